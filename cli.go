@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/astromechza/memory-mouse/internal/archive"
+	"github.com/astromechza/memory-mouse/internal/documents"
+	"github.com/astromechza/memory-mouse/internal/fsck"
+	"github.com/astromechza/memory-mouse/internal/storage"
+	"github.com/astromechza/memory-mouse/internal/storage/storageurl"
+)
+
+// command is an admin subcommand which runs directly against a storage backend rather than starting the server.
+type command struct {
+	// words are the subcommand words, for example "projects list".
+	words   string
+	args    []string
+	summary string
+	// run may register extra flags on the flag set and returns the function to call once the flags are parsed.
+	run func(fs *flag.FlagSet) func(ctx context.Context, c *commandEnv) error
+}
+
+// commandEnv is what a command has available once its flags and arguments are parsed.
+type commandEnv struct {
+	args   []string
+	stdin  io.Reader
+	stdout io.Writer
+	json   bool
+}
+
+// storageFlag is a storage url flag which is required when the command runs.
+type storageFlag struct {
+	name string
+	url  string
+}
+
+// newStorageFlag registers a storage url flag on the flag set.
+func newStorageFlag(fs *flag.FlagSet, name, usage string) *storageFlag {
+	f := &storageFlag{name: name}
+	fs.StringVar(&f.url, name, "", usage+" (sqlite:<connection string> or s3://<bucket>[/<prefix>]?endpoint=&region=&style=&shards=&payload=)")
+	return f
+}
+
+// open opens the storage selected by the flag.
+func (f *storageFlag) open(ctx context.Context) (*storageurl.Backend, error) {
+	if f.url == "" {
+		return nil, fmt.Errorf("-%s is required", f.name)
+	}
+	b, err := storageurl.Open(ctx, f.url)
+	if err != nil {
+		return nil, fmt.Errorf("-%s: %w", f.name, err)
+	}
+	return b, nil
+}
+
+// with opens the storage for the duration of the function.
+func (f *storageFlag) with(ctx context.Context, fn func(s storage.BlobStorage) error) error {
+	b, err := f.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeBackend(b)
+	return fn(b.Storage)
+}
+
+func closeBackend(b *storageurl.Backend) {
+	if err := b.Close(); err != nil {
+		slog.Warn("failed to close storage", slog.Any("err", err.Error()))
+	}
+}
+
+// print writes the value as json if -json is set, otherwise as the lines produced by the text function.
+func (c *commandEnv) print(v any, text func() []string) error {
+	if c.json {
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	for _, line := range text() {
+		if _, err := fmt.Fprintln(c.stdout, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var commands = []command{
+	{
+		words:   "projects list",
+		summary: "list the project ids",
+		run: func(fs *flag.FlagSet) func(ctx context.Context, c *commandEnv) error {
+			storageUrl := newStorageFlag(fs, "storage", "storage url")
+			return func(ctx context.Context, c *commandEnv) error {
+				return storageUrl.with(ctx, func(s storage.BlobStorage) error {
+					ids, err := s.ListProjectIds(ctx)
+					if err != nil {
+						return fmt.Errorf("failed to list projects: %w", err)
+					}
+					return c.print(ids, func() []string { return ids })
+				})
+			}
+		},
+	},
+	{
+		words:   "documents list",
+		args:    []string{"project"},
+		summary: "list the document ids in a project",
+		run: func(fs *flag.FlagSet) func(ctx context.Context, c *commandEnv) error {
+			storageUrl := newStorageFlag(fs, "storage", "storage url")
+			return func(ctx context.Context, c *commandEnv) error {
+				return storageUrl.with(ctx, func(s storage.BlobStorage) error {
+					ids, err := s.ListDocumentIds(ctx, c.args[0])
+					if err != nil {
+						return fmt.Errorf("failed to list documents: %w", err)
+					}
+					// Trashed documents are still listed since their blobs are still stored, but the trash itself
+					// is not a document.
+					ids = slices.DeleteFunc(ids, func(id string) bool {
+						return id == documents.TrashDocumentId
+					})
+					return c.print(ids, func() []string { return ids })
+				})
+			}
+		},
+	},
+	{
+		words:   "blobs list",
+		args:    []string{"project", "document"},
+		summary: "list the blob ids and sizes in a document",
+		run: func(fs *flag.FlagSet) func(ctx context.Context, c *commandEnv) error {
+			storageUrl := newStorageFlag(fs, "storage", "storage url")
+			return func(ctx context.Context, c *commandEnv) error {
+				return storageUrl.with(ctx, func(s storage.BlobStorage) error {
+					blobs, err := s.ListBlobs(ctx, c.args[0], c.args[1])
+					if err != nil {
+						return fmt.Errorf("failed to list blobs: %w", err)
+					}
+					slices.SortFunc(blobs, func(a, b storage.BlobIdAndSize) int {
+						return strings.Compare(a.Id, b.Id)
+					})
+					return c.print(blobs, func() []string {
+						lines := make([]string, len(blobs))
+						for i, b := range blobs {
+							lines[i] = fmt.Sprintf("%s\t%d", b.Id, b.Size)
+						}
+						return lines
+					})
+				})
+			}
+		},
+	},
+	{
+		words:   "blob head",
+		args:    []string{"project", "document", "blob"},
+		summary: "show the size and metadata of a blob",
+		run: func(fs *flag.FlagSet) func(ctx context.Context, c *commandEnv) error {
+			storageUrl := newStorageFlag(fs, "storage", "storage url")
+			return func(ctx context.Context, c *commandEnv) error {
+				return storageUrl.with(ctx, func(s storage.BlobStorage) error {
+					blob, err := s.HeadBlob(ctx, c.args[0], c.args[1], c.args[2])
+					if err != nil {
+						return fmt.Errorf("failed to head blob: %w", err)
+					}
+					return c.print(blob, func() []string { return blobLines(blob) })
+				})
+			}
+		},
+	},
+	{
+		words:   "blob get",
+		args:    []string{"project", "document", "blob"},
+		summary: "write the content of a blob to stdout or a file",
+		run: func(fs *flag.FlagSet) func(ctx context.Context, c *commandEnv) error {
+			storageUrl := newStorageFlag(fs, "storage", "storage url")
+			output := fs.String("o", "", "write the content to this file rather than stdout")
+			return func(ctx context.Context, c *commandEnv) error {
+				return storageUrl.with(ctx, func(s storage.BlobStorage) error {
+					if *output == "" {
+						if _, err := s.GetBlob(ctx, c.args[0], c.args[1], c.args[2], c.stdout); err != nil {
+							return fmt.Errorf("failed to get blob: %w", err)
+						}
+						return nil
+					}
+					f, err := os.Create(*output)
+					if err != nil {
+						return fmt.Errorf("failed to create output file: %w", err)
+					}
+					if _, err := s.GetBlob(ctx, c.args[0], c.args[1], c.args[2], f); err != nil {
+						_ = f.Close()
+						return fmt.Errorf("failed to get blob: %w", err)
+					}
+					return f.Close()
+				})
+			}
+		},
+	},
+	{
+		words:   "migrate",
+		summary: "copy and verify the blobs from one storage backend to another",
+		run:     migrateCommand,
+	},
+	{
+		words:   "export",
+		summary: "write documents and their blobs to a tar archive",
+		run: func(fs *flag.FlagSet) func(ctx context.Context, c *commandEnv) error {
+			storageUrl := newStorageFlag(fs, "storage", "storage url")
+			projectId := fs.String("project", "", "project to export from")
+			var documentIds stringsFlag
+			fs.Var(&documentIds, "document", "only export these documents, may be repeated or comma separated")
+			output := fs.String("o", "", "write the archive to this file rather than stdout")
+			return func(ctx context.Context, c *commandEnv) error {
+				if *projectId == "" {
+					return fmt.Errorf("-project is required")
+				}
+				return storageUrl.with(ctx, func(s storage.BlobStorage) error {
+					if *output == "" {
+						manifests, err := archive.Export(ctx, s, c.stdout, *projectId, documentIds...)
+						if err != nil {
+							return err
+						}
+						slog.Info("exported documents", slog.Int("documents", len(manifests)))
+						return nil
+					}
+					f, err := os.Create(*output)
+					if err != nil {
+						return fmt.Errorf("failed to create output file: %w", err)
+					}
+					manifests, err := archive.Export(ctx, s, f, *projectId, documentIds...)
+					if err = errors.Join(err, f.Close()); err != nil {
+						return err
+					}
+					return c.print(manifests, func() []string { return manifestLines(manifests) })
+				})
+			}
+		},
+	},
+	{
+		words:   "import",
+		summary: "write the documents from a tar archive to storage",
+		run: func(fs *flag.FlagSet) func(ctx context.Context, c *commandEnv) error {
+			storageUrl := newStorageFlag(fs, "storage", "storage url")
+			var opts archive.ImportOptions
+			fs.StringVar(&opts.ProjectId, "project", "", "import into this project rather than the project in the archive")
+			fs.BoolVar(&opts.Overwrite, "overwrite", false, "overwrite documents that already exist")
+			input := fs.String("i", "", "read the archive from this file rather than stdin")
+			return func(ctx context.Context, c *commandEnv) error {
+				src := c.stdin
+				if *input != "" {
+					f, err := os.Open(*input)
+					if err != nil {
+						return fmt.Errorf("failed to open input file: %w", err)
+					}
+					defer f.Close()
+					src = f
+				}
+				return storageUrl.with(ctx, func(s storage.BlobStorage) error {
+					manifests, err := archive.Import(ctx, s, src, opts)
+					if perr := c.print(manifests, func() []string { return manifestLines(manifests) }); perr != nil {
+						return errors.Join(err, perr)
+					}
+					return err
+				})
+			}
+		},
+	},
+	{
+		words:   "fsck",
+		summary: "check documents for inconsistent chunks and optionally repair them",
+		run: func(fs *flag.FlagSet) func(ctx context.Context, c *commandEnv) error {
+			storageUrl := newStorageFlag(fs, "storage", "storage url")
+			var opts fsck.Options
+			fs.BoolVar(&opts.Repair, "repair", false, "delete covered chunks and the abandoned chunks of documents without valid chunks")
+			fs.Var((*stringsFlag)(&opts.Projects), "project", "only check these projects, may be repeated or comma separated")
+			fs.Var((*stringsFlag)(&opts.Documents), "document", "only check these documents, may be repeated or comma separated")
+			return func(ctx context.Context, c *commandEnv) error {
+				return storageUrl.with(ctx, func(s storage.BlobStorage) error {
+					problems := make([]fsck.Problem, 0)
+					checked, err := fsck.Check(ctx, s, opts, func(p fsck.Problem) {
+						problems = append(problems, p)
+					})
+					unrepaired := 0
+					for _, p := range problems {
+						if !p.Repaired {
+							unrepaired++
+						}
+					}
+					if perr := c.print(problems, func() []string {
+						lines := make([]string, 0, len(problems)+1)
+						for _, p := range problems {
+							line := fmt.Sprintf("%s\t%s\t%s\t%s\t%s", p.ProjectId, p.DocumentId, p.BlobId, p.Kind, p.Detail)
+							if p.Repaired {
+								line += "\trepaired"
+							}
+							lines = append(lines, line)
+						}
+						return append(lines, fmt.Sprintf("checked %d documents, found %d problems, %d unrepaired", checked, len(problems), unrepaired))
+					}); perr != nil {
+						return errors.Join(err, perr)
+					} else if err != nil {
+						return err
+					} else if unrepaired > 0 {
+						return fmt.Errorf("found %d unrepaired problems", unrepaired)
+					}
+					return nil
+				})
+			}
+		},
+	},
+}
+
+// manifestLines formats the manifests as tab separated project, document, and blob count lines.
+func manifestLines(manifests []*archive.Manifest) []string {
+	lines := make([]string, len(manifests))
+	for i, m := range manifests {
+		lines[i] = fmt.Sprintf("%s\t%s\t%d", m.ProjectId, m.DocumentId, len(m.Blobs))
+	}
+	return lines
+}
+
+// blobLines formats the blob as tab separated lines with the metadata keys sorted.
+func blobLines(blob *storage.BlobIdSizeAndMeta) []string {
+	lines := []string{"id\t" + blob.Id, fmt.Sprintf("size\t%d", blob.Size)}
+	for _, k := range slices.Sorted(maps.Keys(blob.Metadata)) {
+		lines = append(lines, "meta."+k+"\t"+blob.Metadata[k])
+	}
+	return lines
+}
+
+// isCommand returns true if the arguments select a subcommand rather than the server flags.
+func isCommand(args []string) bool {
+	return len(args) > 1 && !strings.HasPrefix(args[1], "-")
+}
+
+// errUsage is returned when the usage has already been printed.
+var errUsage = errors.New("invalid usage")
+
+func printUsage(w io.Writer, name string) {
+	_, _ = fmt.Fprintln(w, "Usage:")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "  %s [flags]\trun the server\n", name)
+	for _, c := range commands {
+		usage := c.words
+		for _, a := range c.args {
+			usage += " <" + a + ">"
+		}
+		_, _ = fmt.Fprintf(tw, "  %s %s [flags]\t%s\n", name, usage, c.summary)
+	}
+	_ = tw.Flush()
+	_, _ = fmt.Fprintf(w, "\nRun '%s <command> -h' for the flags of a command.\n", name)
+}
+
+// runCommand finds and runs the subcommand selected by the arguments. The flags come after the subcommand words and
+// can be mixed with the positional arguments.
+func runCommand(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	name := args[0]
+	for _, c := range commands {
+		words := strings.Fields(c.words)
+		if len(args) < len(words)+1 || !slices.Equal(args[1:len(words)+1], words) {
+			continue
+		}
+		fs := flag.NewFlagSet(name+" "+c.words, flag.ContinueOnError)
+		fs.SetOutput(stderr)
+		env := &commandEnv{stdin: stdin, stdout: stdout}
+		fs.BoolVar(&env.json, "json", false, "write the output as json")
+		run := c.run(fs)
+		rest := args[len(words)+1:]
+		for {
+			if err := fs.Parse(rest); err != nil {
+				return fmt.Errorf("failed to parse flags: %w", err)
+			}
+			if fs.NArg() == 0 {
+				break
+			}
+			env.args = append(env.args, fs.Arg(0))
+			rest = fs.Args()[1:]
+		}
+		if len(env.args) != len(c.args) {
+			return fmt.Errorf("%s expects %d arguments (%s) but got %d", c.words, len(c.args), strings.Join(c.args, ", "), len(env.args))
+		}
+		return run(ctx, env)
+	}
+	if args[1] == "help" {
+		printUsage(stdout, name)
+		return nil
+	}
+	printUsage(stderr, name)
+	return fmt.Errorf("unknown command '%s': %w", strings.Join(args[1:], " "), errUsage)
+}
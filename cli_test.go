@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/astromechza/memory-mouse/internal/documents"
+	"github.com/astromechza/memory-mouse/internal/storage/storageurl"
+	"github.com/astromechza/memory-mouse/internal/testsupport"
+)
+
+// newCommandStorage returns a storage url for an in-memory sqlite database with a single blob. The returned backend
+// must stay open for the duration of the test so that the database is not discarded.
+func newCommandStorage(t *testing.T) string {
+	u := "sqlite:file:" + strings.ReplaceAll(t.Name(), "/", "_") + ".db?mode=memory&cache=shared"
+	b, err := storageurl.Open(context.Background(), u)
+	testsupport.MustAssertEqual(t, err, nil)
+	t.Cleanup(func() {
+		_ = b.Close()
+	})
+	testsupport.MustAssertEqual(t, b.Storage.PutBlob(context.Background(), "p", "d", "00000000001", map[string]string{"b": "2", "a": "1"}, []byte("hello")), nil)
+	return u
+}
+
+func runTestCommand(args ...string) (string, string, error) {
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	err := runCommand(context.Background(), append([]string{"mm"}, args...), strings.NewReader(""), stdout, stderr)
+	return stdout.String(), stderr.String(), err
+}
+
+func TestRunCommand(t *testing.T) {
+	u := newCommandStorage(t)
+	for _, tc := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"projects", "list", "-storage", u}, "p\n"},
+		{[]string{"projects", "list", "-storage", u, "-json"}, "[\n  \"p\"\n]\n"},
+		{[]string{"documents", "list", "-storage", u, "p"}, "d\n"},
+		{[]string{"documents", "list", "p", "-storage", u}, "d\n"},
+		{[]string{"blobs", "list", "-storage", u, "p", "d"}, "00000000001\t5\n"},
+		{[]string{"blob", "head", "-storage", u, "p", "d", "00000000001"}, "id\t00000000001\nsize\t5\nmeta.a\t1\nmeta.b\t2\n"},
+		{[]string{"blob", "get", "-storage", u, "p", "d", "00000000001"}, "hello"},
+	} {
+		t.Run(strings.Join(tc.args[:2], " "), func(t *testing.T) {
+			stdout, _, err := runTestCommand(tc.args...)
+			testsupport.AssertEqual(t, err, nil)
+			testsupport.AssertEqual(t, stdout, tc.expected)
+		})
+	}
+}
+
+func TestRunCommand_documents_list_trash(t *testing.T) {
+	u := newCommandStorage(t)
+	b, err := storageurl.Open(context.Background(), u)
+	testsupport.MustAssertEqual(t, err, nil)
+	defer b.Close()
+	testsupport.MustAssertEqual(t, b.Storage.PutBlob(context.Background(), "p", "e", "00000000001", nil, []byte("bye")), nil)
+	testsupport.MustAssertEqual(t, documents.Trash(context.Background(), b.Storage, "p", "e", time.Now()), nil)
+	// The trashed document is still stored, but the trash itself is hidden.
+	stdout, _, err := runTestCommand("documents", "list", "-storage", u, "p")
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, stdout, "d\ne\n")
+}
+
+func TestRunCommand_blob_get_file(t *testing.T) {
+	u := newCommandStorage(t)
+	out := filepath.Join(t.TempDir(), "blob")
+	stdout, _, err := runTestCommand("blob", "get", "-storage", u, "-o", out, "p", "d", "00000000001")
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, stdout, "")
+	content, err := os.ReadFile(out)
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, string(content), "hello")
+}
+
+func TestRunCommand_errors(t *testing.T) {
+	u := newCommandStorage(t)
+	_, _, err := runTestCommand("projects", "list")
+	testsupport.AssertErrorEqual(t, err, "-storage is required")
+	_, _, err = runTestCommand("documents", "list", "-storage", u)
+	testsupport.AssertErrorEqual(t, err, "documents list expects 1 arguments (project) but got 0")
+	_, _, err = runTestCommand("blob", "head", "-storage", u, "p", "d", "missing")
+	testsupport.AssertErrorEqual(t, err, "failed to head blob: blob not found")
+	_, stderr, err := runTestCommand("frobnicate")
+	testsupport.AssertErrorEqual(t, err, "unknown command 'frobnicate': invalid usage")
+	testsupport.AssertEqual(t, strings.Contains(stderr, "blobs list <project> <document> [flags]"), true)
+	stdout, _, err := runTestCommand("help")
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, strings.HasPrefix(stdout, "Usage:\n"), true)
+	testsupport.AssertEqual(t, isCommand([]string{"mm", "-address", ":8080"}), false)
+	testsupport.AssertEqual(t, isCommand([]string{"mm"}), false)
+	testsupport.AssertEqual(t, isCommand([]string{"mm", "projects"}), true)
+}
+
+func TestRunCommand_export_import(t *testing.T) {
+	u := newCommandStorage(t)
+	out := filepath.Join(t.TempDir(), "export.tar")
+	stdout, _, err := runTestCommand("export", "-storage", u, "-project", "p", "-o", out)
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, stdout, "p\td\t1\n")
+
+	to := "sqlite:file:" + t.Name() + "-to.db?mode=memory&cache=shared"
+	b, err := storageurl.Open(context.Background(), to)
+	testsupport.MustAssertEqual(t, err, nil)
+	defer b.Close()
+	stdout, _, err = runTestCommand("import", "-storage", to, "-project", "q", "-i", out)
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, stdout, "q\td\t1\n")
+	_, _, err = runTestCommand("import", "-storage", to, "-project", "q", "-i", out)
+	testsupport.AssertErrorEqual(t, err, "failed to import q/d: document already exists")
+	_, _, err = runTestCommand("export", "-storage", u)
+	testsupport.AssertErrorEqual(t, err, "-project is required")
+}
+
+func TestRunCommand_fsck(t *testing.T) {
+	// the blob written by newCommandStorage is not an automerge chunk
+	u := newCommandStorage(t)
+	stdout, _, err := runTestCommand("fsck", "-storage", u)
+	testsupport.AssertErrorEqual(t, err, "found 2 unrepaired problems")
+	testsupport.AssertEqual(t, stdout, "p\td\t00000000001\tunparsable-chunk\tmissing automerge magic number\n"+
+		"p\td\t\tno-valid-chunks\tnone of the 1 blobs are valid chunks\n"+
+		"checked 1 documents, found 2 problems, 2 unrepaired\n")
+	stdout, _, err = runTestCommand("fsck", "-storage", u, "-repair")
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, strings.HasSuffix(stdout, "checked 1 documents, found 2 problems, 0 unrepaired\n"), true)
+	stdout, _, err = runTestCommand("fsck", "-storage", u)
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, stdout, "checked 0 documents, found 0 problems, 0 unrepaired\n")
+}
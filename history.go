@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/astromechza/memory-mouse/internal/documents"
+	"github.com/astromechza/memory-mouse/internal/storage"
+	"github.com/astromechza/memory-mouse/internal/uid"
+)
+
+// historyRoutes registers the handlers for the document history and named snapshots.
+func (s *server) historyRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /documents/{id}/history", func(writer http.ResponseWriter, request *http.Request) {
+		projectId, ok := projectIdFromHeader(writer, request)
+		if !ok {
+			return
+		}
+		documentId, ok := documentIdFromPath(writer, request)
+		if !ok {
+			return
+		}
+		if !s.checkNotTrashed(writer, request, projectId, documentId) {
+			return
+		}
+		h, ok := s.loadHistory(writer, request, projectId, documentId)
+		if !ok {
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(writer).Encode(h)
+	})
+
+	// The content is the concatenation of the chunks as of the latest chunk, or the chunk, time, or snapshot given in
+	// the query, which can be loaded directly as an automerge document.
+	mux.HandleFunc("GET /documents/{id}/content", func(writer http.ResponseWriter, request *http.Request) {
+		projectId, ok := projectIdFromHeader(writer, request)
+		if !ok {
+			return
+		}
+		documentId, ok := documentIdFromPath(writer, request)
+		if !ok {
+			return
+		}
+		if !s.checkNotTrashed(writer, request, projectId, documentId) {
+			return
+		}
+		h, ok := s.loadHistory(writer, request, projectId, documentId)
+		if !ok {
+			return
+		}
+		at, err := resolveHistoryChunk(h, request)
+		if err != nil {
+			writeHistoryError(writer, documentId, err)
+			return
+		}
+		s.writeContent(writer, request, projectId, documentId, h, at)
+	})
+
+	// A fork copies the state at the same chunk, time, or snapshot query parameters as the content into a new document,
+	// in the project given by the project query parameter or the current project. Another project must be writable by
+	// the caller, see projectWritable.
+	mux.HandleFunc("POST /documents/{id}/fork", func(writer http.ResponseWriter, request *http.Request) {
+		projectId, ok := projectIdFromHeader(writer, request)
+		if !ok {
+			return
+		}
+		documentId, ok := documentIdFromPath(writer, request)
+		if !ok {
+			return
+		}
+		if !s.checkNotTrashed(writer, request, projectId, documentId) {
+			return
+		}
+		destinationProjectId := projectId
+		if query := request.URL.Query(); query.Has("project") {
+			if destinationProjectId = query.Get("project"); destinationProjectId == "" {
+				http.Error(writer, "invalid empty project", http.StatusBadRequest)
+				return
+			} else if !projectWritable(request, destinationProjectId) {
+				http.Error(writer, fmt.Sprintf("cannot fork into project '%s' from project '%s'", destinationProjectId, projectId), http.StatusForbidden)
+				return
+			}
+		}
+		h, ok := s.loadHistory(writer, request, projectId, documentId)
+		if !ok {
+			return
+		}
+		at, err := resolveHistoryChunk(h, request)
+		if err != nil {
+			writeHistoryError(writer, documentId, err)
+			return
+		}
+		fork, err := documents.CreateFork(request.Context(), s.storage, projectId, documentId, h, at, destinationProjectId, request.Header.Get(userIdHeader), time.Now())
+		if err != nil {
+			writeHistoryError(writer, documentId, err)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Header().Set("Location", "/documents/"+fork.DocumentId)
+		writer.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(writer).Encode(fork)
+	})
+
+	mux.HandleFunc("POST /documents/{id}/snapshots", func(writer http.ResponseWriter, request *http.Request) {
+		projectId, ok := projectIdFromHeader(writer, request)
+		if !ok {
+			return
+		}
+		documentId, ok := documentIdFromPath(writer, request)
+		if !ok {
+			return
+		}
+		if !s.checkNotTrashed(writer, request, projectId, documentId) {
+			return
+		}
+		var body struct {
+			Name    string `json:"name"`
+			ChunkId string `json:"chunkId"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(writer, request.Body, 4096)).Decode(&body); err != nil {
+			http.Error(writer, fmt.Sprintf("invalid request body: %s", err), http.StatusBadRequest)
+			return
+		}
+		var at *uid.ChunkId
+		if body.ChunkId != "" {
+			c, err := uid.ParseChunkId(body.ChunkId)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+			at = &c
+		}
+		snapshot, err := documents.CreateSnapshot(request.Context(), s.storage, projectId, documentId, body.Name, at, time.Now())
+		if err != nil {
+			writeHistoryError(writer, documentId, err)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(writer).Encode(snapshot)
+	})
+
+	mux.HandleFunc("DELETE /documents/{id}/snapshots/{name}", func(writer http.ResponseWriter, request *http.Request) {
+		projectId, ok := projectIdFromHeader(writer, request)
+		if !ok {
+			return
+		}
+		documentId, ok := documentIdFromPath(writer, request)
+		if !ok {
+			return
+		}
+		if !s.checkNotTrashed(writer, request, projectId, documentId) {
+			return
+		}
+		if err := documents.DeleteSnapshot(request.Context(), s.storage, projectId, documentId, request.PathValue("name")); err != nil {
+			writeHistoryError(writer, documentId, err)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (s *server) loadHistory(writer http.ResponseWriter, request *http.Request, projectId, documentId string) (*documents.History, bool) {
+	h, err := documents.LoadHistory(request.Context(), s.storage, projectId, documentId)
+	if err != nil {
+		writeHistoryError(writer, documentId, err)
+		return nil, false
+	}
+	return h, true
+}
+
+// writeContent writes the state of the document as of the chunk, with the chunk id in the X-Chunk-Id header.
+func (s *server) writeContent(writer http.ResponseWriter, request *http.Request, projectId, documentId string, h *documents.History, at uid.ChunkId) {
+	// The content is buffered so that errors can still be returned as a status code.
+	buff := new(bytes.Buffer)
+	if err := documents.WriteAsOf(request.Context(), s.storage, projectId, documentId, h, at, buff); err != nil {
+		writeHistoryError(writer, documentId, err)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/octet-stream")
+	writer.Header().Set("X-Chunk-Id", at.String())
+	_, _ = writer.Write(buff.Bytes())
+}
+
+// resolveHistoryChunk returns the chunk selected by at most one of the chunk, at, or snapshot query parameters,
+// defaulting to the latest chunk.
+func resolveHistoryChunk(h *documents.History, request *http.Request) (uid.ChunkId, error) {
+	query := request.URL.Query()
+	var set int
+	for _, k := range []string{"chunk", "at", "snapshot"} {
+		if query.Has(k) {
+			set++
+		}
+	}
+	if set > 1 {
+		return uid.ChunkId{}, errInvalidHistoryQuery
+	} else if raw := query.Get("chunk"); raw != "" {
+		c, err := uid.ParseChunkId(raw)
+		if err != nil {
+			return uid.ChunkId{}, fmt.Errorf("%w: %w", errInvalidHistoryQuery, err)
+		}
+		return c, nil
+	} else if raw := query.Get("at"); raw != "" {
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return uid.ChunkId{}, fmt.Errorf("%w: invalid time '%s'", errInvalidHistoryQuery, raw)
+		}
+		return h.ChunkAsOf(t)
+	} else if raw := query.Get("snapshot"); raw != "" {
+		snapshot, err := h.Snapshot(raw)
+		if err != nil {
+			return uid.ChunkId{}, err
+		}
+		return uid.ParseChunkId(snapshot.ChunkId)
+	}
+	return h.Latest()
+}
+
+var errInvalidHistoryQuery = errors.New("expected at most one of the chunk, at, or snapshot query parameters")
+
+// writeHistoryError maps the errors of the history operations to status codes.
+func writeHistoryError(writer http.ResponseWriter, documentId string, err error) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, storage.ErrDocumentNotFound):
+		http.Error(writer, "document not found", http.StatusNotFound)
+	case errors.Is(err, documents.ErrSnapshotNotFound):
+		http.Error(writer, err.Error(), http.StatusNotFound)
+	case errors.Is(err, documents.ErrSnapshotExists):
+		http.Error(writer, err.Error(), http.StatusConflict)
+	case errors.Is(err, documents.ErrHistoryUnavailable):
+		http.Error(writer, err.Error(), http.StatusGone)
+	case errors.Is(err, documents.ErrInvalidSnapshotName), errors.Is(err, errInvalidHistoryQuery), errors.As(err, &maxBytesErr):
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+	default:
+		slog.Error("failed to read document history", slog.String("document", documentId), slog.Any("err", err.Error()))
+		http.Error(writer, "failed to read document history", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/astromechza/memory-mouse/internal/documents"
+	"github.com/astromechza/memory-mouse/internal/storage/sqlite"
+	"github.com/astromechza/memory-mouse/internal/testsupport"
+	"github.com/astromechza/memory-mouse/internal/uid"
+)
+
+func TestHistoryRoutes(t *testing.T) {
+	inner, err := sqlite.New(context.Background(), "file:history.db?mode=memory&cache=shared", 0)
+	testsupport.MustAssertEqual(t, err, nil)
+	defer inner.Close()
+	srv := &server{storage: inner}
+	mux := http.NewServeMux()
+	srv.routes(mux)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(projectIdHeader, "p1")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	documentId := uid.DocumentUid()
+	testsupport.MustAssertEqual(t, inner.PutBlob(context.Background(), "p1", documentId, "00000000001", map[string]string{documents.WrittenAtMetadataKey: "2024-01-02T00:00:00Z"}, []byte("a")), nil)
+	testsupport.MustAssertEqual(t, inner.PutBlob(context.Background(), "p1", documentId, "00000000002", map[string]string{documents.WrittenAtMetadataKey: "2024-01-03T00:00:00Z"}, []byte("b")), nil)
+
+	rec := do(http.MethodGet, "/documents/"+uid.DocumentUid()+"/history", "")
+	testsupport.AssertEqual(t, rec.Code, http.StatusNotFound)
+	rec = do(http.MethodGet, "/documents/"+documentId+"/history", "")
+	testsupport.AssertEqual(t, rec.Code, http.StatusOK)
+	testsupport.AssertEqual(t, rec.Body.String(), `{"chunks":[{"id":"00000000001","size":1,"writtenAt":"2024-01-02T00:00:00Z"},{"id":"00000000002","size":1,"writtenAt":"2024-01-03T00:00:00Z"}],"snapshots":[]}`+"\n")
+
+	for _, tc := range []struct {
+		query    string
+		code     int
+		expected string
+	}{
+		{"", http.StatusOK, "ab"},
+		{"?chunk=00000000001", http.StatusOK, "a"},
+		{"?at=2024-01-02T12:00:00Z", http.StatusOK, "a"},
+		{"?at=2024-01-01T00:00:00Z", http.StatusGone, "history is not available: no chunks were written at or before 2024-01-01T00:00:00Z\n"},
+		{"?chunk=00000000001&at=2024-01-02T12:00:00Z", http.StatusBadRequest, "expected at most one of the chunk, at, or snapshot query parameters\n"},
+		{"?snapshot=missing", http.StatusNotFound, "snapshot not found: 'missing'\n"},
+	} {
+		rec = do(http.MethodGet, "/documents/"+documentId+"/content"+tc.query, "")
+		testsupport.AssertEqual(t, rec.Code, tc.code)
+		testsupport.AssertEqual(t, rec.Body.String(), tc.expected)
+	}
+
+	rec = do(http.MethodPost, "/documents/"+documentId+"/snapshots", `{"name":"first","chunkId":"00000000001"}`)
+	testsupport.AssertEqual(t, rec.Code, http.StatusCreated)
+	rec = do(http.MethodPost, "/documents/"+documentId+"/snapshots", `{"name":"first"}`)
+	testsupport.AssertEqual(t, rec.Code, http.StatusConflict)
+	rec = do(http.MethodPost, "/documents/"+documentId+"/snapshots", `{"name":""}`)
+	testsupport.AssertEqual(t, rec.Code, http.StatusBadRequest)
+
+	rec = do(http.MethodGet, "/documents/"+documentId+"/content?snapshot=first", "")
+	testsupport.AssertEqual(t, rec.Code, http.StatusOK)
+	testsupport.AssertEqual(t, rec.Header().Get("X-Chunk-Id"), "00000000001")
+	testsupport.AssertEqual(t, rec.Body.String(), "a")
+
+	// The fork is written into another project when the caller may write to it.
+	req := httptest.NewRequest(http.MethodPost, "/documents/"+documentId+"/fork?snapshot=first&project=p2", nil)
+	req.Header.Set(projectIdHeader, "p1")
+	req.Header.Set(writableProjectIdsHeader, "p3, p2")
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	testsupport.MustAssertEqual(t, rec.Code, http.StatusCreated)
+	var fork documents.Fork
+	testsupport.MustAssertEqual(t, json.Unmarshal(rec.Body.Bytes(), &fork), nil)
+	testsupport.AssertEqual(t, rec.Header().Get("Location"), "/documents/"+fork.DocumentId)
+	testsupport.AssertEqual(t, fork.ProjectId, "p2")
+	testsupport.AssertEqual(t, fork.ForkedFrom, documents.ForkSource{ProjectId: "p1", DocumentId: documentId, ChunkId: "00000000001"})
+	buff := new(bytes.Buffer)
+	_, err = inner.GetBlob(context.Background(), "p2", fork.DocumentId, "00000000001", buff)
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, buff.String(), "a")
+
+	rec = do(http.MethodPost, "/documents/"+documentId+"/fork?project=p1", "")
+	testsupport.MustAssertEqual(t, rec.Code, http.StatusCreated)
+	testsupport.MustAssertEqual(t, json.Unmarshal(rec.Body.Bytes(), &fork), nil)
+	testsupport.AssertEqual(t, fork.ProjectId, "p1")
+
+	// Forking into a project that the caller may not write to is rejected without writing anything.
+	rec = do(http.MethodPost, "/documents/"+documentId+"/fork?project=p4", "")
+	testsupport.AssertEqual(t, rec.Code, http.StatusForbidden)
+	testsupport.AssertEqual(t, rec.Body.String(), "cannot fork into project 'p4' from project 'p1'\n")
+	ids, err := inner.ListDocumentIds(context.Background(), "p4")
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, len(ids), 0)
+	rec = do(http.MethodPost, "/documents/"+documentId+"/fork?project=", "")
+	testsupport.AssertEqual(t, rec.Code, http.StatusBadRequest)
+	rec = do(http.MethodPost, "/documents/"+uid.DocumentUid()+"/fork", "")
+	testsupport.AssertEqual(t, rec.Code, http.StatusNotFound)
+
+	rec = do(http.MethodDelete, "/documents/"+documentId+"/snapshots/first", "")
+	testsupport.AssertEqual(t, rec.Code, http.StatusNoContent)
+	rec = do(http.MethodDelete, "/documents/"+documentId+"/snapshots/first", "")
+	testsupport.AssertEqual(t, rec.Code, http.StatusNotFound)
+}
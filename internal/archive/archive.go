@@ -0,0 +1,326 @@
+// Package archive implements a portable tar archive of documents which can be used to move documents between
+// environments or hand them to customers. Each document is stored as a manifest followed by its blobs:
+//
+//	<project>/<document>/manifest.json
+//	<project>/<document>/blobs/<blob>
+//	...
+//
+// The path elements are url path escaped, while the manifest holds the real ids along with the size, sha256 checksum,
+// and metadata of every blob. The blobs follow the manifest in the same order, so an archive can be read and verified
+// as a stream while only holding one document in memory at a time.
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/astromechza/memory-mouse/internal/documents"
+	"github.com/astromechza/memory-mouse/internal/storage"
+)
+
+// ErrInvalidArchive is returned when the archive is malformed or the content doesn't match the manifest.
+var ErrInvalidArchive = errors.New("invalid archive")
+
+// ErrDocumentExists is returned by Import when a document already exists and overwriting is not enabled.
+var ErrDocumentExists = errors.New("document already exists")
+
+// Manifest describes a single document in the archive.
+type Manifest struct {
+	ProjectId  string         `json:"projectId"`
+	DocumentId string         `json:"documentId"`
+	Blobs      []ManifestBlob `json:"blobs"`
+}
+
+type ManifestBlob struct {
+	Id       string            `json:"id"`
+	Size     int64             `json:"size"`
+	Sha256   string            `json:"sha256"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Document is a manifest with the content of each blob in the same order.
+type Document struct {
+	Manifest
+	Contents [][]byte
+}
+
+// maxManifestSize bounds the manifest entry, which is read fully into memory before the blobs are checked.
+const maxManifestSize = 16 << 20
+
+func documentDir(projectId, documentId string) string {
+	return url.PathEscape(projectId) + "/" + url.PathEscape(documentId) + "/"
+}
+
+func manifestName(projectId, documentId string) string {
+	return documentDir(projectId, documentId) + "manifest.json"
+}
+
+func blobName(projectId, documentId, blobId string) string {
+	return documentDir(projectId, documentId) + "blobs/" + url.PathEscape(blobId)
+}
+
+// LoadDocument reads all the blobs of the document into memory. This returns storage.ErrDocumentNotFound if the
+// document has no blobs.
+func LoadDocument(ctx context.Context, s storage.BlobStorage, projectId, documentId string) (*Document, error) {
+	blobs, err := s.ListBlobs(ctx, projectId, documentId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blobs: %w", err)
+	}
+	slices.SortFunc(blobs, func(a, b storage.BlobIdAndSize) int {
+		return strings.Compare(a.Id, b.Id)
+	})
+	doc := &Document{Manifest: Manifest{ProjectId: projectId, DocumentId: documentId}}
+	for _, b := range blobs {
+		buff := new(bytes.Buffer)
+		blob, err := s.GetBlob(ctx, projectId, documentId, b.Id, buff)
+		if errors.Is(err, storage.ErrBlobNotFound) {
+			// The blob was removed since it was listed, for example by compaction.
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to get blob %s: %w", b.Id, err)
+		}
+		sum := sha256.Sum256(buff.Bytes())
+		doc.Blobs = append(doc.Blobs, ManifestBlob{Id: b.Id, Size: int64(buff.Len()), Sha256: hex.EncodeToString(sum[:]), Metadata: blob.Metadata})
+		doc.Contents = append(doc.Contents, buff.Bytes())
+	}
+	if len(doc.Blobs) == 0 {
+		return nil, fmt.Errorf("failed to load document: %w", storage.ErrDocumentNotFound)
+	}
+	return doc, nil
+}
+
+// Writer writes documents to a tar archive.
+type Writer struct {
+	tw    *tar.Writer
+	clock func() time.Time
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{tw: tar.NewWriter(w), clock: time.Now}
+}
+
+// Add writes the manifest and blobs of the document.
+func (w *Writer) Add(doc *Document) error {
+	manifest, err := json.MarshalIndent(&doc.Manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := w.writeEntry(manifestName(doc.ProjectId, doc.DocumentId), manifest); err != nil {
+		return err
+	}
+	for i, b := range doc.Blobs {
+		if err := w.writeEntry(blobName(doc.ProjectId, doc.DocumentId, b.Id), doc.Contents[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Writer) writeEntry(name string, content []byte) error {
+	if err := w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     int64(len(content)),
+		Mode:     0o644,
+		ModTime:  w.clock(),
+		Format:   tar.FormatPAX,
+	}); err != nil {
+		return fmt.Errorf("failed to write header for %s: %w", name, err)
+	}
+	if _, err := w.tw.Write(content); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// Close finishes the archive, it does not close the underlying writer.
+func (w *Writer) Close() error {
+	return w.tw.Close()
+}
+
+// Export loads each document and writes it to the archive. If no document ids are given, all the documents in the
+// project that are not trashed are exported.
+func Export(ctx context.Context, s storage.BlobStorage, dst io.Writer, projectId string, documentIds ...string) ([]*Manifest, error) {
+	if len(documentIds) == 0 {
+		var err error
+		if documentIds, err = documents.ListDocumentIds(ctx, s, projectId); err != nil {
+			return nil, err
+		}
+	}
+	w := NewWriter(dst)
+	manifests := make([]*Manifest, 0, len(documentIds))
+	for _, documentId := range documentIds {
+		doc, err := LoadDocument(ctx, s, projectId, documentId)
+		if err != nil {
+			return manifests, fmt.Errorf("failed to export %s: %w", documentId, err)
+		} else if err := w.Add(doc); err != nil {
+			return manifests, fmt.Errorf("failed to export %s: %w", documentId, err)
+		}
+		manifests = append(manifests, &doc.Manifest)
+	}
+	if err := w.Close(); err != nil {
+		return manifests, fmt.Errorf("failed to close archive: %w", err)
+	}
+	return manifests, nil
+}
+
+// Reader reads and verifies documents from a tar archive.
+type Reader struct {
+	tr *tar.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{tr: tar.NewReader(r)}
+}
+
+// nextEntry reads the next regular file entry. If the expected name is empty, any name is accepted and io.EOF is
+// returned at the end of the archive.
+func (r *Reader) nextEntry(expectedName string, maxSize int64) (string, []byte, error) {
+	h, err := r.tr.Next()
+	if errors.Is(err, io.EOF) && expectedName == "" {
+		return "", nil, io.EOF
+	} else if errors.Is(err, io.EOF) {
+		return "", nil, fmt.Errorf("%w: missing entry %s", ErrInvalidArchive, expectedName)
+	} else if err != nil {
+		return "", nil, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+	} else if h.Typeflag != tar.TypeReg {
+		return "", nil, fmt.Errorf("%w: unexpected entry type for %s", ErrInvalidArchive, h.Name)
+	} else if expectedName != "" && h.Name != expectedName {
+		return "", nil, fmt.Errorf("%w: expected entry %s but got %s", ErrInvalidArchive, expectedName, h.Name)
+	} else if h.Size > maxSize {
+		return "", nil, fmt.Errorf("%w: entry %s is %d bytes, expected at most %d", ErrInvalidArchive, h.Name, h.Size, maxSize)
+	}
+	content, err := io.ReadAll(r.tr)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: failed to read %s: %w", ErrInvalidArchive, h.Name, err)
+	}
+	return h.Name, content, nil
+}
+
+// Next reads the next document and verifies the size and checksum of each blob against the manifest. This returns
+// io.EOF once there are no more documents.
+func (r *Reader) Next() (*Document, error) {
+	name, raw, err := r.nextEntry("", maxManifestSize)
+	if err != nil {
+		return nil, err
+	}
+	doc := new(Document)
+	if err := json.Unmarshal(raw, &doc.Manifest); err != nil {
+		return nil, fmt.Errorf("%w: failed to decode manifest: %w", ErrInvalidArchive, err)
+	} else if doc.ProjectId == "" || doc.DocumentId == "" || len(doc.Blobs) == 0 {
+		return nil, fmt.Errorf("%w: manifest must have a project id, document id, and at least one blob", ErrInvalidArchive)
+	}
+	if expected := manifestName(doc.ProjectId, doc.DocumentId); name != expected {
+		return nil, fmt.Errorf("%w: expected entry %s but got %s", ErrInvalidArchive, expected, name)
+	}
+	for _, b := range doc.Blobs {
+		_, content, err := r.nextEntry(blobName(doc.ProjectId, doc.DocumentId, b.Id), b.Size)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(content)
+		if int64(len(content)) != b.Size {
+			return nil, fmt.Errorf("%w: blob %s is %d bytes but the manifest has %d", ErrInvalidArchive, b.Id, len(content), b.Size)
+		} else if hex.EncodeToString(sum[:]) != b.Sha256 {
+			return nil, fmt.Errorf("%w: blob %s has checksum %x but the manifest has %s", ErrInvalidArchive, b.Id, sum, b.Sha256)
+		}
+		doc.Contents = append(doc.Contents, content)
+	}
+	return doc, nil
+}
+
+// ImportOptions control how documents are written by Import.
+type ImportOptions struct {
+	// ProjectId, if set, replaces the project id of every document in the archive.
+	ProjectId string
+	// Overwrite allows documents that already exist to be overwritten. The blobs in the archive are written over the
+	// existing blobs, but blobs which are not in the archive are left in place.
+	Overwrite bool
+	// ValidDocumentId, if set, rejects the archive if any document id is not valid.
+	ValidDocumentId func(id string) bool
+	// VerifyFirst reads and checks every document in the archive before writing any of them, so that an invalid
+	// document or one that already exists rejects the whole archive. The whole archive is held in memory, so the source
+	// should be bounded.
+	VerifyFirst bool
+}
+
+// Import reads each document from the archive and writes its blobs with PutBlob. Each document is verified in full
+// before any of its blobs are written, so a corrupt archive never results in a partially written document. The
+// manifests of the imported documents are returned with the project id they were written to, including when an error
+// stops the import part way through.
+func Import(ctx context.Context, s storage.BlobStorage, src io.Reader, opts ImportOptions) ([]*Manifest, error) {
+	r := NewReader(src)
+	var manifests []*Manifest
+	var verified []*Document
+	seen := make(map[[2]string]bool)
+	for {
+		doc, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return manifests, err
+		}
+		if opts.ProjectId != "" {
+			doc.ProjectId = opts.ProjectId
+		}
+		if err := checkImport(ctx, s, doc, opts); err != nil {
+			return manifests, err
+		}
+		if !opts.VerifyFirst {
+			if err := importDocument(ctx, s, doc); err != nil {
+				return manifests, err
+			}
+			manifests = append(manifests, &doc.Manifest)
+			continue
+		}
+		// Nothing is written yet, so a document that appears twice wouldn't be found by the existence check.
+		key := [2]string{doc.ProjectId, doc.DocumentId}
+		if seen[key] && !opts.Overwrite {
+			return manifests, fmt.Errorf("failed to import %s/%s: %w", doc.ProjectId, doc.DocumentId, ErrDocumentExists)
+		}
+		seen[key] = true
+		verified = append(verified, doc)
+	}
+	for _, doc := range verified {
+		if err := importDocument(ctx, s, doc); err != nil {
+			return manifests, err
+		}
+		manifests = append(manifests, &doc.Manifest)
+	}
+	return manifests, nil
+}
+
+// checkImport checks the document id, and that the document doesn't exist yet unless overwriting is enabled.
+func checkImport(ctx context.Context, s storage.BlobStorage, doc *Document, opts ImportOptions) error {
+	if opts.ValidDocumentId != nil && !opts.ValidDocumentId(doc.DocumentId) {
+		return fmt.Errorf("%w: invalid document id '%s'", ErrInvalidArchive, doc.DocumentId)
+	}
+	if !opts.Overwrite {
+		if _, err := s.ListBlobs(ctx, doc.ProjectId, doc.DocumentId); err == nil {
+			return fmt.Errorf("failed to import %s/%s: %w", doc.ProjectId, doc.DocumentId, ErrDocumentExists)
+		} else if !errors.Is(err, storage.ErrDocumentNotFound) {
+			return fmt.Errorf("failed to check for existing document: %w", err)
+		}
+	}
+	return nil
+}
+
+func importDocument(ctx context.Context, s storage.BlobStorage, doc *Document) error {
+	for i, b := range doc.Blobs {
+		if err := s.PutBlob(ctx, doc.ProjectId, doc.DocumentId, b.Id, b.Metadata, doc.Contents[i]); err != nil {
+			return fmt.Errorf("failed to import %s/%s: failed to put blob %s: %w", doc.ProjectId, doc.DocumentId, b.Id, err)
+		}
+	}
+	return nil
+}
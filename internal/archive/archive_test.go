@@ -0,0 +1,182 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/astromechza/memory-mouse/internal/documents"
+	"github.com/astromechza/memory-mouse/internal/storage"
+	"github.com/astromechza/memory-mouse/internal/storage/sqlitetest"
+	"github.com/astromechza/memory-mouse/internal/testsupport"
+)
+
+func TestExportImport(t *testing.T) {
+	src := sqlitetest.New(t)
+	ctx := context.Background()
+	testsupport.MustAssertEqual(t, src.PutBlob(ctx, "p/1", "d1", "00000000001", map[string]string{"a": "b"}, []byte("one")), nil)
+	testsupport.MustAssertEqual(t, src.PutBlob(ctx, "p/1", "d1", "00000000002", nil, []byte("two")), nil)
+	testsupport.MustAssertEqual(t, src.PutBlob(ctx, "p/1", "d2", "00000000001", nil, []byte("three")), nil)
+
+	buff := new(bytes.Buffer)
+	manifests, err := Export(ctx, src, buff, "p/1")
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, len(manifests), 2)
+	testsupport.AssertEqual(t, manifests[0].Blobs[0], ManifestBlob{
+		Id: "00000000001", Size: 3, Sha256: "7692c3ad3540bb803c020b3aee66cd8887123234ea0c6e7143c0add73ff431ed", Metadata: map[string]string{"a": "b"},
+	})
+
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(buff.Bytes()))
+	for h, err := tr.Next(); err == nil; h, err = tr.Next() {
+		names = append(names, h.Name)
+	}
+	testsupport.AssertEqual(t, names, []string{
+		"p%2F1/d1/manifest.json", "p%2F1/d1/blobs/00000000001", "p%2F1/d1/blobs/00000000002",
+		"p%2F1/d2/manifest.json", "p%2F1/d2/blobs/00000000001",
+	})
+
+	dst := sqlitetest.New(t)
+	imported, err := Import(ctx, dst, bytes.NewReader(buff.Bytes()), ImportOptions{ProjectId: "other"})
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, len(imported), 2)
+	testsupport.AssertEqual(t, imported[1].ProjectId, "other")
+	out := new(bytes.Buffer)
+	blob, err := dst.GetBlob(ctx, "other", "d1", "00000000001", out)
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, out.String(), "one")
+	testsupport.AssertEqual(t, blob.Metadata, map[string]string{"a": "b"})
+
+	_, err = Import(ctx, dst, bytes.NewReader(buff.Bytes()), ImportOptions{ProjectId: "other"})
+	testsupport.AssertErrorEqual(t, err, "failed to import other/d1: document already exists")
+	testsupport.AssertEqual(t, errors.Is(err, ErrDocumentExists), true)
+	imported, err = Import(ctx, dst, bytes.NewReader(buff.Bytes()), ImportOptions{ProjectId: "other", Overwrite: true})
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, len(imported), 2)
+
+	imported, err = Import(ctx, dst, new(bytes.Buffer), ImportOptions{})
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, len(imported), 0)
+}
+
+func TestImport_verify_first(t *testing.T) {
+	ctx := context.Background()
+	src := sqlitetest.New(t)
+	testsupport.MustAssertEqual(t, src.PutBlob(ctx, "p", "d1", "00000000001", nil, []byte("one")), nil)
+	testsupport.MustAssertEqual(t, src.PutBlob(ctx, "p", "d2", "00000000001", nil, []byte("two")), nil)
+	buff := new(bytes.Buffer)
+	_, err := Export(ctx, src, buff, "p")
+	testsupport.MustAssertEqual(t, err, nil)
+
+	t.Run("existing", func(t *testing.T) {
+		dst := sqlitetest.New(t)
+		testsupport.MustAssertEqual(t, dst.PutBlob(ctx, "p", "d2", "00000000001", nil, []byte("old")), nil)
+		imported, err := Import(ctx, dst, bytes.NewReader(buff.Bytes()), ImportOptions{VerifyFirst: true})
+		testsupport.AssertEqual(t, errors.Is(err, ErrDocumentExists), true)
+		testsupport.AssertEqual(t, len(imported), 0)
+		ids, _ := dst.ListDocumentIds(ctx, "p")
+		testsupport.AssertEqual(t, ids, []string{"d2"})
+
+		// Without verifying first, the documents before the existing one are written and returned.
+		imported, err = Import(ctx, dst, bytes.NewReader(buff.Bytes()), ImportOptions{})
+		testsupport.AssertEqual(t, errors.Is(err, ErrDocumentExists), true)
+		testsupport.AssertEqual(t, len(imported), 1)
+		testsupport.AssertEqual(t, imported[0].DocumentId, "d1")
+	})
+
+	t.Run("invalid id", func(t *testing.T) {
+		dst := sqlitetest.New(t)
+		_, err := Import(ctx, dst, bytes.NewReader(buff.Bytes()), ImportOptions{VerifyFirst: true, ValidDocumentId: func(id string) bool {
+			return id == "d1"
+		}})
+		testsupport.AssertErrorEqual(t, err, "invalid archive: invalid document id 'd2'")
+		ids, _ := dst.ListProjectIds(ctx)
+		testsupport.AssertEqual(t, len(ids), 0)
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		doc, err := LoadDocument(ctx, src, "p", "d1")
+		testsupport.MustAssertEqual(t, err, nil)
+		dup := new(bytes.Buffer)
+		w := NewWriter(dup)
+		testsupport.MustAssertEqual(t, w.Add(doc), nil)
+		testsupport.MustAssertEqual(t, w.Add(doc), nil)
+		testsupport.MustAssertEqual(t, w.Close(), nil)
+
+		dst := sqlitetest.New(t)
+		_, err = Import(ctx, dst, bytes.NewReader(dup.Bytes()), ImportOptions{VerifyFirst: true})
+		testsupport.AssertErrorEqual(t, err, "failed to import p/d1: document already exists")
+		ids, _ := dst.ListProjectIds(ctx)
+		testsupport.AssertEqual(t, len(ids), 0)
+		imported, err := Import(ctx, dst, bytes.NewReader(dup.Bytes()), ImportOptions{VerifyFirst: true, Overwrite: true})
+		testsupport.AssertEqual(t, err, nil)
+		testsupport.AssertEqual(t, len(imported), 2)
+	})
+
+	imported, err := Import(ctx, sqlitetest.New(t), bytes.NewReader(buff.Bytes()), ImportOptions{VerifyFirst: true})
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, len(imported), 2)
+}
+
+func TestExport_trash(t *testing.T) {
+	ctx := context.Background()
+	s := sqlitetest.New(t)
+	testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p", "d1", "00000000001", nil, []byte("one")), nil)
+	testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p", "d2", "00000000001", nil, []byte("two")), nil)
+	testsupport.MustAssertEqual(t, documents.Trash(ctx, s, "p", "d2", time.Now()), nil)
+
+	manifests, err := Export(ctx, s, new(bytes.Buffer), "p")
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, len(manifests), 1)
+	testsupport.AssertEqual(t, manifests[0].DocumentId, "d1")
+}
+
+func TestExport_not_found(t *testing.T) {
+	_, err := Export(context.Background(), sqlitetest.New(t), new(bytes.Buffer), "p", "missing")
+	testsupport.AssertErrorEqual(t, err, "failed to export missing: failed to list blobs: document not found")
+	testsupport.AssertEqual(t, errors.Is(err, storage.ErrDocumentNotFound), true)
+}
+
+// writeRawArchive writes the entries to a tar archive in order.
+func writeRawArchive(t *testing.T, entries ...string) *bytes.Buffer {
+	buff := new(bytes.Buffer)
+	tw := tar.NewWriter(buff)
+	for i := 0; i < len(entries); i += 2 {
+		testsupport.MustAssertEqual(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: entries[i], Size: int64(len(entries[i+1])), Mode: 0o644}), nil)
+		_, err := tw.Write([]byte(entries[i+1]))
+		testsupport.MustAssertEqual(t, err, nil)
+	}
+	testsupport.MustAssertEqual(t, tw.Close(), nil)
+	return buff
+}
+
+func TestImport_invalid(t *testing.T) {
+	const manifest = `{"projectId":"p","documentId":"d","blobs":[{"id":"b","size":3,"sha256":"7692c3ad3540bb803c020b3aee66cd8887123234ea0c6e7143c0add73ff431ed"}]}`
+	for _, tc := range []struct {
+		name     string
+		entries  []string
+		expected string
+	}{
+		{"bad manifest", []string{"p/d/manifest.json", "{"}, "invalid archive: failed to decode manifest: unexpected end of JSON input"},
+		{"empty manifest", []string{"p/d/manifest.json", "{}"}, "invalid archive: manifest must have a project id, document id, and at least one blob"},
+		{"wrong manifest path", []string{"x/d/manifest.json", manifest}, "invalid archive: expected entry p/d/manifest.json but got x/d/manifest.json"},
+		{"missing blob", []string{"p/d/manifest.json", manifest}, "invalid archive: missing entry p/d/blobs/b"},
+		{"wrong blob", []string{"p/d/manifest.json", manifest, "p/d/blobs/c", "one"}, "invalid archive: expected entry p/d/blobs/b but got p/d/blobs/c"},
+		{"too large", []string{"p/d/manifest.json", manifest, "p/d/blobs/b", "four"}, "invalid archive: entry p/d/blobs/b is 4 bytes, expected at most 3"},
+		{"too small", []string{"p/d/manifest.json", manifest, "p/d/blobs/b", "on"}, "invalid archive: blob b is 2 bytes but the manifest has 3"},
+		{"checksum", []string{"p/d/manifest.json", manifest, "p/d/blobs/b", "ONE"},
+			"invalid archive: blob b has checksum 2192e8955d5e1ad1651f2f0c637e6f1ac82855747a5f42f978db28669595dc21 but the manifest has 7692c3ad3540bb803c020b3aee66cd8887123234ea0c6e7143c0add73ff431ed"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := sqlitetest.New(t)
+			_, err := Import(context.Background(), s, writeRawArchive(t, tc.entries...), ImportOptions{})
+			testsupport.AssertErrorEqual(t, err, tc.expected)
+			testsupport.AssertEqual(t, errors.Is(err, ErrInvalidArchive), true)
+			ids, _ := s.ListProjectIds(context.Background())
+			testsupport.AssertEqual(t, len(ids), 0)
+		})
+	}
+}
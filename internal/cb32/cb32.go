@@ -5,15 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"strings"
 )
 
 // bitPump is the core of the bit encoder and decoder. It reads bytes from the src, decodes them into integer chunks and
 // adds them to the buffer. Whenever the buffer has enough content for an output chunk, we read it, convert it to a byte
 // and then write it.
-func bitPump(src io.ByteReader, srcDecoder map[byte]int, srcChunkSize int, dropExtra bool, dstChunkSize int, dstEncoder map[int]byte, dst io.ByteWriter) (int64, error) {
+func bitPump(src io.ByteReader, srcDecoder *[256]byte, srcChunkSize int, dropExtra bool, dstChunkSize int, dstEncoder *[32]byte, dst io.ByteWriter) (int64, error) {
 	// Setup some initial assignments
-	rem, remBits, i, b, ok, written, err := 0, 0, 0, byte(0), false, int64(0), error(nil)
+	rem, remBits, i, b, written, err := 0, 0, 0, byte(0), int64(0), error(nil)
 	// Now loop through the main body of the src stream. Reading bytes to fill in the buffer until we have enough for an output chunk.
 	for {
 		// If we have enough bits for an output chunk, let's produce one.
@@ -24,9 +23,7 @@ func bitPump(src io.ByteReader, srcDecoder map[byte]int, srcChunkSize int, dropE
 			rem = rem & ((1 << remBits) - 1)
 			// encode it by converting the chunk to an output byte, if no alphabet is defined then just cast it.
 			if dstEncoder != nil {
-				if b, ok = dstEncoder[i]; !ok {
-					return written, fmt.Errorf("unknown dst alphabet: %v", b)
-				}
+				b = dstEncoder[i]
 			} else {
 				b = byte(i)
 			}
@@ -47,10 +44,10 @@ func bitPump(src io.ByteReader, srcDecoder map[byte]int, srcChunkSize int, dropE
 			} else {
 				// now convert it into it's integer chunk or just cast it if no decoder is specified
 				if srcDecoder != nil {
-					i, ok = srcDecoder[b]
-					if !ok {
+					if srcDecoder[b] == invalidSymbol {
 						return written, fmt.Errorf("unknown src alphabet: %v", b)
 					}
+					i = int(srcDecoder[b])
 				} else {
 					i = int(b)
 				}
@@ -67,10 +64,7 @@ func bitPump(src io.ByteReader, srcDecoder map[byte]int, srcChunkSize int, dropE
 		i = rem << (dstChunkSize - remBits)
 		// convert it to an output byte using the encoder or cast
 		if dstEncoder != nil {
-			b, ok = dstEncoder[i]
-			if !ok {
-				return written, fmt.Errorf("unknown dst alphabet: %v", b)
-			}
+			b = dstEncoder[i]
 		} else {
 			b = byte(i)
 		}
@@ -82,48 +76,194 @@ func bitPump(src io.ByteReader, srcDecoder map[byte]int, srcChunkSize int, dropE
 	return written, nil
 }
 
-var cb32Encoding = map[int]byte{
-	0: '0', 1: '1', 2: '2', 3: '3', 4: '4', 5: '5', 6: '6', 7: '7',
-	8: '8', 9: '9', 10: 'A', 11: 'B', 12: 'C', 13: 'D', 14: 'E', 15: 'F',
-	16: 'G', 17: 'H', 18: 'J', 19: 'K', 20: 'M', 21: 'N', 22: 'P', 23: 'Q',
-	24: 'R', 25: 'S', 26: 'T', 27: 'V', 28: 'W', 29: 'X', 30: 'Y', 31: 'Z',
+// cb32Encoding maps each 5-bit value to its symbol. These are array based rather than maps because the lookups are in
+// the hot path of every encoded or decoded byte.
+var cb32Encoding = [32]byte{
+	'0', '1', '2', '3', '4', '5', '6', '7',
+	'8', '9', 'A', 'B', 'C', 'D', 'E', 'F',
+	'G', 'H', 'J', 'K', 'M', 'N', 'P', 'Q',
+	'R', 'S', 'T', 'V', 'W', 'X', 'Y', 'Z',
 }
 
-var cb32Decoding map[byte]int
+// invalidSymbol marks bytes in the decoding table that are not part of the alphabet.
+const invalidSymbol = 0xff
+
+// cb32Decoding maps each input byte to its 5-bit value or invalidSymbol. This includes the lower case and O/I/L
+// aliases.
+var cb32Decoding [256]byte
 
 func init() {
-	cb32Decoding = make(map[byte]int, len(cb32Encoding))
+	for i := range cb32Decoding {
+		cb32Decoding[i] = invalidSymbol
+	}
 	cb32Decoding['O'] = 0
 	cb32Decoding['I'] = 1
 	cb32Decoding['L'] = 1
 	for i, b := range cb32Encoding {
-		cb32Decoding[b] = i
+		cb32Decoding[b] = byte(i)
 		if b >= 'A' && b <= 'Z' {
-			cb32Decoding[b+32] = i
+			cb32Decoding[b+32] = byte(i)
 		}
 	}
 }
 
 func EncodeCB32(dst io.ByteWriter, src io.ByteReader) (written int64, err error) {
-	return bitPump(src, nil, 8, false, 5, cb32Encoding, dst)
+	return bitPump(src, nil, 8, false, 5, &cb32Encoding, dst)
 }
 
 func DecodeCB32(dst io.ByteWriter, src io.ByteReader) (written int64, err error) {
-	return bitPump(src, cb32Decoding, 5, true, 8, nil, dst)
+	return bitPump(src, &cb32Decoding, 5, true, 8, nil, dst)
+}
+
+// Option modifies the behavior of the string encoding and decoding functions.
+type Option func(o *options)
+
+type options struct {
+	checkSymbol bool
+	strict      bool
+}
+
+func buildOptions(opts []Option) *options {
+	o := new(options)
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithCheckSymbol appends a Crockford mod 37 check symbol when encoding, and requires and validates the check symbol
+// when decoding. This allows typos in human-entered strings to be detected before they are used.
+func WithCheckSymbol() Option {
+	return func(o *options) {
+		o.checkSymbol = true
+	}
+}
+
+// WithStrict rejects any input to DecodeCB32String which is not exactly what EncodeCB32String would have produced for
+// the decoded bytes. Lower case letters, the O/I/L aliases, trailing bits that are not zero, and trailing symbols that
+// don't contribute to a whole byte are all rejected. This ensures that each decoded value has exactly one string form.
+func WithStrict() Option {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+var ErrInvalidCheckSymbol = errors.New("invalid check symbol")
+
+var ErrNonCanonical = errors.New("non-canonical encoding")
+
+// checkCanonicalSymbols returns an error if the input contains anything other than the canonical upper case symbols.
+func checkCanonicalSymbols(in string, withCheckSymbol bool) error {
+	for i := 0; i < len(in); i++ {
+		b := in[i]
+		if v := cb32Decoding[b]; v != invalidSymbol && cb32Encoding[v] == b {
+			continue
+		} else if withCheckSymbol && i == len(in)-1 && bytes.IndexByte(checkSymbols[:], b) >= 0 {
+			continue
+		} else if withCheckSymbol && i == len(in)-1 && b == 'u' {
+			// 'u' is only valid as a check symbol, so it isn't in the decoding table.
+			return fmt.Errorf("%w: symbol '%c' at %d should be 'U'", ErrNonCanonical, b, i)
+		} else if v != invalidSymbol {
+			return fmt.Errorf("%w: symbol '%c' at %d should be '%c'", ErrNonCanonical, b, i, cb32Encoding[v])
+		}
+		// Let the decoder report the unknown symbol
+	}
+	return nil
 }
 
-func EncodeCB32String(in []byte) (string, error) {
-	sb := bytes.NewBuffer(make([]byte, 0, len(in)*2))
-	if _, err := EncodeCB32(sb, bytes.NewReader(in)); err != nil {
+// checkSymbols are the 5 additional symbols used for check values 32 to 36.
+var checkSymbols = [5]byte{'*', '~', '$', '=', 'U'}
+
+// computeCheckSymbol returns the Crockford check symbol for the encoded string. The check value is the number
+// represented by the encoded symbols modulo 37. Because this is calculated from the symbols rather than the decoded
+// bytes, any zero padding bits in the final symbol are included.
+func computeCheckSymbol(encoded string) (byte, error) {
+	r := 0
+	for i := 0; i < len(encoded); i++ {
+		v := cb32Decoding[encoded[i]]
+		if v == invalidSymbol {
+			return 0, fmt.Errorf("unknown src alphabet: %v", encoded[i])
+		}
+		r = (r*32 + int(v)) % 37
+	}
+	if r < 32 {
+		return cb32Encoding[r], nil
+	}
+	return checkSymbols[r-32], nil
+}
+
+// splitCheckSymbol removes the trailing check symbol from the input and validates it.
+func splitCheckSymbol(in string) (string, error) {
+	if len(in) == 0 {
+		return "", fmt.Errorf("%w: missing", ErrInvalidCheckSymbol)
+	}
+	body, check := in[:len(in)-1], in[len(in)-1]
+	if check == 'u' {
+		check = 'U'
+	} else if v := cb32Decoding[check]; v != invalidSymbol {
+		check = cb32Encoding[v]
+	}
+	if expected, err := computeCheckSymbol(body); err != nil {
 		return "", err
+	} else if check != expected {
+		return "", fmt.Errorf("%w: expected '%c' but got '%c'", ErrInvalidCheckSymbol, expected, in[len(in)-1])
+	}
+	return body, nil
+}
+
+func EncodeCB32String(in []byte, opts ...Option) (string, error) {
+	o := buildOptions(opts)
+	out := AppendEncode(make([]byte, 0, len(in)*8/5+2), in)
+	if o.checkSymbol {
+		c, err := computeCheckSymbol(string(out))
+		if err != nil {
+			return "", err
+		}
+		out = append(out, c)
 	}
-	return sb.String(), nil
+	return string(out), nil
 }
 
-func DecodeCB32String(in string) ([]byte, error) {
-	sb := bytes.NewBuffer(make([]byte, 0, len(in)))
-	if _, err := DecodeCB32(sb, strings.NewReader(in)); err != nil {
+func DecodeCB32String(in string, opts ...Option) ([]byte, error) {
+	o := buildOptions(opts)
+	if o.strict {
+		if err := checkCanonicalSymbols(in, o.checkSymbol); err != nil {
+			return nil, err
+		}
+	}
+	if o.checkSymbol {
+		var err error
+		if in, err = splitCheckSymbol(in); err != nil {
+			return nil, err
+		}
+	}
+	if o.strict {
+		bb := &bitBuffer{}
+		out, err := appendDecodeBits(make([]byte, 0, len(in)*5/8), []byte(in), bb)
+		if err != nil {
+			return nil, err
+		} else if bb.remBits >= 5 {
+			return nil, fmt.Errorf("%w: trailing symbol does not contribute to a whole byte", ErrNonCanonical)
+		} else if bb.rem != 0 {
+			return nil, fmt.Errorf("%w: trailing padding bits are not zero", ErrNonCanonical)
+		}
+		return out, nil
+	}
+	out, err := AppendDecode(make([]byte, 0, len(in)*5/8+1), []byte(in))
+	if err != nil {
 		return nil, err
 	}
-	return sb.Bytes(), nil
+	return out, nil
+}
+
+// Canonicalize returns the canonical form of the encoded string. This is the string that EncodeCB32String would
+// produce for the bytes that DecodeCB32String decodes from the input, so that aliases and lower case are replaced and
+// the check symbol, if enabled, is recalculated. With the WithStrict option the input must already be canonical, so
+// this only validates it.
+func Canonicalize(in string, opts ...Option) (string, error) {
+	b, err := DecodeCB32String(in, opts...)
+	if err != nil {
+		return "", err
+	}
+	return EncodeCB32String(b, opts...)
 }
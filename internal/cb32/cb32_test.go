@@ -2,6 +2,7 @@ package cb32
 
 import (
 	"bytes"
+	"errors"
 	"strings"
 	"testing"
 
@@ -64,3 +65,138 @@ func FuzzEncodeDecodeCB32(f *testing.F) {
 		}
 	})
 }
+
+func TestCheckSymbol(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   []byte
+		out  string
+	}{
+		{"empty", []byte{}, "0"},
+		{"1byte", []byte("a"), "C4J"},
+		{"extended symbol", []byte{0x08}, "10*"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			o, err := EncodeCB32String(tc.in, WithCheckSymbol())
+			if testsupport.AssertEqual(t, err, nil) {
+				testsupport.AssertEqual(t, o, tc.out)
+			}
+			d, err := DecodeCB32String(tc.out, WithCheckSymbol())
+			if testsupport.AssertEqual(t, err, nil) {
+				testsupport.AssertEqual(t, d, tc.in)
+			}
+		})
+	}
+}
+
+func TestCheckSymbol_invalid(t *testing.T) {
+	_, err := DecodeCB32String("C5J", WithCheckSymbol())
+	testsupport.AssertErrorEqual(t, err, "invalid check symbol: expected 'K' but got 'J'")
+	testsupport.AssertEqual(t, errors.Is(err, ErrInvalidCheckSymbol), true)
+	_, err = DecodeCB32String("", WithCheckSymbol())
+	testsupport.AssertErrorEqual(t, err, "invalid check symbol: missing")
+	// aliases are accepted for both the body and the check symbol
+	d, err := DecodeCB32String("c4j", WithCheckSymbol())
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, string(d), "a")
+	d, err = DecodeCB32String("1O*", WithCheckSymbol())
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, d, []byte{0x08})
+}
+
+func FuzzCheckSymbol(f *testing.F) {
+	for _, s := range []string{"", "a", "zzzzz", "\x00\xff"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, in string) {
+		o, err := EncodeCB32String([]byte(in), WithCheckSymbol())
+		testsupport.MustAssertEqual(t, err, nil)
+		d, err := DecodeCB32String(o, WithCheckSymbol())
+		if testsupport.AssertEqual(t, err, nil) {
+			testsupport.AssertEqual(t, string(d), in)
+		}
+	})
+}
+
+func TestStrict(t *testing.T) {
+	for _, tc := range []struct {
+		in, expected string
+	}{
+		{"c4", "non-canonical encoding: symbol 'c' at 0 should be 'C'"},
+		{"1O", "non-canonical encoding: symbol 'O' at 1 should be '0'"},
+		{"IL", "non-canonical encoding: symbol 'I' at 0 should be '1'"},
+		{"C5", "non-canonical encoding: trailing padding bits are not zero"},
+		{"C40", "non-canonical encoding: trailing symbol does not contribute to a whole byte"},
+		{"C4!", "unknown src alphabet: 33"},
+	} {
+		_, err := DecodeCB32String(tc.in, WithStrict())
+		testsupport.AssertErrorEqual(t, err, tc.expected)
+	}
+	for _, in := range []string{"", "C4", "C5H0", "C5H66", "C5H66S0"} {
+		o, err := DecodeCB32String(in, WithStrict())
+		if testsupport.AssertEqual(t, err, nil) {
+			e, _ := EncodeCB32String(o)
+			testsupport.AssertEqual(t, e, in)
+		}
+	}
+	_, err := DecodeCB32String("C4J", WithStrict(), WithCheckSymbol())
+	testsupport.AssertEqual(t, err, nil)
+	_, err = DecodeCB32String("10*", WithStrict(), WithCheckSymbol())
+	testsupport.AssertEqual(t, err, nil)
+	_, err = DecodeCB32String("C4j", WithStrict(), WithCheckSymbol())
+	testsupport.AssertErrorEqual(t, err, "non-canonical encoding: symbol 'j' at 2 should be 'J'")
+	testsupport.AssertEqual(t, errors.Is(err, ErrNonCanonical), true)
+	_, err = DecodeCB32String("1W0GU", WithStrict(), WithCheckSymbol())
+	testsupport.AssertEqual(t, err, nil)
+	_, err = DecodeCB32String("1W0Gu", WithStrict(), WithCheckSymbol())
+	testsupport.AssertErrorEqual(t, err, "non-canonical encoding: symbol 'u' at 4 should be 'U'")
+	_, err = DecodeCB32String("1W0Gu", WithCheckSymbol())
+	testsupport.AssertEqual(t, err, nil)
+}
+
+func TestCanonicalize(t *testing.T) {
+	for _, tc := range []struct {
+		in, expected string
+	}{
+		{"", ""},
+		{"c4", "C4"},
+		{"c5h0", "C5H0"},
+		{"1O", "10"},
+		// the lenient decoder emits an extra byte for non-zero trailing bits, so this grows
+		{"IL", "1100"},
+		{"C40", "C4"},
+	} {
+		o, err := Canonicalize(tc.in)
+		if testsupport.AssertEqual(t, err, nil) {
+			testsupport.AssertEqual(t, o, tc.expected)
+		}
+	}
+	o, err := Canonicalize("C4", WithStrict())
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, o, "C4")
+	_, err = Canonicalize("c4", WithStrict())
+	testsupport.AssertErrorEqual(t, err, "non-canonical encoding: symbol 'c' at 0 should be 'C'")
+	o, err = Canonicalize("c4j", WithCheckSymbol())
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, o, "C4J")
+	_, err = Canonicalize("!")
+	testsupport.AssertErrorEqual(t, err, "unknown src alphabet: 33")
+}
+
+func FuzzCanonicalize(f *testing.F) {
+	for _, s := range []string{"", "c4", "C40", "IL", "zzzzz"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, in string) {
+		o, err := Canonicalize(in)
+		if err != nil {
+			return
+		}
+		// the canonical form must always be accepted by the strict decoder and be a fixed point
+		_, err = DecodeCB32String(o, WithStrict())
+		testsupport.AssertEqual(t, err, nil)
+		o2, err := Canonicalize(o)
+		testsupport.AssertEqual(t, err, nil)
+		testsupport.AssertEqual(t, o2, o)
+	})
+}
@@ -0,0 +1,153 @@
+package cb32
+
+import (
+	"fmt"
+	"io"
+)
+
+// streamBufferSize is the size of the internal buffers used by the streaming encoder and decoder.
+const streamBufferSize = 1024
+
+// AppendEncode appends the cb32 encoding of src to dst and returns the extended buffer.
+func AppendEncode(dst, src []byte) []byte {
+	bb := &bitBuffer{}
+	return bitBufferFlushEncode(appendEncodeBits(dst, src, bb), bb)
+}
+
+// AppendDecode appends the bytes decoded from the cb32 src to dst and returns the extended buffer. If the input is
+// malformed, it returns the partially decoded data and an error.
+func AppendDecode(dst, src []byte) ([]byte, error) {
+	bb := &bitBuffer{}
+	dst, err := appendDecodeBits(dst, src, bb)
+	if err != nil {
+		return dst, err
+	}
+	return bitBufferFlushDecode(dst, bb), nil
+}
+
+// bitBuffer holds the bits which have been read but not yet written between calls.
+type bitBuffer struct {
+	rem     uint16
+	remBits uint
+}
+
+func appendEncodeBits(dst, src []byte, bb *bitBuffer) []byte {
+	for _, b := range src {
+		bb.rem = bb.rem<<8 | uint16(b)
+		bb.remBits += 8
+		for bb.remBits >= 5 {
+			bb.remBits -= 5
+			dst = append(dst, cb32Encoding[(bb.rem>>bb.remBits)&0x1f])
+		}
+		bb.rem &= 1<<bb.remBits - 1
+	}
+	return dst
+}
+
+// bitBufferFlushEncode zero pads any remaining bits into a final symbol.
+func bitBufferFlushEncode(dst []byte, bb *bitBuffer) []byte {
+	if bb.remBits > 0 {
+		dst = append(dst, cb32Encoding[(bb.rem<<(5-bb.remBits))&0x1f])
+		bb.rem, bb.remBits = 0, 0
+	}
+	return dst
+}
+
+func appendDecodeBits(dst, src []byte, bb *bitBuffer) ([]byte, error) {
+	for _, b := range src {
+		v := cb32Decoding[b]
+		if v == invalidSymbol {
+			return dst, fmt.Errorf("unknown src alphabet: %v", b)
+		}
+		bb.rem = bb.rem<<5 | uint16(v)
+		bb.remBits += 5
+		if bb.remBits >= 8 {
+			bb.remBits -= 8
+			dst = append(dst, byte(bb.rem>>bb.remBits))
+			bb.rem &= 1<<bb.remBits - 1
+		}
+	}
+	return dst, nil
+}
+
+// bitBufferFlushDecode drops any remaining zero bits, but preserves the existing DecodeCB32 behavior of emitting a
+// final byte if any of the remaining bits are set.
+func bitBufferFlushDecode(dst []byte, bb *bitBuffer) []byte {
+	if bb.remBits > 0 && bb.rem > 0 {
+		dst = append(dst, byte(bb.rem<<(8-bb.remBits)))
+	}
+	bb.rem, bb.remBits = 0, 0
+	return dst
+}
+
+type encoder struct {
+	w   io.Writer
+	bb  bitBuffer
+	buf []byte
+	err error
+}
+
+// NewEncoder returns a new cb32 stream encoder. Data written to the returned writer is encoded and written to w. The
+// caller must Close the encoder to flush any partially written symbol.
+func NewEncoder(w io.Writer) io.WriteCloser {
+	return &encoder{w: w, buf: make([]byte, 0, streamBufferSize*8/5+1)}
+}
+
+func (e *encoder) Write(p []byte) (n int, err error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	for n < len(p) {
+		chunk := p[n:min(len(p), n+streamBufferSize)]
+		if _, e.err = e.w.Write(appendEncodeBits(e.buf[:0], chunk, &e.bb)); e.err != nil {
+			return n, e.err
+		}
+		n += len(chunk)
+	}
+	return n, nil
+}
+
+// Close flushes any partially written symbol. It does not close the underlying writer.
+func (e *encoder) Close() error {
+	if e.err != nil {
+		return e.err
+	}
+	if out := bitBufferFlushEncode(e.buf[:0], &e.bb); len(out) > 0 {
+		_, e.err = e.w.Write(out)
+	}
+	return e.err
+}
+
+type decoder struct {
+	r       io.Reader
+	bb      bitBuffer
+	in      []byte
+	pending []byte
+	out     []byte
+	err     error
+}
+
+// NewDecoder returns a new cb32 stream decoder that reads encoded data from r.
+func NewDecoder(r io.Reader) io.Reader {
+	return &decoder{r: r, in: make([]byte, streamBufferSize), out: make([]byte, 0, streamBufferSize)}
+}
+
+func (d *decoder) Read(p []byte) (int, error) {
+	for len(d.pending) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		n, err := d.r.Read(d.in)
+		d.out, d.err = appendDecodeBits(d.out[:0], d.in[:n], &d.bb)
+		if d.err == nil && err != nil {
+			if err == io.EOF {
+				d.out = bitBufferFlushDecode(d.out, &d.bb)
+			}
+			d.err = err
+		}
+		d.pending = d.out
+	}
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
@@ -0,0 +1,126 @@
+package cb32
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/astromechza/memory-mouse/internal/testsupport"
+)
+
+func streamRoundTrip(t *testing.T, in []byte, writeSize int) {
+	t.Helper()
+	expected, err := EncodeCB32String(in)
+	testsupport.MustAssertEqual(t, err, nil)
+
+	buff := new(bytes.Buffer)
+	enc := NewEncoder(buff)
+	for i := 0; i < len(in); i += writeSize {
+		n, err := enc.Write(in[i:min(len(in), i+writeSize)])
+		testsupport.MustAssertEqual(t, err, nil)
+		testsupport.AssertEqual(t, n, min(len(in), i+writeSize)-i)
+	}
+	testsupport.MustAssertEqual(t, enc.Close(), nil)
+	testsupport.AssertEqual(t, buff.String(), expected)
+	testsupport.AssertEqual(t, string(AppendEncode(nil, in)), expected)
+
+	out, err := io.ReadAll(iotest.OneByteReader(NewDecoder(strings.NewReader(expected))))
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, string(out), string(in))
+	out, err = io.ReadAll(NewDecoder(iotest.HalfReader(strings.NewReader(expected))))
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, string(out), string(in))
+	out, err = AppendDecode([]byte("prefix"), []byte(expected))
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, string(out), "prefix"+string(in))
+}
+
+func TestStream(t *testing.T) {
+	for _, in := range []string{"", "a", "ab", "abc", "abcd", "abcde", strings.Repeat("xyz", 2000)} {
+		for _, size := range []int{1, 3, 7, 4096} {
+			streamRoundTrip(t, []byte(in), size)
+		}
+	}
+}
+
+func TestDecoder_invalid(t *testing.T) {
+	out, err := io.ReadAll(NewDecoder(strings.NewReader("C5H6!")))
+	testsupport.AssertErrorEqual(t, err, "unknown src alphabet: 33")
+	testsupport.AssertEqual(t, string(out), "ab")
+	_, err = AppendDecode(nil, []byte("!"))
+	testsupport.AssertErrorEqual(t, err, "unknown src alphabet: 33")
+}
+
+func TestDecoder_reader_error(t *testing.T) {
+	_, err := io.ReadAll(NewDecoder(iotest.ErrReader(io.ErrUnexpectedEOF)))
+	testsupport.AssertEqual(t, err, io.ErrUnexpectedEOF)
+}
+
+func FuzzStream(f *testing.F) {
+	for _, s := range []string{"", "a", "zzzzz", "\x00\xff", strings.Repeat("x", 100)} {
+		f.Add(s, 3)
+	}
+	f.Fuzz(func(t *testing.T, in string, writeSize int) {
+		if writeSize <= 0 {
+			writeSize = 1
+		}
+		streamRoundTrip(t, []byte(in), writeSize)
+	})
+}
+
+var benchmarkInput = bytes.Repeat([]byte("memory-mouse"), 1024)
+
+func BenchmarkEncodeCB32(b *testing.B) {
+	b.SetBytes(int64(len(benchmarkInput)))
+	buff := new(bytes.Buffer)
+	for range b.N {
+		buff.Reset()
+		_, _ = EncodeCB32(buff, bytes.NewReader(benchmarkInput))
+	}
+}
+
+func BenchmarkAppendEncode(b *testing.B) {
+	b.SetBytes(int64(len(benchmarkInput)))
+	out := make([]byte, 0, len(benchmarkInput)*2)
+	for range b.N {
+		out = AppendEncode(out[:0], benchmarkInput)
+	}
+}
+
+func BenchmarkEncoder(b *testing.B) {
+	b.SetBytes(int64(len(benchmarkInput)))
+	for range b.N {
+		enc := NewEncoder(io.Discard)
+		_, _ = enc.Write(benchmarkInput)
+		_ = enc.Close()
+	}
+}
+
+func BenchmarkDecodeCB32(b *testing.B) {
+	encoded := AppendEncode(nil, benchmarkInput)
+	b.SetBytes(int64(len(encoded)))
+	buff := new(bytes.Buffer)
+	for range b.N {
+		buff.Reset()
+		_, _ = DecodeCB32(buff, bytes.NewReader(encoded))
+	}
+}
+
+func BenchmarkAppendDecode(b *testing.B) {
+	encoded := AppendEncode(nil, benchmarkInput)
+	b.SetBytes(int64(len(encoded)))
+	out := make([]byte, 0, len(benchmarkInput))
+	for range b.N {
+		out, _ = AppendDecode(out[:0], encoded)
+	}
+}
+
+func BenchmarkDecoder(b *testing.B) {
+	encoded := AppendEncode(nil, benchmarkInput)
+	b.SetBytes(int64(len(encoded)))
+	for range b.N {
+		_, _ = io.Copy(io.Discard, NewDecoder(bytes.NewReader(encoded)))
+	}
+}
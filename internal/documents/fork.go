@@ -0,0 +1,63 @@
+package documents
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/astromechza/memory-mouse/internal/storage"
+	"github.com/astromechza/memory-mouse/internal/uid"
+)
+
+const (
+	// ForkedFromProjectMetadataKey, ForkedFromDocumentMetadataKey, and ForkedFromChunkMetadataKey record the provenance
+	// on the first chunk of a forked document.
+	ForkedFromProjectMetadataKey  = "forked-from-project"
+	ForkedFromDocumentMetadataKey = "forked-from-document"
+	ForkedFromChunkMetadataKey    = "forked-from-chunk"
+)
+
+// ForkSource identifies the state a document was forked from.
+type ForkSource struct {
+	ProjectId  string `json:"projectId"`
+	DocumentId string `json:"documentId"`
+	ChunkId    string `json:"chunkId"`
+}
+
+// Fork is a new document created from the state of another.
+type Fork struct {
+	ProjectId  string     `json:"projectId"`
+	DocumentId string     `json:"documentId"`
+	ForkedFrom ForkSource `json:"forkedFrom"`
+}
+
+// CreateFork creates a new document in the given project whose first chunk holds the state of the source document at
+// the given chunk, as loaded with LoadHistory. The history, snapshots, and metadata are not copied, so the fork starts
+// at sequence 1 with new metadata recording the creator.
+func CreateFork(ctx context.Context, s storage.BlobStorage, sourceProjectId, sourceDocumentId string, h *History, at uid.ChunkId, projectId, creator string, now time.Time) (*Fork, error) {
+	buff := new(bytes.Buffer)
+	if err := WriteAsOf(ctx, s, sourceProjectId, sourceDocumentId, h, at, buff); err != nil {
+		return nil, err
+	}
+	source := ForkSource{ProjectId: sourceProjectId, DocumentId: sourceDocumentId, ChunkId: at.String()}
+	fork := &Fork{ProjectId: projectId, DocumentId: uid.DocumentUid(), ForkedFrom: source}
+	sum := sha256.Sum256(buff.Bytes())
+	meta := map[string]string{
+		ChecksumMetadataKey:           hex.EncodeToString(sum[:]),
+		WrittenAtMetadataKey:          now.UTC().Format(time.RFC3339),
+		ForkedFromProjectMetadataKey:  source.ProjectId,
+		ForkedFromDocumentMetadataKey: source.DocumentId,
+		ForkedFromChunkMetadataKey:    source.ChunkId,
+	}
+	first := uid.ChunkId{Sequence: 1}
+	if err := s.PutBlob(ctx, projectId, fork.DocumentId, first.String(), meta, buff.Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to write first chunk: %w", err)
+	}
+	if _, err := CreateMetadata(ctx, s, projectId, fork.DocumentId, creator, now); err != nil {
+		return nil, err
+	}
+	return fork, nil
+}
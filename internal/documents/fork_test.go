@@ -0,0 +1,48 @@
+package documents
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/astromechza/memory-mouse/internal/storage/sqlitetest"
+	"github.com/astromechza/memory-mouse/internal/testsupport"
+	"github.com/astromechza/memory-mouse/internal/uid"
+)
+
+func TestCreateFork(t *testing.T) {
+	ctx := context.Background()
+	s := sqlitetest.New(t)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p", "d", "00000000001", nil, []byte("a")), nil)
+	testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p", "d", "00000000002", nil, []byte("b")), nil)
+	testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p", "d", "00000000003", nil, []byte("c")), nil)
+	h, err := LoadHistory(ctx, s, "p", "d")
+	testsupport.MustAssertEqual(t, err, nil)
+
+	fork, err := CreateFork(ctx, s, "p", "d", h, chunkId(t, "00000000002"), "other", "alice", now)
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, fork.ProjectId, "other")
+	testsupport.AssertEqual(t, fork.ForkedFrom, ForkSource{ProjectId: "p", DocumentId: "d", ChunkId: "00000000002"})
+	_, ok := uid.CanonicalDocumentUid(fork.DocumentId)
+	testsupport.AssertEqual(t, ok, true)
+
+	buff := new(bytes.Buffer)
+	blob, err := s.GetBlob(ctx, "other", fork.DocumentId, "00000000001", buff)
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, buff.String(), "ab")
+	testsupport.AssertEqual(t, blob.Metadata, map[string]string{
+		ChecksumMetadataKey:           "fb8e20fc2e4c3f248c60c39bd652f3c1347298bb977b8b4d5903b85055620603",
+		WrittenAtMetadataKey:          "2024-01-02T03:04:05Z",
+		ForkedFromProjectMetadataKey:  "p",
+		ForkedFromDocumentMetadataKey: "d",
+		ForkedFromChunkMetadataKey:    "00000000002",
+	})
+	m, err := GetMetadata(ctx, s, "other", fork.DocumentId)
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, m, &Metadata{Tags: []string{}, CreatedAt: now, UpdatedAt: now, Creator: "alice"})
+
+	_, err = CreateFork(ctx, s, "p", "d", h, chunkId(t, "00000000004"), "other", "alice", now)
+	testsupport.AssertErrorEqual(t, err, "history is not available: chunks 4 to 4 have been compacted or are missing")
+}
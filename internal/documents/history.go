@@ -0,0 +1,267 @@
+package documents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/astromechza/memory-mouse/internal/storage"
+	"github.com/astromechza/memory-mouse/internal/uid"
+)
+
+const (
+	// ChecksumMetadataKey holds the hex sha256 of the chunk content. It is optional, but verified when present.
+	ChecksumMetadataKey = "sha256"
+	// CompactedFromMetadataKey holds the id of the first chunk that a compacted chunk contains the changes of.
+	CompactedFromMetadataKey = "compacted-from"
+	// WrittenAtMetadataKey holds the time the chunk was written in RFC 3339 format. A compacted chunk keeps the time of
+	// the last chunk it replaces, so that it is found by the same point-in-time lookups.
+	WrittenAtMetadataKey = "written-at"
+)
+
+const (
+	// SnapshotBlobPrefix is the prefix of the blobs that hold named snapshots. These live in the document alongside the
+	// chunks so that they are exported, migrated, and purged with it. The '.' can never appear in a chunk id.
+	SnapshotBlobPrefix = "snapshot."
+	// snapshotChunkMetadataKey holds the id of the last chunk included in the snapshot.
+	snapshotChunkMetadataKey = "snapshot-chunk"
+	// snapshotCreatedAtMetadataKey holds the time the snapshot was created in RFC 3339 format.
+	snapshotCreatedAtMetadataKey = "created-at"
+)
+
+var (
+	ErrHistoryUnavailable  = errors.New("history is not available")
+	ErrSnapshotNotFound    = errors.New("snapshot not found")
+	ErrSnapshotExists      = errors.New("snapshot already exists")
+	ErrInvalidSnapshotName = errors.New("invalid snapshot name")
+)
+
+var snapshotNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// HistoryChunk is a chunk of the document as recorded in storage.
+type HistoryChunk struct {
+	Id            string     `json:"id"`
+	Size          int64      `json:"size"`
+	Sha256        string     `json:"sha256,omitempty"`
+	CompactedFrom string     `json:"compactedFrom,omitempty"`
+	WrittenAt     *time.Time `json:"writtenAt,omitempty"`
+}
+
+// Snapshot is a named point in the history of a document which compaction must preserve.
+type Snapshot struct {
+	Name      string    `json:"name"`
+	ChunkId   string    `json:"chunkId"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// History is the list of chunks of a document in order, and the snapshots by name.
+type History struct {
+	Chunks    []HistoryChunk `json:"chunks"`
+	Snapshots []Snapshot     `json:"snapshots"`
+}
+
+// IsSnapshotBlobId returns whether the blob id holds a snapshot rather than a chunk.
+func IsSnapshotBlobId(blobId string) bool {
+	return strings.HasPrefix(blobId, SnapshotBlobPrefix)
+}
+
+// ParseSnapshot reads the snapshot from the metadata of a snapshot blob.
+func ParseSnapshot(blob *storage.BlobIdSizeAndMeta) (*Snapshot, error) {
+	name, ok := strings.CutPrefix(blob.Id, SnapshotBlobPrefix)
+	if !ok {
+		return nil, fmt.Errorf("blob %s is not a snapshot", blob.Id)
+	}
+	chunkId, err := uid.ParseChunkId(blob.Metadata[snapshotChunkMetadataKey])
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %s has an invalid chunk: %w", name, err)
+	}
+	createdAt, _ := time.Parse(time.RFC3339, blob.Metadata[snapshotCreatedAtMetadataKey])
+	return &Snapshot{Name: name, ChunkId: chunkId.String(), CreatedAt: createdAt}, nil
+}
+
+// LoadHistory reads the chunk and snapshot metadata of the document. Blobs which are neither are ignored.
+func LoadHistory(ctx context.Context, s storage.BlobStorage, projectId, documentId string) (*History, error) {
+	blobs, err := s.ListBlobs(ctx, projectId, documentId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blobs: %w", err)
+	}
+	h := &History{Chunks: make([]HistoryChunk, 0, len(blobs)), Snapshots: make([]Snapshot, 0)}
+	for _, b := range blobs {
+		isSnapshot := IsSnapshotBlobId(b.Id)
+		if _, err := uid.ParseChunkId(b.Id); err != nil && !isSnapshot {
+			continue
+		}
+		blob, err := s.HeadBlob(ctx, projectId, documentId, b.Id)
+		if errors.Is(err, storage.ErrBlobNotFound) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to head blob %s: %w", b.Id, err)
+		}
+		if isSnapshot {
+			snapshot, err := ParseSnapshot(blob)
+			if err != nil {
+				return nil, err
+			}
+			h.Snapshots = append(h.Snapshots, *snapshot)
+			continue
+		}
+		c := HistoryChunk{Id: b.Id, Size: blob.Size, Sha256: blob.Metadata[ChecksumMetadataKey], CompactedFrom: blob.Metadata[CompactedFromMetadataKey]}
+		if t, err := time.Parse(time.RFC3339, blob.Metadata[WrittenAtMetadataKey]); err == nil {
+			c.WrittenAt = &t
+		}
+		h.Chunks = append(h.Chunks, c)
+	}
+	// The canonical chunk ids sort in logical order.
+	slices.SortFunc(h.Chunks, func(a, b HistoryChunk) int {
+		return strings.Compare(a.Id, b.Id)
+	})
+	slices.SortFunc(h.Snapshots, func(a, b Snapshot) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return h, nil
+}
+
+// Latest returns the id of the last chunk.
+func (h *History) Latest() (uid.ChunkId, error) {
+	if len(h.Chunks) == 0 {
+		return uid.ChunkId{}, fmt.Errorf("%w: the document has no chunks", ErrHistoryUnavailable)
+	}
+	return uid.ParseChunkId(h.Chunks[len(h.Chunks)-1].Id)
+}
+
+// CompactedChunk returns the id of the latest chunk when it alone holds the whole state of the document, which is the
+// case once every earlier chunk has been compacted into it. The blob can then be served as is without concatenation.
+func (h *History) CompactedChunk() (string, bool) {
+	latest, err := h.Latest()
+	if err != nil {
+		return "", false
+	}
+	ids, err := h.chunksAsOf(latest)
+	if err != nil || len(ids) != 1 {
+		return "", false
+	}
+	return ids[0], true
+}
+
+// ChunkAsOf returns the id of the last chunk written at or before the given time. Chunks without a written time are
+// skipped.
+func (h *History) ChunkAsOf(t time.Time) (uid.ChunkId, error) {
+	for i := len(h.Chunks) - 1; i >= 0; i-- {
+		if w := h.Chunks[i].WrittenAt; w != nil && !w.After(t) {
+			return uid.ParseChunkId(h.Chunks[i].Id)
+		}
+	}
+	return uid.ChunkId{}, fmt.Errorf("%w: no chunks were written at or before %s", ErrHistoryUnavailable, t.Format(time.RFC3339))
+}
+
+// Snapshot returns the named snapshot.
+func (h *History) Snapshot(name string) (*Snapshot, error) {
+	for _, s := range h.Snapshots {
+		if s.Name == name {
+			return &s, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: '%s'", ErrSnapshotNotFound, name)
+}
+
+// chunksAsOf returns the ids of the chunks that make up the state of the document at the given chunk. Covered chunks
+// from an incomplete compaction are included alongside the compacted chunk, since loading the same changes twice is
+// harmless. ErrHistoryUnavailable is returned if any of the earlier chunks have been compacted into a later chunk.
+func (h *History) chunksAsOf(at uid.ChunkId) ([]string, error) {
+	out := make([]string, 0, len(h.Chunks))
+	next := uint64(1)
+	for i, c := range h.Chunks {
+		id, err := uid.ParseChunkId(c.Id)
+		if err != nil {
+			return nil, err
+		} else if id.Compare(at) > 0 {
+			break
+		} else if i+1 < len(h.Chunks) && strings.HasPrefix(h.Chunks[i+1].Id, c.Id[:uid.ChunkSequenceWidth]) && h.Chunks[i+1].Id <= at.String() {
+			// Only the latest writer epoch of each sequence is used.
+			continue
+		}
+		start := id.Sequence
+		if c.CompactedFrom != "" {
+			from, err := uid.ParseChunkId(c.CompactedFrom)
+			if err != nil {
+				return nil, fmt.Errorf("chunk %s has an invalid %s: %w", c.Id, CompactedFromMetadataKey, err)
+			}
+			start = min(start, from.Sequence)
+		}
+		if start > next {
+			return nil, fmt.Errorf("%w: chunks %d to %d have been compacted or are missing", ErrHistoryUnavailable, next, start-1)
+		}
+		next = max(next, id.Sequence+1)
+		out = append(out, c.Id)
+	}
+	if next <= at.Sequence {
+		return nil, fmt.Errorf("%w: chunks %d to %d have been compacted or are missing", ErrHistoryUnavailable, next, at.Sequence)
+	}
+	return out, nil
+}
+
+// WriteAsOf writes the concatenated chunks that make up the state of the document at the given chunk to dst. An
+// automerge document can be loaded directly from the concatenation.
+func WriteAsOf(ctx context.Context, s storage.BlobStorage, projectId, documentId string, h *History, at uid.ChunkId, dst io.Writer) error {
+	ids, err := h.chunksAsOf(at)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if _, err := s.GetBlob(ctx, projectId, documentId, id, dst); err != nil {
+			return fmt.Errorf("failed to get chunk %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// CreateSnapshot records a named snapshot of the document at the given chunk, or the latest chunk if nil. The state at
+// the chunk must still be available.
+func CreateSnapshot(ctx context.Context, s storage.BlobStorage, projectId, documentId, name string, at *uid.ChunkId, now time.Time) (*Snapshot, error) {
+	if !snapshotNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("%w '%s': expected 1 to 64 letters, digits, '_', or '-'", ErrInvalidSnapshotName, name)
+	}
+	h, err := LoadHistory(ctx, s, projectId, documentId)
+	if err != nil {
+		return nil, err
+	} else if _, err := h.Snapshot(name); err == nil {
+		return nil, fmt.Errorf("%w: '%s'", ErrSnapshotExists, name)
+	}
+	if at == nil {
+		latest, err := h.Latest()
+		if err != nil {
+			return nil, err
+		}
+		at = &latest
+	}
+	if _, err := h.chunksAsOf(*at); err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{Name: name, ChunkId: at.String(), CreatedAt: now.UTC().Truncate(time.Second)}
+	meta := map[string]string{
+		snapshotChunkMetadataKey:     snapshot.ChunkId,
+		snapshotCreatedAtMetadataKey: snapshot.CreatedAt.Format(time.RFC3339),
+	}
+	if err := s.PutBlob(ctx, projectId, documentId, SnapshotBlobPrefix+name, meta, []byte{}); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return snapshot, nil
+}
+
+// DeleteSnapshot removes the named snapshot, allowing the chunks it preserved to be compacted.
+func DeleteSnapshot(ctx context.Context, s storage.BlobStorage, projectId, documentId, name string) error {
+	if _, err := s.HeadBlob(ctx, projectId, documentId, SnapshotBlobPrefix+name); errors.Is(err, storage.ErrBlobNotFound) || errors.Is(err, storage.ErrDocumentNotFound) {
+		return fmt.Errorf("%w: '%s'", ErrSnapshotNotFound, name)
+	} else if err != nil {
+		return fmt.Errorf("failed to head snapshot: %w", err)
+	}
+	if err := s.DeleteBlobs(ctx, projectId, documentId, []string{SnapshotBlobPrefix + name}); err != nil {
+		return fmt.Errorf("failed to delete snapshot: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package documents
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/astromechza/memory-mouse/internal/storage"
+	"github.com/astromechza/memory-mouse/internal/storage/sqlitetest"
+	"github.com/astromechza/memory-mouse/internal/testsupport"
+	"github.com/astromechza/memory-mouse/internal/uid"
+)
+
+func writtenAt(t time.Time, extra ...string) map[string]string {
+	out := map[string]string{WrittenAtMetadataKey: t.Format(time.RFC3339)}
+	for i := 0; i+1 < len(extra); i += 2 {
+		out[extra[i]] = extra[i+1]
+	}
+	return out
+}
+
+func chunkId(t *testing.T, raw string) uid.ChunkId {
+	c, err := uid.ParseChunkId(raw)
+	testsupport.MustAssertEqual(t, err, nil)
+	return c
+}
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	s := sqlitetest.New(t)
+	t0 := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p", "d", "00000000001", writtenAt(t0), []byte("a")), nil)
+	testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p", "d", "00000000002", writtenAt(t0.Add(time.Hour)), []byte("b")), nil)
+	testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p", "d", "00000000003", nil, []byte("c")), nil)
+	testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p", "d", "not-a-chunk", nil, []byte("x")), nil)
+
+	_, err := LoadHistory(ctx, s, "p", "missing")
+	testsupport.AssertEqual(t, errors.Is(err, storage.ErrDocumentNotFound), true)
+
+	h, err := LoadHistory(ctx, s, "p", "d")
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.MustAssertEqual(t, len(h.Chunks), 3)
+	testsupport.AssertEqual(t, h.Chunks[0].Id, "00000000001")
+	testsupport.AssertEqual(t, *h.Chunks[1].WrittenAt, t0.Add(time.Hour))
+	testsupport.AssertEqual(t, h.Chunks[2].WrittenAt, nil)
+
+	latest, err := h.Latest()
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, latest.String(), "00000000003")
+	at, err := h.ChunkAsOf(t0.Add(30 * time.Minute))
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, at.String(), "00000000001")
+	_, err = h.ChunkAsOf(t0.Add(-time.Minute))
+	testsupport.AssertErrorEqual(t, err, "history is not available: no chunks were written at or before 2024-01-02T03:03:05Z")
+
+	buff := new(bytes.Buffer)
+	testsupport.AssertEqual(t, WriteAsOf(ctx, s, "p", "d", h, chunkId(t, "00000000002"), buff), nil)
+	testsupport.AssertEqual(t, buff.String(), "ab")
+}
+
+func TestHistory_compacted(t *testing.T) {
+	ctx := context.Background()
+	s := sqlitetest.New(t)
+	// Chunks 1 and 2 were compacted into 3, then chunk 4 was written by a writer which then lost ownership.
+	testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p", "d", "00000000003", map[string]string{CompactedFromMetadataKey: "00000000001"}, []byte("abc")), nil)
+	testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p", "d", "00000000004", nil, []byte("d")), nil)
+	testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p", "d", "00000000004-0000000002", nil, []byte("D")), nil)
+
+	h, err := LoadHistory(ctx, s, "p", "d")
+	testsupport.MustAssertEqual(t, err, nil)
+	for _, tc := range []struct {
+		at       string
+		expected string
+		err      string
+	}{
+		{at: "00000000002", err: "history is not available: chunks 1 to 2 have been compacted or are missing"},
+		{at: "00000000003", expected: "abc"},
+		{at: "00000000004", expected: "abcd"},
+		{at: "00000000004-0000000002", expected: "abcD"},
+		{at: "00000000005", err: "history is not available: chunks 5 to 5 have been compacted or are missing"},
+	} {
+		t.Run(tc.at, func(t *testing.T) {
+			buff := new(bytes.Buffer)
+			err := WriteAsOf(ctx, s, "p", "d", h, chunkId(t, tc.at), buff)
+			if tc.err != "" {
+				testsupport.AssertErrorEqual(t, err, tc.err)
+			} else {
+				testsupport.AssertEqual(t, err, nil)
+				testsupport.AssertEqual(t, buff.String(), tc.expected)
+			}
+		})
+	}
+}
+
+func TestHistory_CompactedChunk(t *testing.T) {
+	ctx := context.Background()
+	s := sqlitetest.New(t)
+	testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p", "d", "00000000001", nil, []byte("a")), nil)
+	testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p", "d", "00000000002", nil, []byte("b")), nil)
+
+	h, err := LoadHistory(ctx, s, "p", "d")
+	testsupport.MustAssertEqual(t, err, nil)
+	_, ok := h.CompactedChunk()
+	testsupport.AssertEqual(t, ok, false)
+
+	// While the covered chunks remain the state still spans several blobs.
+	testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p", "d", "00000000003", map[string]string{CompactedFromMetadataKey: "00000000001"}, []byte("abc")), nil)
+	h, err = LoadHistory(ctx, s, "p", "d")
+	testsupport.MustAssertEqual(t, err, nil)
+	_, ok = h.CompactedChunk()
+	testsupport.AssertEqual(t, ok, false)
+
+	testsupport.MustAssertEqual(t, s.DeleteBlobs(ctx, "p", "d", []string{"00000000001", "00000000002"}), nil)
+	h, err = LoadHistory(ctx, s, "p", "d")
+	testsupport.MustAssertEqual(t, err, nil)
+	id, ok := h.CompactedChunk()
+	testsupport.AssertEqual(t, ok, true)
+	testsupport.AssertEqual(t, id, "00000000003")
+}
+
+func TestSnapshots(t *testing.T) {
+	ctx := context.Background()
+	s := sqlitetest.New(t)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p", "d", "00000000001", nil, []byte("a")), nil)
+	testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p", "d", "00000000002", nil, []byte("b")), nil)
+
+	snapshot, err := CreateSnapshot(ctx, s, "p", "d", "latest", nil, now)
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, snapshot, &Snapshot{Name: "latest", ChunkId: "00000000002", CreatedAt: now})
+	first := chunkId(t, "00000000001")
+	_, err = CreateSnapshot(ctx, s, "p", "d", "first", &first, now)
+	testsupport.AssertEqual(t, err, nil)
+
+	_, err = CreateSnapshot(ctx, s, "p", "d", "first", nil, now)
+	testsupport.AssertErrorEqual(t, err, "snapshot already exists: 'first'")
+	_, err = CreateSnapshot(ctx, s, "p", "d", "no/slashes", nil, now)
+	testsupport.AssertEqual(t, errors.Is(err, ErrInvalidSnapshotName), true)
+	missing := chunkId(t, "00000000009")
+	_, err = CreateSnapshot(ctx, s, "p", "d", "future", &missing, now)
+	testsupport.AssertEqual(t, errors.Is(err, ErrHistoryUnavailable), true)
+
+	h, err := LoadHistory(ctx, s, "p", "d")
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, len(h.Chunks), 2)
+	testsupport.AssertEqual(t, h.Snapshots, []Snapshot{
+		{Name: "first", ChunkId: "00000000001", CreatedAt: now},
+		{Name: "latest", ChunkId: "00000000002", CreatedAt: now},
+	})
+
+	testsupport.AssertEqual(t, DeleteSnapshot(ctx, s, "p", "d", "first"), nil)
+	testsupport.AssertErrorEqual(t, DeleteSnapshot(ctx, s, "p", "d", "first"), "snapshot not found: 'first'")
+	h, err = LoadHistory(ctx, s, "p", "d")
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, len(h.Snapshots), 1)
+}
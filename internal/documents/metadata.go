@@ -0,0 +1,253 @@
+package documents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/astromechza/memory-mouse/internal/storage"
+	"github.com/astromechza/memory-mouse/internal/uid"
+)
+
+// MetadataBlobId is the empty blob whose metadata holds the user editable metadata of the document. It lives in the
+// document so that it is exported, migrated, and purged with it.
+const MetadataBlobId = "metadata"
+
+const (
+	titleMetadataKey       = "title"
+	descriptionMetadataKey = "description"
+	tagsMetadataKey        = "tags"
+	createdAtMetadataKey   = "created-at"
+	updatedAtMetadataKey   = "updated-at"
+	creatorMetadataKey     = "creator"
+)
+
+const (
+	maxTitleLength       = 200
+	maxDescriptionLength = 1000
+	maxTags              = 20
+	// maxMetadataBytes is the limit on the total size of the keys and values of the blob metadata in S3.
+	maxMetadataBytes = 2048
+)
+
+var ErrInvalidMetadata = errors.New("invalid metadata")
+
+var tagPattern = regexp.MustCompile(`^[A-Za-z0-9_:-]{1,32}$`)
+
+// Metadata is the user editable description of a document, along with the timestamps and creator.
+type Metadata struct {
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Tags        []string  `json:"tags"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	Creator     string    `json:"creator,omitempty"`
+}
+
+// MetadataPatch holds the fields to change, nil fields are left as they are.
+type MetadataPatch struct {
+	Title       *string   `json:"title"`
+	Description *string   `json:"description"`
+	Tags        *[]string `json:"tags"`
+}
+
+// DocumentInfo is a document in a listing.
+type DocumentInfo struct {
+	Id string `json:"id"`
+	Metadata
+}
+
+// defaultMetadata is the metadata of a document which has never been edited. The creation time comes from the
+// document uid when it is sortable.
+func defaultMetadata(documentId string) *Metadata {
+	m := &Metadata{Tags: []string{}}
+	if t, err := uid.DocumentUidTime(documentId); err == nil {
+		m.CreatedAt, m.UpdatedAt = t.UTC(), t.UTC()
+	}
+	return m
+}
+
+// encodeMetadata converts the metadata to blob metadata. The free text values are query escaped since S3 only allows
+// ASCII in metadata headers.
+func encodeMetadata(m *Metadata) map[string]string {
+	tags := make([]string, len(m.Tags))
+	for i, t := range m.Tags {
+		tags[i] = url.QueryEscape(t)
+	}
+	return map[string]string{
+		titleMetadataKey:       url.QueryEscape(m.Title),
+		descriptionMetadataKey: url.QueryEscape(m.Description),
+		tagsMetadataKey:        strings.Join(tags, ","),
+		createdAtMetadataKey:   m.CreatedAt.UTC().Format(time.RFC3339Nano),
+		updatedAtMetadataKey:   m.UpdatedAt.UTC().Format(time.RFC3339Nano),
+		creatorMetadataKey:     url.QueryEscape(m.Creator),
+	}
+}
+
+// decodeMetadata is the reverse of encodeMetadata. Values that can't be decoded are left raw rather than failing the
+// whole listing.
+func decodeMetadata(documentId string, raw map[string]string) *Metadata {
+	unescape := func(k string) string {
+		if v, err := url.QueryUnescape(raw[k]); err == nil {
+			return v
+		}
+		return raw[k]
+	}
+	m := defaultMetadata(documentId)
+	m.Title = unescape(titleMetadataKey)
+	m.Description = unescape(descriptionMetadataKey)
+	m.Creator = unescape(creatorMetadataKey)
+	if v := raw[tagsMetadataKey]; v != "" {
+		for _, t := range strings.Split(v, ",") {
+			if u, err := url.QueryUnescape(t); err == nil {
+				t = u
+			}
+			m.Tags = append(m.Tags, t)
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, raw[createdAtMetadataKey]); err == nil {
+		m.CreatedAt = t
+	}
+	if t, err := time.Parse(time.RFC3339, raw[updatedAtMetadataKey]); err == nil {
+		m.UpdatedAt = t
+	}
+	return m
+}
+
+// headMetadata reads the metadata of the document without checking that the document exists.
+func headMetadata(ctx context.Context, s storage.BlobStorage, projectId, documentId string) (*Metadata, bool, error) {
+	blob, err := s.HeadBlob(ctx, projectId, documentId, MetadataBlobId)
+	if errors.Is(err, storage.ErrBlobNotFound) || errors.Is(err, storage.ErrDocumentNotFound) {
+		return defaultMetadata(documentId), false, nil
+	} else if err != nil {
+		return nil, false, fmt.Errorf("failed to head metadata: %w", err)
+	}
+	return decodeMetadata(documentId, blob.Metadata), true, nil
+}
+
+// GetMetadata returns the metadata of the document, or storage.ErrDocumentNotFound.
+func GetMetadata(ctx context.Context, s storage.BlobStorage, projectId, documentId string) (*Metadata, error) {
+	m, found, err := headMetadata(ctx, s, projectId, documentId)
+	if err != nil {
+		return nil, err
+	} else if !found {
+		if _, err := s.ListBlobs(ctx, projectId, documentId); err != nil {
+			return nil, fmt.Errorf("failed to check document: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// CreateMetadata writes the initial metadata of a new document, recording the creator and creation time. Existing
+// metadata is kept as it is, so that importing an archive over a document doesn't change who created it.
+func CreateMetadata(ctx context.Context, s storage.BlobStorage, projectId, documentId, creator string, now time.Time) (*Metadata, error) {
+	if m, found, err := headMetadata(ctx, s, projectId, documentId); err != nil {
+		return nil, err
+	} else if found {
+		return m, nil
+	}
+	m := &Metadata{Tags: []string{}, CreatedAt: now.UTC(), UpdatedAt: now.UTC(), Creator: creator}
+	meta, err := validateMetadata(m)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.PutBlob(ctx, projectId, documentId, MetadataBlobId, meta, []byte{}); err != nil {
+		return nil, fmt.Errorf("failed to write metadata: %w", err)
+	}
+	return m, nil
+}
+
+// UpdateMetadata applies the patch to the metadata of the document and returns the result. The creator is only ever
+// set by CreateMetadata, documents created before the metadata existed keep an empty creator.
+func UpdateMetadata(ctx context.Context, s storage.BlobStorage, projectId, documentId string, patch MetadataPatch, now time.Time) (*Metadata, error) {
+	m, found, err := headMetadata(ctx, s, projectId, documentId)
+	if err != nil {
+		return nil, err
+	} else if !found {
+		if _, err := s.ListBlobs(ctx, projectId, documentId); err != nil {
+			return nil, fmt.Errorf("failed to check document: %w", err)
+		}
+		if m.CreatedAt.IsZero() {
+			m.CreatedAt = now.UTC()
+		}
+	}
+	if patch.Title != nil {
+		m.Title = *patch.Title
+	}
+	if patch.Description != nil {
+		m.Description = *patch.Description
+	}
+	if patch.Tags != nil {
+		m.Tags = append([]string{}, slices.Compact(slices.Sorted(slices.Values(*patch.Tags)))...)
+	}
+	m.UpdatedAt = now.UTC()
+	meta, err := validateMetadata(m)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.PutBlob(ctx, projectId, documentId, MetadataBlobId, meta, []byte{}); err != nil {
+		return nil, fmt.Errorf("failed to write metadata: %w", err)
+	}
+	return m, nil
+}
+
+// validateMetadata checks the limits of the fields and returns the encoded blob metadata.
+func validateMetadata(m *Metadata) (map[string]string, error) {
+	if n := utf8.RuneCountInString(m.Title); n > maxTitleLength {
+		return nil, fmt.Errorf("%w: title is %d characters but the limit is %d", ErrInvalidMetadata, n, maxTitleLength)
+	} else if n := utf8.RuneCountInString(m.Description); n > maxDescriptionLength {
+		return nil, fmt.Errorf("%w: description is %d characters but the limit is %d", ErrInvalidMetadata, n, maxDescriptionLength)
+	} else if len(m.Tags) > maxTags {
+		return nil, fmt.Errorf("%w: there are %d tags but the limit is %d", ErrInvalidMetadata, len(m.Tags), maxTags)
+	}
+	for _, t := range m.Tags {
+		if !tagPattern.MatchString(t) {
+			return nil, fmt.Errorf("%w: tag '%s' must be 1 to 32 letters, digits, '_', ':', or '-'", ErrInvalidMetadata, t)
+		}
+	}
+	meta := encodeMetadata(m)
+	var size int
+	for k, v := range meta {
+		size += len(k) + len(v)
+	}
+	if size > maxMetadataBytes {
+		return nil, fmt.Errorf("%w: the encoded metadata is %d bytes but the limit is %d", ErrInvalidMetadata, size, maxMetadataBytes)
+	}
+	return meta, nil
+}
+
+// ListDocuments lists up to limit documents in the project which are not trashed, along with their metadata, in the
+// order of storage.BlobStorage.IterDocumentIds. The ids are fetched a page at a time, so this stays cheap for large
+// projects. When there may be more documents, the last id is returned as the cursor to pass as StartAfter.
+func ListDocuments(ctx context.Context, s storage.BlobStorage, projectId string, opts storage.ListOptions, limit int) ([]DocumentInfo, string, error) {
+	hidden, err := trashedDocumentIds(ctx, s, projectId)
+	if err != nil {
+		return nil, "", err
+	}
+	if opts.PageSize <= 0 {
+		// One extra id is enough to tell whether there is another page.
+		opts.PageSize = limit + 1
+	}
+	out := make([]DocumentInfo, 0, limit)
+	for id, err := range s.IterDocumentIds(ctx, projectId, opts) {
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to list documents: %w", err)
+		} else if hidden[id] {
+			continue
+		} else if len(out) == limit {
+			return out, out[len(out)-1].Id, nil
+		}
+		m, _, err := headMetadata(ctx, s, projectId, id)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read metadata of %s: %w", id, err)
+		}
+		out = append(out, DocumentInfo{Id: id, Metadata: *m})
+	}
+	return out, "", nil
+}
@@ -0,0 +1,135 @@
+package documents
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/astromechza/memory-mouse/internal/storage"
+	"github.com/astromechza/memory-mouse/internal/storage/sqlitetest"
+	"github.com/astromechza/memory-mouse/internal/testsupport"
+	"github.com/astromechza/memory-mouse/internal/uid"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestMetadata(t *testing.T) {
+	ctx := context.Background()
+	s := sqlitetest.New(t)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	documentId := uid.DocumentUid()
+	created, err := uid.DocumentUidTime(documentId)
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p", documentId, "00000000001", nil, []byte("a")), nil)
+
+	_, err = GetMetadata(ctx, s, "p", "missing")
+	testsupport.AssertEqual(t, errors.Is(err, storage.ErrDocumentNotFound), true)
+	_, err = UpdateMetadata(ctx, s, "p", "missing", MetadataPatch{}, now)
+	testsupport.AssertEqual(t, errors.Is(err, storage.ErrDocumentNotFound), true)
+
+	m, err := GetMetadata(ctx, s, "p", documentId)
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, m, &Metadata{Tags: []string{}, CreatedAt: created.UTC(), UpdatedAt: created.UTC()})
+
+	m, err = UpdateMetadata(ctx, s, "p", documentId, MetadataPatch{
+		Title:       ptr("Plans, 2024 ✨"),
+		Description: ptr("line one\nline two"),
+		Tags:        ptr([]string{"work", "a:b", "work"}),
+	}, now)
+	testsupport.AssertEqual(t, err, nil)
+	expected := &Metadata{
+		Title: "Plans, 2024 ✨", Description: "line one\nline two", Tags: []string{"a:b", "work"},
+		CreatedAt: created.UTC(), UpdatedAt: now,
+	}
+	testsupport.AssertEqual(t, m, expected)
+	m, err = GetMetadata(ctx, s, "p", documentId)
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, m, expected)
+
+	// Later updates keep the fields that aren't patched.
+	m, err = UpdateMetadata(ctx, s, "p", documentId, MetadataPatch{Tags: ptr([]string{})}, now.Add(time.Hour))
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, m.Title, expected.Title)
+	testsupport.AssertEqual(t, m.Tags, []string{})
+	testsupport.AssertEqual(t, m.UpdatedAt, now.Add(time.Hour))
+
+	infos, next, err := ListDocuments(ctx, s, "p", storage.ListOptions{}, 10)
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, infos, []DocumentInfo{{Id: documentId, Metadata: *m}})
+	testsupport.AssertEqual(t, next, "")
+}
+
+func TestCreateMetadata(t *testing.T) {
+	ctx := context.Background()
+	s := sqlitetest.New(t)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	documentId := uid.DocumentUid()
+
+	m, err := CreateMetadata(ctx, s, "p", documentId, "alice", now)
+	testsupport.AssertEqual(t, err, nil)
+	expected := &Metadata{Tags: []string{}, CreatedAt: now, UpdatedAt: now, Creator: "alice"}
+	testsupport.AssertEqual(t, m, expected)
+	// The metadata blob alone makes the document exist.
+	m, err = GetMetadata(ctx, s, "p", documentId)
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, m, expected)
+
+	// Neither creating again nor updating changes the creator.
+	m, err = CreateMetadata(ctx, s, "p", documentId, "bob", now.Add(time.Hour))
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, m, expected)
+	m, err = UpdateMetadata(ctx, s, "p", documentId, MetadataPatch{Title: ptr("x")}, now.Add(time.Hour))
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, m.Creator, "alice")
+	testsupport.AssertEqual(t, m.CreatedAt, now)
+}
+
+func TestListDocuments_paged(t *testing.T) {
+	ctx := context.Background()
+	s := sqlitetest.New(t)
+	for _, d := range []string{"a", "b", "c", "d", "e"} {
+		testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p", d, "00000000001", nil, []byte(d)), nil)
+	}
+	testsupport.MustAssertEqual(t, Trash(ctx, s, "p", "b", time.Now()), nil)
+
+	var pages [][]string
+	var after string
+	for {
+		infos, next, err := ListDocuments(ctx, s, "p", storage.ListOptions{StartAfter: after}, 2)
+		testsupport.MustAssertEqual(t, err, nil)
+		var ids []string
+		for _, info := range infos {
+			ids = append(ids, info.Id)
+		}
+		pages = append(pages, ids)
+		if after = next; after == "" {
+			break
+		}
+	}
+	// The trashed document is skipped without leaving a short page, and the last page is not followed by an empty one.
+	testsupport.AssertEqual(t, pages, [][]string{{"a", "c"}, {"d", "e"}})
+}
+
+func TestMetadata_invalid(t *testing.T) {
+	ctx := context.Background()
+	s := sqlitetest.New(t)
+	testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p", "d", "00000000001", nil, []byte("a")), nil)
+	for _, tc := range []struct {
+		name  string
+		patch MetadataPatch
+		err   string
+	}{
+		{"title", MetadataPatch{Title: ptr(strings.Repeat("x", 201))}, "invalid metadata: title is 201 characters but the limit is 200"},
+		{"tag", MetadataPatch{Tags: ptr([]string{"no spaces"})}, "invalid metadata: tag 'no spaces' must be 1 to 32 letters, digits, '_', ':', or '-'"},
+		{"encoded size", MetadataPatch{Description: ptr(strings.Repeat("✨", 1000))}, "invalid metadata: the encoded metadata is 9087 bytes but the limit is 2048"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := UpdateMetadata(ctx, s, "p", "d", tc.patch, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+			testsupport.AssertErrorEqual(t, err, tc.err)
+		})
+	}
+}
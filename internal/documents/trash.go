@@ -0,0 +1,202 @@
+// Package documents implements document level operations on top of the blob storage.
+package documents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/astromechza/memory-mouse/internal/storage"
+)
+
+// TrashDocumentId is the reserved document which holds a tombstone blob for each trashed document in a project. The
+// blob id is the id of the trashed document. Keeping the tombstones together means that listing a project only needs
+// one extra request, rather than one per document. The underscore is not part of the document uid alphabet, so this
+// can never clash with a real document.
+const TrashDocumentId = "_trash"
+
+// trashedAtMetadataKey holds the time the document was trashed in RFC 3339 format.
+const trashedAtMetadataKey = "trashed-at"
+
+var ErrNotTrashed = errors.New("document is not trashed")
+
+// Trash marks the document as trashed so that it is hidden from listings until it is restored or purged. The blobs are
+// left in place. Trashing a document that is already trashed keeps the original time.
+func Trash(ctx context.Context, s storage.BlobStorage, projectId, documentId string, now time.Time) error {
+	if _, err := s.ListBlobs(ctx, projectId, documentId); err != nil {
+		return fmt.Errorf("failed to check document: %w", err)
+	}
+	if _, err := s.HeadBlob(ctx, projectId, TrashDocumentId, documentId); err == nil {
+		return nil
+	} else if !errors.Is(err, storage.ErrBlobNotFound) && !errors.Is(err, storage.ErrDocumentNotFound) {
+		return fmt.Errorf("failed to check tombstone: %w", err)
+	}
+	meta := map[string]string{trashedAtMetadataKey: now.UTC().Format(time.RFC3339)}
+	if err := s.PutBlob(ctx, projectId, TrashDocumentId, documentId, meta, []byte{}); err != nil {
+		return fmt.Errorf("failed to write tombstone: %w", err)
+	}
+	return nil
+}
+
+// IsTrashed returns whether the document has a tombstone. The document itself is not checked.
+func IsTrashed(ctx context.Context, s storage.BlobStorage, projectId, documentId string) (bool, error) {
+	if _, err := s.HeadBlob(ctx, projectId, TrashDocumentId, documentId); errors.Is(err, storage.ErrBlobNotFound) || errors.Is(err, storage.ErrDocumentNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to check tombstone: %w", err)
+	}
+	return true, nil
+}
+
+// Restore removes the tombstone of a trashed document. This returns ErrNotTrashed if there is no tombstone, or
+// storage.ErrDocumentNotFound if the document has already been purged.
+func Restore(ctx context.Context, s storage.BlobStorage, projectId, documentId string) error {
+	if _, err := s.HeadBlob(ctx, projectId, TrashDocumentId, documentId); errors.Is(err, storage.ErrBlobNotFound) || errors.Is(err, storage.ErrDocumentNotFound) {
+		if _, err := s.ListBlobs(ctx, projectId, documentId); err != nil {
+			return fmt.Errorf("failed to check document: %w", err)
+		}
+		return ErrNotTrashed
+	} else if err != nil {
+		return fmt.Errorf("failed to check tombstone: %w", err)
+	}
+	if err := s.DeleteBlobs(ctx, projectId, TrashDocumentId, []string{documentId}); err != nil && !errors.Is(err, storage.ErrDocumentNotFound) {
+		return fmt.Errorf("failed to remove tombstone: %w", err)
+	}
+	if _, err := s.ListBlobs(ctx, projectId, documentId); err != nil {
+		return fmt.Errorf("failed to check document: %w", err)
+	}
+	return nil
+}
+
+// TrashedDocument is a tombstone in the trash.
+type TrashedDocument struct {
+	DocumentId string
+	TrashedAt  time.Time
+}
+
+// ListTrash returns the trashed documents in the project. Tombstones with an unreadable time are returned with the
+// zero time so that they are purged on the next run rather than kept forever.
+func ListTrash(ctx context.Context, s storage.BlobStorage, projectId string) ([]TrashedDocument, error) {
+	blobs, err := s.ListBlobs(ctx, projectId, TrashDocumentId)
+	if errors.Is(err, storage.ErrDocumentNotFound) {
+		return []TrashedDocument{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to list trash: %w", err)
+	}
+	out := make([]TrashedDocument, 0, len(blobs))
+	for _, b := range blobs {
+		blob, err := s.HeadBlob(ctx, projectId, TrashDocumentId, b.Id)
+		if errors.Is(err, storage.ErrBlobNotFound) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read tombstone %s: %w", b.Id, err)
+		}
+		t, _ := time.Parse(time.RFC3339, blob.Metadata[trashedAtMetadataKey])
+		out = append(out, TrashedDocument{DocumentId: b.Id, TrashedAt: t})
+	}
+	slices.SortFunc(out, func(a, b TrashedDocument) int {
+		return a.TrashedAt.Compare(b.TrashedAt)
+	})
+	return out, nil
+}
+
+// ListDocumentIds lists the documents in the project which are not trashed, in the same order as the storage.
+func ListDocumentIds(ctx context.Context, s storage.BlobStorage, projectId string) ([]string, error) {
+	ids, err := s.ListDocumentIds(ctx, projectId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list documents: %w", err)
+	}
+	hidden, err := trashedDocumentIds(ctx, s, projectId)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(ids, func(id string) bool {
+		return hidden[id]
+	}), nil
+}
+
+// trashedDocumentIds returns the set of documents that are hidden from listings, including the trash itself.
+func trashedDocumentIds(ctx context.Context, s storage.BlobStorage, projectId string) (map[string]bool, error) {
+	trashed, err := s.ListBlobs(ctx, projectId, TrashDocumentId)
+	if err != nil && !errors.Is(err, storage.ErrDocumentNotFound) {
+		return nil, fmt.Errorf("failed to list trash: %w", err)
+	}
+	hidden := map[string]bool{TrashDocumentId: true}
+	for _, b := range trashed {
+		hidden[b.Id] = true
+	}
+	return hidden, nil
+}
+
+// PurgeTrash permanently deletes the documents in every project that were trashed before the retention period. The
+// blobs are deleted before the tombstone so that a failure part way through is retried on the next run. It returns
+// the number of documents purged.
+func PurgeTrash(ctx context.Context, s storage.BlobStorage, retention time.Duration, now time.Time) (int, error) {
+	projectIds, err := s.ListProjectIds(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list projects: %w", err)
+	}
+	var purged int
+	var errs []error
+	for _, projectId := range projectIds {
+		trashed, err := ListTrash(ctx, s, projectId)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, t := range trashed {
+			if now.Sub(t.TrashedAt) < retention {
+				// The list is sorted by time, so the rest are newer.
+				break
+			}
+			if err := purgeDocument(ctx, s, projectId, t.DocumentId); err != nil {
+				errs = append(errs, fmt.Errorf("failed to purge %s/%s: %w", projectId, t.DocumentId, err))
+				continue
+			}
+			slog.Info("purged trashed document", slog.String("project", projectId), slog.String("document", t.DocumentId), slog.Time("trashed_at", t.TrashedAt))
+			purged++
+		}
+	}
+	return purged, errors.Join(errs...)
+}
+
+func purgeDocument(ctx context.Context, s storage.BlobStorage, projectId, documentId string) error {
+	blobs, err := s.ListBlobs(ctx, projectId, documentId)
+	if err != nil && !errors.Is(err, storage.ErrDocumentNotFound) {
+		return fmt.Errorf("failed to list blobs: %w", err)
+	}
+	if len(blobs) > 0 {
+		ids := make([]string, len(blobs))
+		for i, b := range blobs {
+			ids[i] = b.Id
+		}
+		if err := s.DeleteBlobs(ctx, projectId, documentId, ids); err != nil && !errors.Is(err, storage.ErrDocumentNotFound) {
+			return fmt.Errorf("failed to delete blobs: %w", err)
+		}
+	}
+	if err := s.DeleteBlobs(ctx, projectId, TrashDocumentId, []string{documentId}); err != nil && !errors.Is(err, storage.ErrDocumentNotFound) {
+		return fmt.Errorf("failed to delete tombstone: %w", err)
+	}
+	return nil
+}
+
+// RunPurger calls PurgeTrash on every interval until the context is cancelled.
+func RunPurger(ctx context.Context, s storage.BlobStorage, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := PurgeTrash(ctx, s, retention, time.Now()); err != nil {
+				slog.Error("failed to purge trash", slog.Int("purged", n), slog.Any("err", err.Error()))
+			} else if n > 0 {
+				slog.Info("purged trash", slog.Int("purged", n))
+			}
+		}
+	}
+}
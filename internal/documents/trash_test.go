@@ -0,0 +1,81 @@
+package documents
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/astromechza/memory-mouse/internal/storage"
+	"github.com/astromechza/memory-mouse/internal/storage/sqlitetest"
+	"github.com/astromechza/memory-mouse/internal/testsupport"
+)
+
+func TestTrashAndRestore(t *testing.T) {
+	ctx := context.Background()
+	s := sqlitetest.New(t)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p", "a", "00000000001", nil, []byte("a")), nil)
+	testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p", "b", "00000000001", nil, []byte("b")), nil)
+
+	testsupport.AssertErrorEqual(t, Trash(ctx, s, "p", "missing", now), "failed to check document: document not found")
+	testsupport.AssertEqual(t, errors.Is(Restore(ctx, s, "p", "a"), ErrNotTrashed), true)
+
+	trashed, err := IsTrashed(ctx, s, "p", "a")
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, trashed, false)
+	testsupport.MustAssertEqual(t, Trash(ctx, s, "p", "a", now), nil)
+	trashed, err = IsTrashed(ctx, s, "p", "a")
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, trashed, true)
+	// Trashing again keeps the original time.
+	testsupport.MustAssertEqual(t, Trash(ctx, s, "p", "a", now.Add(time.Hour)), nil)
+
+	ids, err := ListDocumentIds(ctx, s, "p")
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, ids, []string{"b"})
+	tombstones, err := ListTrash(ctx, s, "p")
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, tombstones, []TrashedDocument{{DocumentId: "a", TrashedAt: now}})
+	// The blobs are untouched until the purge.
+	_, err = s.HeadBlob(ctx, "p", "a", "00000000001")
+	testsupport.AssertEqual(t, err, nil)
+
+	testsupport.MustAssertEqual(t, Restore(ctx, s, "p", "a"), nil)
+	ids, err = ListDocumentIds(ctx, s, "p")
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, ids, []string{"a", "b"})
+	tombstones, err = ListTrash(ctx, s, "p")
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, tombstones, []TrashedDocument{})
+}
+
+func TestPurgeTrash(t *testing.T) {
+	ctx := context.Background()
+	s := sqlitetest.New(t)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, d := range []string{"old", "new", "kept"} {
+		testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p", d, "00000000001", nil, []byte(d)), nil)
+		testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p", d, "00000000002", nil, []byte(d)), nil)
+	}
+	testsupport.MustAssertEqual(t, Trash(ctx, s, "p", "old", now.Add(-48*time.Hour)), nil)
+	testsupport.MustAssertEqual(t, Trash(ctx, s, "p", "new", now.Add(-time.Hour)), nil)
+
+	purged, err := PurgeTrash(ctx, s, 24*time.Hour, now)
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, purged, 1)
+
+	_, err = s.ListBlobs(ctx, "p", "old")
+	testsupport.AssertEqual(t, errors.Is(err, storage.ErrDocumentNotFound), true)
+	trashed, err := ListTrash(ctx, s, "p")
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, trashed, []TrashedDocument{{DocumentId: "new", TrashedAt: now.Add(-time.Hour)}})
+	testsupport.AssertEqual(t, errors.Is(Restore(ctx, s, "p", "old"), storage.ErrDocumentNotFound), true)
+
+	purged, err = PurgeTrash(ctx, s, 24*time.Hour, now.Add(24*time.Hour))
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, purged, 1)
+	ids, err := s.ListDocumentIds(ctx, "p")
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, ids, []string{"kept"})
+}
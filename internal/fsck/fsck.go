@@ -0,0 +1,301 @@
+// Package fsck checks the chunks of documents in storage for the inconsistencies that crashes can leave behind, and
+// repairs the ones that are safe to repair.
+//
+// A document is a sequence of chunk blobs named by uid.ChunkId starting at sequence 1. Compaction rewrites chunk N+n
+// with the changes of chunks N to N+n and records N in the CompactedFromMetadataKey metadata, before deleting chunks N
+// to N+n-1. So a gap in the sequence is expected as long as the chunk after it was compacted from the start of the gap,
+// and a chunk that is still present within the range of a later compacted chunk is redundant unless a named snapshot
+// still needs it.
+package fsck
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/astromechza/memory-mouse/internal/documents"
+	"github.com/astromechza/memory-mouse/internal/storage"
+	"github.com/astromechza/memory-mouse/internal/uid"
+)
+
+const (
+	// ChecksumMetadataKey holds the hex sha256 of the chunk content. It is optional, but verified when present.
+	ChecksumMetadataKey = documents.ChecksumMetadataKey
+	// CompactedFromMetadataKey holds the id of the first chunk that a compacted chunk contains the changes of.
+	CompactedFromMetadataKey = documents.CompactedFromMetadataKey
+)
+
+// automergeMagic is the magic number at the start of every automerge chunk, both for whole documents and for
+// incremental changes.
+var automergeMagic = []byte{0x85, 0x6f, 0x4a, 0x83}
+
+// ValidateAutomergeChunk is the default chunk validator, it only checks the magic number since a full parse requires
+// loading the document.
+func ValidateAutomergeChunk(content []byte) error {
+	if !bytes.HasPrefix(content, automergeMagic) {
+		return fmt.Errorf("missing automerge magic number")
+	}
+	return nil
+}
+
+type ProblemKind string
+
+const (
+	// MalformedBlobId is a blob whose id is not a uid.ChunkId. These are never repaired since they may not be ours.
+	MalformedBlobId ProblemKind = "malformed-blob-id"
+	// UnparsableChunk is a chunk whose content or metadata is not valid.
+	UnparsableChunk ProblemKind = "unparsable-chunk"
+	// ChecksumMismatch is a chunk whose content doesn't match the checksum in its metadata.
+	ChecksumMismatch ProblemKind = "checksum-mismatch"
+	// DuplicateSequence is a chunk that has the same sequence as a chunk from a later writer epoch.
+	DuplicateSequence ProblemKind = "duplicate-sequence"
+	// CoveredChunk is a chunk whose changes are already included in a later compacted chunk. These are repaired by
+	// deleting the chunk, completing the interrupted compaction, unless a snapshot needs it.
+	CoveredChunk ProblemKind = "covered-chunk"
+	// Gap is a range of sequences that is missing and not covered by a compacted chunk.
+	Gap ProblemKind = "gap"
+	// NoValidChunks is a document which has blobs but none of them are valid chunks, for example after an interrupted
+	// create. These are repaired by deleting the chunks only if every blob is an abandoned chunk, see chunk.abandoned.
+	// Chunks that fail the checksum or hold some other content may still be recoverable, so they are left alone.
+	NoValidChunks ProblemKind = "no-valid-chunks"
+)
+
+type Problem struct {
+	ProjectId  string      `json:"projectId"`
+	DocumentId string      `json:"documentId"`
+	BlobId     string      `json:"blobId,omitempty"`
+	Kind       ProblemKind `json:"kind"`
+	Detail     string      `json:"detail"`
+	Repaired   bool        `json:"repaired"`
+}
+
+type Options struct {
+	// Repair deletes covered chunks and the abandoned chunks of documents without any valid chunks.
+	Repair bool
+	// ValidateChunk checks the content of each chunk, it defaults to ValidateAutomergeChunk.
+	ValidateChunk func(content []byte) error
+	// Projects and Documents filter the ids to check, empty means all.
+	Projects  []string
+	Documents []string
+}
+
+// chunk is a blob with a valid chunk id.
+type chunk struct {
+	id            uid.ChunkId
+	blobId        string
+	valid         bool
+	compactedFrom *uid.ChunkId
+	// abandoned is set when the chunk has no checksum metadata and its content is empty or lacks the automerge magic
+	// number, which is what an interrupted create leaves behind.
+	abandoned bool
+}
+
+// CheckDocument checks a single document and returns the problems found. When repairing, the returned problems that
+// were fixed are marked as repaired.
+func CheckDocument(ctx context.Context, s storage.BlobStorage, projectId, documentId string, opts Options) ([]Problem, error) {
+	validate := opts.ValidateChunk
+	if validate == nil {
+		validate = ValidateAutomergeChunk
+	}
+	blobs, err := s.ListBlobs(ctx, projectId, documentId)
+	if errors.Is(err, storage.ErrDocumentNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to list blobs: %w", err)
+	}
+
+	var problems []Problem
+	// report adds a problem and returns its index so that it can be marked as repaired.
+	report := func(blobId string, kind ProblemKind, format string, args ...any) int {
+		problems = append(problems, Problem{ProjectId: projectId, DocumentId: documentId, BlobId: blobId, Kind: kind, Detail: fmt.Sprintf(format, args...)})
+		return len(problems) - 1
+	}
+
+	var chunks []*chunk
+	var snapshots []*documents.Snapshot
+	// contentBlobs counts the blobs other than the metadata, which is all that a newly created document has.
+	var contentBlobs int
+	for _, b := range blobs {
+		if b.Id == documents.MetadataBlobId {
+			continue
+		}
+		contentBlobs++
+		if documents.IsSnapshotBlobId(b.Id) {
+			blob, err := s.HeadBlob(ctx, projectId, documentId, b.Id)
+			if errors.Is(err, storage.ErrBlobNotFound) {
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("failed to head blob %s: %w", b.Id, err)
+			}
+			snapshot, err := documents.ParseSnapshot(blob)
+			if err != nil {
+				return nil, err
+			}
+			snapshots = append(snapshots, snapshot)
+			continue
+		}
+		id, err := uid.ParseChunkId(b.Id)
+		if err != nil {
+			report(b.Id, MalformedBlobId, "%s", err)
+			continue
+		}
+		buff := new(bytes.Buffer)
+		blob, err := s.GetBlob(ctx, projectId, documentId, b.Id, buff)
+		if errors.Is(err, storage.ErrBlobNotFound) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to get blob %s: %w", b.Id, err)
+		}
+		_, hasChecksum := blob.Metadata[ChecksumMetadataKey]
+		c := &chunk{id: id, blobId: b.Id, abandoned: !hasChecksum && !bytes.HasPrefix(buff.Bytes(), automergeMagic)}
+		chunks = append(chunks, c)
+		if expected, ok := blob.Metadata[ChecksumMetadataKey]; ok {
+			if sum := sha256.Sum256(buff.Bytes()); hex.EncodeToString(sum[:]) != expected {
+				report(b.Id, ChecksumMismatch, "content has checksum %x but the metadata has %s", sum, expected)
+				continue
+			}
+		}
+		if err := validate(buff.Bytes()); err != nil {
+			report(b.Id, UnparsableChunk, "%s", err)
+			continue
+		}
+		if raw, ok := blob.Metadata[CompactedFromMetadataKey]; ok {
+			from, err := uid.ParseChunkId(raw)
+			if err != nil {
+				report(b.Id, UnparsableChunk, "invalid %s metadata: %s", CompactedFromMetadataKey, err)
+				continue
+			} else if from.Sequence >= id.Sequence {
+				report(b.Id, UnparsableChunk, "%s metadata %s is not before the chunk", CompactedFromMetadataKey, raw)
+				continue
+			}
+			c.compactedFrom = &from
+		}
+		c.valid = true
+	}
+	slices.SortFunc(chunks, func(a, b *chunk) int {
+		return a.id.Compare(b.id)
+	})
+
+	// Any chunk within the range of a later valid compacted chunk is redundant, whether it is valid or not.
+	var deletes []string
+	covered := make(map[*chunk]bool)
+	for _, c := range chunks {
+		if !c.valid || c.compactedFrom == nil {
+			continue
+		}
+		for _, o := range chunks {
+			if o.id.Sequence >= c.compactedFrom.Sequence && o.id.Sequence < c.id.Sequence && !covered[o] {
+				covered[o] = true
+				if snapshot := snapshotBetween(snapshots, o.blobId, c.blobId); snapshot != nil {
+					report(o.blobId, CoveredChunk, "changes are included in compacted chunk %s but it is kept for snapshot '%s'", c.blobId, snapshot.Name)
+					continue
+				}
+				report(o.blobId, CoveredChunk, "changes are included in compacted chunk %s", c.blobId)
+				if opts.Repair {
+					deletes = append(deletes, o.blobId)
+				}
+			}
+		}
+	}
+
+	// The effective chunks are the valid ones that aren't covered, with only the latest epoch of each sequence.
+	var effective []*chunk
+	for i, c := range chunks {
+		if covered[c] || !c.valid {
+			continue
+		} else if i+1 < len(chunks) && chunks[i+1].id.Sequence == c.id.Sequence && !covered[chunks[i+1]] {
+			report(c.blobId, DuplicateSequence, "superseded by %s from a later writer epoch", chunks[i+1].blobId)
+			continue
+		}
+		effective = append(effective, c)
+	}
+
+	next := uint64(1)
+	for _, c := range effective {
+		start := c.id.Sequence
+		if c.compactedFrom != nil {
+			start = c.compactedFrom.Sequence
+		}
+		if start > next {
+			report(c.blobId, Gap, "missing sequences %d to %d before this chunk", next, start-1)
+		}
+		next = c.id.Sequence + 1
+	}
+
+	if len(effective) == 0 && contentBlobs > 0 {
+		i := report("", NoValidChunks, "none of the %d blobs are valid chunks", contentBlobs)
+		if opts.Repair && len(chunks) == contentBlobs && !slices.ContainsFunc(chunks, func(c *chunk) bool { return !c.abandoned }) {
+			deletes = deletes[:0]
+			for _, c := range chunks {
+				deletes = append(deletes, c.blobId)
+			}
+			problems[i].Repaired = true
+		}
+	}
+
+	if len(deletes) > 0 {
+		if err := s.DeleteBlobs(ctx, projectId, documentId, deletes); err != nil {
+			for i := range problems {
+				problems[i].Repaired = false
+			}
+			return problems, fmt.Errorf("failed to repair: %w", err)
+		}
+		for i := range problems {
+			if slices.Contains(deletes, problems[i].BlobId) {
+				problems[i].Repaired = true
+			}
+		}
+	}
+	return problems, nil
+}
+
+// snapshotBetween returns a snapshot at or after the covered chunk but before the compacted chunk, which needs the
+// covered chunk to rebuild its state. Canonical chunk ids sort in logical order.
+func snapshotBetween(snapshots []*documents.Snapshot, covered, compacted string) *documents.Snapshot {
+	for _, s := range snapshots {
+		if s.ChunkId >= covered && s.ChunkId < compacted {
+			return s
+		}
+	}
+	return nil
+}
+
+// Check checks every document that matches the filters and calls the report function for each problem. It returns
+// the number of documents checked.
+func Check(ctx context.Context, s storage.BlobStorage, opts Options, report func(p Problem)) (int, error) {
+	projectIds := opts.Projects
+	if len(projectIds) == 0 {
+		var err error
+		if projectIds, err = s.ListProjectIds(ctx); err != nil {
+			return 0, fmt.Errorf("failed to list projects: %w", err)
+		}
+	}
+	var checked int
+	for _, projectId := range projectIds {
+		documentIds, err := s.ListDocumentIds(ctx, projectId)
+		if err != nil {
+			return checked, fmt.Errorf("failed to list documents: %w", err)
+		}
+		for _, documentId := range documentIds {
+			if documentId == documents.TrashDocumentId {
+				// The trash holds tombstones rather than chunks.
+				continue
+			} else if len(opts.Documents) > 0 && !slices.Contains(opts.Documents, documentId) {
+				continue
+			}
+			problems, err := CheckDocument(ctx, s, projectId, documentId, opts)
+			for _, p := range problems {
+				report(p)
+			}
+			if err != nil {
+				return checked, fmt.Errorf("failed to check %s/%s: %w", projectId, documentId, err)
+			}
+			checked++
+		}
+	}
+	return checked, nil
+}
@@ -0,0 +1,191 @@
+package fsck
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/astromechza/memory-mouse/internal/storage/sqlitetest"
+	"github.com/astromechza/memory-mouse/internal/testsupport"
+)
+
+func validChunk(content string) []byte {
+	return append(slices.Clone(automergeMagic), content...)
+}
+
+type testBlob struct {
+	id      string
+	meta    map[string]string
+	content []byte
+}
+
+func compacted(from string) map[string]string {
+	return map[string]string{CompactedFromMetadataKey: from}
+}
+
+func TestCheckDocument(t *testing.T) {
+	sum := sha256.Sum256(validChunk("a"))
+	for _, tc := range []struct {
+		name     string
+		blobs    []testBlob
+		expected []Problem
+	}{
+		{"healthy", []testBlob{
+			{"00000000001", map[string]string{ChecksumMetadataKey: hex.EncodeToString(sum[:])}, validChunk("a")},
+			{"00000000002", nil, validChunk("b")},
+		}, nil},
+		{"metadata", []testBlob{
+			{"00000000001", nil, validChunk("a")},
+			{"metadata", map[string]string{"title": "x"}, []byte{}},
+		}, nil},
+		{"no valid chunks with checksum mismatch", []testBlob{
+			{"00000000001", map[string]string{ChecksumMetadataKey: "00"}, validChunk("a")},
+		}, []Problem{
+			{BlobId: "00000000001", Kind: ChecksumMismatch, Detail: fmt.Sprintf("content has checksum %x but the metadata has 00", sha256.Sum256(validChunk("a")))},
+			{Kind: NoValidChunks, Detail: "none of the 1 blobs are valid chunks"},
+		}},
+		{"no valid chunks with checksummed content", []testBlob{
+			{"00000000001", nil, []byte{}},
+			{"00000000002", map[string]string{ChecksumMetadataKey: fmt.Sprintf("%x", sha256.Sum256([]byte("nope")))}, []byte("nope")},
+		}, []Problem{
+			{BlobId: "00000000001", Kind: UnparsableChunk, Detail: "missing automerge magic number"},
+			{BlobId: "00000000002", Kind: UnparsableChunk, Detail: "missing automerge magic number"},
+			{Kind: NoValidChunks, Detail: "none of the 2 blobs are valid chunks"},
+		}},
+		{"new document", []testBlob{
+			{"metadata", map[string]string{"creator": "alice"}, []byte{}},
+		}, nil},
+		{"no valid chunks with metadata", []testBlob{
+			{"00000000001", nil, []byte{}},
+			{"metadata", map[string]string{"creator": "alice"}, []byte{}},
+		}, []Problem{
+			{BlobId: "00000000001", Kind: UnparsableChunk, Detail: "missing automerge magic number", Repaired: true},
+			{Kind: NoValidChunks, Detail: "none of the 1 blobs are valid chunks", Repaired: true},
+		}},
+		{"compacted gap", []testBlob{
+			{"00000000003", compacted("00000000001"), validChunk("a")},
+			{"00000000004", nil, validChunk("b")},
+		}, nil},
+		{"malformed and unparsable", []testBlob{
+			{"00000000001", nil, validChunk("a")},
+			{"00000000002", nil, []byte("nope")},
+			{"junk", nil, validChunk("b")},
+		}, []Problem{
+			{BlobId: "00000000002", Kind: UnparsableChunk, Detail: "missing automerge magic number"},
+			{BlobId: "junk", Kind: MalformedBlobId, Detail: "invalid chunk id 'junk': sequence must be 11 digits"},
+		}},
+		{"checksum", []testBlob{
+			{"00000000001", map[string]string{ChecksumMetadataKey: "00"}, validChunk("b")},
+			{"00000000002", nil, validChunk("b")},
+		}, []Problem{
+			{BlobId: "00000000001", Kind: ChecksumMismatch, Detail: fmt.Sprintf("content has checksum %x but the metadata has 00", sha256.Sum256(validChunk("b")))},
+			{BlobId: "00000000002", Kind: Gap, Detail: "missing sequences 1 to 1 before this chunk"},
+		}},
+		{"gap", []testBlob{
+			{"00000000001", nil, validChunk("a")},
+			{"00000000005", compacted("00000000004"), validChunk("b")},
+		}, []Problem{
+			{BlobId: "00000000005", Kind: Gap, Detail: "missing sequences 2 to 3 before this chunk"},
+		}},
+		{"interrupted compaction", []testBlob{
+			{"00000000001", nil, validChunk("a")},
+			{"00000000002", nil, validChunk("b")},
+			{"00000000003", compacted("00000000001"), validChunk("c")},
+		}, []Problem{
+			{BlobId: "00000000001", Kind: CoveredChunk, Detail: "changes are included in compacted chunk 00000000003", Repaired: true},
+			{BlobId: "00000000002", Kind: CoveredChunk, Detail: "changes are included in compacted chunk 00000000003", Repaired: true},
+		}},
+		{"interrupted compaction with snapshot", []testBlob{
+			{"00000000001", nil, validChunk("a")},
+			{"00000000002", nil, validChunk("b")},
+			{"00000000003", compacted("00000000001"), validChunk("c")},
+			{"snapshot.first", map[string]string{"snapshot-chunk": "00000000001"}, []byte{}},
+		}, []Problem{
+			{BlobId: "00000000001", Kind: CoveredChunk, Detail: "changes are included in compacted chunk 00000000003 but it is kept for snapshot 'first'"},
+			{BlobId: "00000000002", Kind: CoveredChunk, Detail: "changes are included in compacted chunk 00000000003", Repaired: true},
+		}},
+		{"bad compacted from", []testBlob{
+			{"00000000001", nil, validChunk("a")},
+			{"00000000002", compacted("00000000002"), validChunk("b")},
+		}, []Problem{
+			{BlobId: "00000000002", Kind: UnparsableChunk, Detail: "compacted-from metadata 00000000002 is not before the chunk"},
+		}},
+		{"duplicate", []testBlob{
+			{"00000000001", nil, validChunk("a")},
+			{"00000000001-0000000002", nil, validChunk("b")},
+		}, []Problem{
+			{BlobId: "00000000001", Kind: DuplicateSequence, Detail: "superseded by 00000000001-0000000002 from a later writer epoch"},
+		}},
+		{"no valid chunks", []testBlob{
+			{"00000000001", nil, []byte{}},
+		}, []Problem{
+			{BlobId: "00000000001", Kind: UnparsableChunk, Detail: "missing automerge magic number", Repaired: true},
+			{Kind: NoValidChunks, Detail: "none of the 1 blobs are valid chunks", Repaired: true},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := sqlitetest.New(t)
+			ctx := context.Background()
+			for _, b := range tc.blobs {
+				testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p", "d", b.id, b.meta, b.content), nil)
+			}
+			for i := range tc.expected {
+				tc.expected[i].ProjectId, tc.expected[i].DocumentId = "p", "d"
+			}
+			unrepaired := slices.Clone(tc.expected)
+			for i := range unrepaired {
+				unrepaired[i].Repaired = false
+			}
+
+			problems, err := CheckDocument(ctx, s, "p", "d", Options{})
+			testsupport.AssertEqual(t, err, nil)
+			testsupport.AssertEqual(t, problems, unrepaired)
+
+			problems, err = CheckDocument(ctx, s, "p", "d", Options{Repair: true})
+			testsupport.AssertEqual(t, err, nil)
+			testsupport.AssertEqual(t, problems, tc.expected)
+
+			// Everything that was repaired is gone on the next check.
+			var remaining []Problem
+			for _, p := range tc.expected {
+				if !p.Repaired {
+					remaining = append(remaining, p)
+				}
+			}
+			problems, err = CheckDocument(ctx, s, "p", "d", Options{})
+			testsupport.AssertEqual(t, err, nil)
+			testsupport.AssertEqual(t, problems, remaining)
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	s := sqlitetest.New(t)
+	ctx := context.Background()
+	testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p1", "d1", "00000000001", nil, validChunk("a")), nil)
+	testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p1", "d2", "00000000002", nil, validChunk("a")), nil)
+	testsupport.MustAssertEqual(t, s.PutBlob(ctx, "p2", "d3", "x", nil, validChunk("a")), nil)
+
+	var problems []Problem
+	checked, err := Check(ctx, s, Options{}, func(p Problem) {
+		problems = append(problems, p)
+	})
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, checked, 3)
+	testsupport.AssertEqual(t, len(problems), 3)
+	testsupport.AssertEqual(t, problems[0].Kind, Gap)
+	testsupport.AssertEqual(t, problems[1].Kind, MalformedBlobId)
+	testsupport.AssertEqual(t, problems[2].Kind, NoValidChunks)
+	testsupport.AssertEqual(t, problems[2].Repaired, false)
+
+	problems = nil
+	checked, err = Check(ctx, s, Options{Projects: []string{"p1"}, Documents: []string{"d1"}}, func(p Problem) {
+		problems = append(problems, p)
+	})
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, checked, 1)
+	testsupport.AssertEqual(t, len(problems), 0)
+}
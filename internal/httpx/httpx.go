@@ -0,0 +1,37 @@
+// Package httpx holds the small http helpers that are shared by the middleware packages.
+package httpx
+
+import (
+	"net/http"
+)
+
+// StatusWriter records the status code written to the response, for middleware that reports on the response after
+// the handler has returned.
+type StatusWriter struct {
+	http.ResponseWriter
+	// Status is the first status code written, or 200 if the handler only wrote the body or nothing at all.
+	Status      int
+	wroteHeader bool
+}
+
+func NewStatusWriter(w http.ResponseWriter) *StatusWriter {
+	return &StatusWriter{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (w *StatusWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.Status = statusCode
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *StatusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer for flushing and hijacking.
+func (w *StatusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,29 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/astromechza/memory-mouse/internal/testsupport"
+)
+
+func TestStatusWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := NewStatusWriter(rec)
+	testsupport.AssertEqual(t, w.Status, http.StatusOK)
+	w.WriteHeader(http.StatusTeapot)
+	w.WriteHeader(http.StatusInternalServerError)
+	testsupport.AssertEqual(t, w.Status, http.StatusTeapot)
+
+	// Writing the body first implies a 200.
+	w = NewStatusWriter(httptest.NewRecorder())
+	_, _ = w.Write([]byte("ok"))
+	w.WriteHeader(http.StatusTeapot)
+	testsupport.AssertEqual(t, w.Status, http.StatusOK)
+
+	// The response controller reaches the recorder through Unwrap.
+	w = NewStatusWriter(rec)
+	testsupport.AssertEqual(t, http.NewResponseController(w).Flush(), nil)
+	testsupport.AssertEqual(t, rec.Flushed, true)
+}
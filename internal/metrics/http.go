@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/astromechza/memory-mouse/internal/httpx"
+)
+
+// unmatchedRoute is the route label for requests that did not match any pattern on the mux. This keeps the cardinality
+// of the route label bounded no matter what paths clients request.
+const unmatchedRoute = "unmatched"
+
+// otherMethod is the method label for requests with a method outside of knownMethods, since clients can send any
+// method and the mux answers all of them.
+const otherMethod = "other"
+
+var knownMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
+// methodLabel returns the method if it is one of the known methods, or otherMethod.
+func methodLabel(method string) string {
+	if slices.Contains(knownMethods, method) {
+		return method
+	}
+	return otherMethod
+}
+
+// InstrumentHandler wraps the handler, which is expected to be a http.ServeMux, and records the number and latency of
+// requests by route. The route is the mux pattern that matched the request rather than the raw path, so that the
+// document ids in the path don't create a new series per document.
+func InstrumentHandler(registry *Registry, next http.Handler) http.Handler {
+	requests := registry.NewCounterVec("memorymouse_http_requests_total", "Number of http requests by route, method, and status code.", "route", "method", "code")
+	latency := registry.NewHistogramVec("memorymouse_http_request_duration_seconds", "Latency of http requests by route.", nil, "route")
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		sw := httpx.NewStatusWriter(writer)
+		next.ServeHTTP(sw, request)
+		// The mux sets the pattern on the request once it has been routed.
+		route := request.Pattern
+		if route == "" {
+			route = unmatchedRoute
+		}
+		requests.With(route, methodLabel(request.Method), strconv.Itoa(sw.Status)).Inc()
+		latency.With(route).Observe(time.Since(start).Seconds())
+	})
+}
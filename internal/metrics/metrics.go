@@ -0,0 +1,314 @@
+// Package metrics is a small implementation of counters, gauges, and histograms which can be exposed in the Prometheus
+// text exposition format (https://prometheus.io/docs/instrumenting/exposition_formats/). It only implements what this
+// project needs so that we don't need to pull in the full client library.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets are the default histogram buckets in seconds, these match the Prometheus client defaults.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds a set of metric families and renders them in the text exposition format.
+type Registry struct {
+	lock     sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+type family struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+	buckets    []float64
+
+	lock   sync.Mutex
+	series map[string]*series
+}
+
+// series is a single set of label values within a family. Counters and gauges use the value, while histograms use the
+// bucket counts, sum, and count under the lock.
+type series struct {
+	labelValues []string
+	value       atomic.Uint64
+
+	lock         sync.Mutex
+	bounds       []float64
+	bucketCounts []uint64
+	sum          float64
+	count        uint64
+}
+
+func (s *series) add(v float64) {
+	for {
+		old := s.value.Load()
+		if s.value.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func (s *series) get() float64 {
+	return math.Float64frombits(s.value.Load())
+}
+
+// register returns the existing family with the given name or creates a new one. Registering the same name twice with
+// a different kind or labels is a programming error and panics.
+func (r *Registry) register(name, help, kind string, buckets []float64, labelNames []string) *family {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if f, ok := r.families[name]; ok {
+		if f.kind != kind || !slices.Equal(f.labelNames, labelNames) {
+			panic(fmt.Sprintf("metric %s is already registered as a different %s", name, f.kind))
+		}
+		return f
+	}
+	f := &family{name: name, help: help, kind: kind, labelNames: labelNames, buckets: buckets, series: make(map[string]*series)}
+	r.families[name] = f
+	return f
+}
+
+func (f *family) with(labelValues []string) *series {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\x00")
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: slices.Clone(labelValues), bounds: f.buckets, bucketCounts: make([]uint64, len(f.buckets))}
+		f.series[key] = s
+	}
+	return s
+}
+
+// CounterVec is a family of counters partitioned by label values.
+type CounterVec struct{ f *family }
+
+// Counter is a monotonically increasing value.
+type Counter struct{ s *series }
+
+// NewCounterVec registers or returns the counter family with the given name.
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{f: r.register(name, help, "counter", nil, labelNames)}
+}
+
+// NewCounter registers or returns a counter without any labels.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+func (c *CounterVec) With(labelValues ...string) *Counter {
+	return &Counter{s: c.f.with(labelValues)}
+}
+
+func (c *Counter) Inc() {
+	c.s.add(1)
+}
+
+// Add increases the counter, negative values are ignored.
+func (c *Counter) Add(v float64) {
+	if v > 0 {
+		c.s.add(v)
+	}
+}
+
+func (c *Counter) Value() float64 {
+	return c.s.get()
+}
+
+// GaugeVec is a family of gauges partitioned by label values.
+type GaugeVec struct{ f *family }
+
+// Gauge is a value that can go up and down.
+type Gauge struct{ s *series }
+
+// NewGaugeVec registers or returns the gauge family with the given name.
+func (r *Registry) NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{f: r.register(name, help, "gauge", nil, labelNames)}
+}
+
+// NewGauge registers or returns a gauge without any labels.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.NewGaugeVec(name, help).With()
+}
+
+func (g *GaugeVec) With(labelValues ...string) *Gauge {
+	return &Gauge{s: g.f.with(labelValues)}
+}
+
+func (g *Gauge) Set(v float64) {
+	g.s.value.Store(math.Float64bits(v))
+}
+
+func (g *Gauge) Add(v float64) {
+	g.s.add(v)
+}
+
+func (g *Gauge) Inc() {
+	g.s.add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.s.add(-1)
+}
+
+func (g *Gauge) Value() float64 {
+	return g.s.get()
+}
+
+// HistogramVec is a family of histograms partitioned by label values.
+type HistogramVec struct{ f *family }
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct{ s *series }
+
+// NewHistogramVec registers or returns the histogram family with the given name. If the buckets are nil, the
+// DefaultBuckets are used.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	return &HistogramVec{f: r.register(name, help, "histogram", slices.Sorted(slices.Values(buckets)), labelNames)}
+}
+
+func (h *HistogramVec) With(labelValues ...string) *Histogram {
+	return &Histogram{s: h.f.with(labelValues)}
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.s.lock.Lock()
+	defer h.s.lock.Unlock()
+	h.s.count++
+	h.s.sum += v
+	for i, bound := range h.s.bounds {
+		if v <= bound {
+			h.s.bucketCounts[i]++
+		}
+	}
+}
+
+// Count returns the number of observations.
+func (h *Histogram) Count() uint64 {
+	h.s.lock.Lock()
+	defer h.s.lock.Unlock()
+	return h.s.count
+}
+
+// Sum returns the sum of the observations.
+func (h *Histogram) Sum() float64 {
+	h.s.lock.Lock()
+	defer h.s.lock.Unlock()
+	return h.s.sum
+}
+
+// WriteText writes all the metric families in the Prometheus text exposition format, sorted by name and label values.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.lock.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.lock.Unlock()
+	slices.SortFunc(families, func(a, b *family) int {
+		return strings.Compare(a.name, b.name)
+	})
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		_, _ = fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.kind)
+		f.lock.Lock()
+		all := make([]*series, 0, len(f.series))
+		for _, s := range f.series {
+			all = append(all, s)
+		}
+		f.lock.Unlock()
+		slices.SortFunc(all, func(a, b *series) int {
+			return slices.Compare(a.labelValues, b.labelValues)
+		})
+		for _, s := range all {
+			labels := formatLabels(f.labelNames, s.labelValues)
+			if f.kind != "histogram" {
+				_, _ = fmt.Fprintf(bw, "%s%s %s\n", f.name, wrapLabels(labels), formatFloat(s.get()))
+				continue
+			}
+			s.lock.Lock()
+			for i, bound := range f.buckets {
+				_, _ = fmt.Fprintf(bw, "%s_bucket%s %d\n", f.name, wrapLabels(joinLabels(labels, `le="`+formatFloat(bound)+`"`)), s.bucketCounts[i])
+			}
+			_, _ = fmt.Fprintf(bw, "%s_bucket%s %d\n", f.name, wrapLabels(joinLabels(labels, `le="+Inf"`)), s.count)
+			_, _ = fmt.Fprintf(bw, "%s_sum%s %s\n", f.name, wrapLabels(labels), formatFloat(s.sum))
+			_, _ = fmt.Fprintf(bw, "%s_count%s %d\n", f.name, wrapLabels(labels), s.count)
+			s.lock.Unlock()
+		}
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP serves the metrics in the text exposition format.
+func (r *Registry) ServeHTTP(writer http.ResponseWriter, _ *http.Request) {
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = r.WriteText(writer)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+func formatLabels(names, values []string) string {
+	parts := make([]string, len(names))
+	for i := range names {
+		parts[i] = names[i] + `="` + escapeLabelValue(values[i]) + `"`
+	}
+	return strings.Join(parts, ",")
+}
+
+func joinLabels(labels, extra string) string {
+	if labels == "" {
+		return extra
+	}
+	return labels + "," + extra
+}
+
+func wrapLabels(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(v string) string {
+	return helpEscaper.Replace(v)
+}
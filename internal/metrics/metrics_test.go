@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/astromechza/memory-mouse/internal/testsupport"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_requests_total", "Number of requests.", "code")
+	c.With("500").Inc()
+	c.With("200").Add(2)
+	c.With("200").Add(-1)
+	g := r.NewGauge("test_in_flight", "Requests in\nflight.")
+	g.Inc()
+	g.Inc()
+	g.Dec()
+	h := r.NewHistogramVec("test_latency_seconds", "Latency.", []float64{1, 0.1}, "path")
+	h.With(`a"b`).Observe(0.05)
+	h.With(`a"b`).Observe(0.5)
+	h.With(`a"b`).Observe(5)
+
+	buff := new(bytes.Buffer)
+	testsupport.MustAssertEqual(t, r.WriteText(buff), nil)
+	testsupport.AssertEqual(t, buff.String(), `# HELP test_in_flight Requests in\nflight.
+# TYPE test_in_flight gauge
+test_in_flight 1
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{path="a\"b",le="0.1"} 1
+test_latency_seconds_bucket{path="a\"b",le="1"} 2
+test_latency_seconds_bucket{path="a\"b",le="+Inf"} 3
+test_latency_seconds_sum{path="a\"b"} 5.55
+test_latency_seconds_count{path="a\"b"} 3
+# HELP test_requests_total Number of requests.
+# TYPE test_requests_total counter
+test_requests_total{code="200"} 2
+test_requests_total{code="500"} 1
+`)
+}
+
+func TestRegister_existing(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("x_total", "X.", "a").With("b").Inc()
+	r.NewCounterVec("x_total", "X.", "a").With("b").Inc()
+	testsupport.AssertEqual(t, r.NewCounterVec("x_total", "X.", "a").With("b").Value(), 2.0)
+	defer func() {
+		testsupport.AssertEqual(t, recover(), any("metric x_total is already registered as a different counter"))
+	}()
+	r.NewGauge("x_total", "X.")
+}
+
+func TestInstrumentHandler(t *testing.T) {
+	r := NewRegistry()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /documents/{id}", func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusTeapot)
+	})
+	mux.HandleFunc("GET /ok", func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = writer.Write([]byte("ok"))
+	})
+	h := InstrumentHandler(r, mux)
+	for _, path := range []string{"/documents/a", "/documents/b", "/ok", "/missing"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	for _, method := range []string{"BREW", "brew", http.MethodTrace} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/ok", nil))
+	}
+	requests := r.NewCounterVec("memorymouse_http_requests_total", "", "route", "method", "code")
+	testsupport.AssertEqual(t, requests.With("unmatched", "other", "405").Value(), 3.0)
+	testsupport.AssertEqual(t, requests.With("GET /documents/{id}", "GET", "418").Value(), 2.0)
+	testsupport.AssertEqual(t, requests.With("GET /ok", "GET", "200").Value(), 1.0)
+	testsupport.AssertEqual(t, requests.With("unmatched", "GET", "404").Value(), 1.0)
+	latency := r.NewHistogramVec("memorymouse_http_request_duration_seconds", "", nil, "route")
+	testsupport.AssertEqual(t, latency.With("GET /documents/{id}").Count(), uint64(2))
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	testsupport.AssertEqual(t, rec.Header().Get("Content-Type"), "text/plain; version=0.0.4; charset=utf-8")
+	testsupport.AssertContains(t, strings.Split(rec.Body.String(), "\n"), `memorymouse_http_requests_total{route="GET /ok",method="GET",code="200"} 1`)
+	testsupport.AssertEqual(t, strings.Contains(rec.Body.String(), "BREW"), false)
+}
@@ -0,0 +1,136 @@
+// Package metered provides a storage.BlobStorage decorator which records the latency and outcome of every call, so that
+// each backend gets the same metrics without having to instrument them individually.
+package metered
+
+import (
+	"context"
+	"errors"
+	"io"
+	"iter"
+	"time"
+
+	"github.com/astromechza/memory-mouse/internal/metrics"
+	"github.com/astromechza/memory-mouse/internal/storage"
+)
+
+// Storage wraps another storage.BlobStorage and records metrics for each call.
+type Storage struct {
+	inner      storage.BlobStorage
+	backend    string
+	operations *metrics.CounterVec
+	latency    *metrics.HistogramVec
+}
+
+var _ storage.BlobStorage = (*Storage)(nil)
+var _ storage.BlobStreamer = (*Storage)(nil)
+
+// New wraps the inner storage and registers the storage metrics in the registry. The backend name is used as a label,
+// so multiple backends can share the same registry. If the inner storage implements storage.BlobPresigner, so does the
+// returned storage.
+func New(inner storage.BlobStorage, backend string, registry *metrics.Registry) storage.BlobStorage {
+	s := &Storage{
+		inner:      inner,
+		backend:    backend,
+		operations: registry.NewCounterVec("memorymouse_storage_operations_total", "Number of blob storage operations by backend, method, and result.", "backend", "method", "result"),
+		latency:    registry.NewHistogramVec("memorymouse_storage_operation_duration_seconds", "Latency of blob storage operations by backend and method.", nil, "backend", "method"),
+	}
+	if p, ok := inner.(storage.BlobPresigner); ok {
+		return &presignerStorage{Storage: s, BlobPresigner: p}
+	}
+	return s
+}
+
+// presignerStorage exposes the presigner of the inner storage. Presigning is a local calculation, so it isn't measured.
+type presignerStorage struct {
+	*Storage
+	storage.BlobPresigner
+}
+
+// Unwrap returns the inner storage.
+func (s *Storage) Unwrap() storage.BlobStorage {
+	return s.inner
+}
+
+// result converts the error into a low cardinality label value. Not found errors are expected during normal operation,
+// so they are kept separate from real failures.
+func result(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, storage.ErrDocumentNotFound), errors.Is(err, storage.ErrBlobNotFound):
+		return "not_found"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	default:
+		return "error"
+	}
+}
+
+func (s *Storage) observe(method string, start time.Time, err error) {
+	s.observeDuration(method, time.Since(start), err)
+}
+
+func (s *Storage) observeDuration(method string, d time.Duration, err error) {
+	s.latency.With(s.backend, method).Observe(d.Seconds())
+	s.operations.With(s.backend, method, result(err)).Inc()
+}
+
+func (s *Storage) ListProjectIds(ctx context.Context) (projectIds []string, err error) {
+	defer func(start time.Time) { s.observe("ListProjectIds", start, err) }(time.Now())
+	return s.inner.ListProjectIds(ctx)
+}
+
+func (s *Storage) ListDocumentIds(ctx context.Context, projectId string) (documentIds []string, err error) {
+	defer func(start time.Time) { s.observe("ListDocumentIds", start, err) }(time.Now())
+	return s.inner.ListDocumentIds(ctx, projectId)
+}
+
+func (s *Storage) IterProjectIds(ctx context.Context, opts storage.ListOptions) iter.Seq2[string, error] {
+	return s.observeIter("IterProjectIds", s.inner.IterProjectIds(ctx, opts))
+}
+
+func (s *Storage) IterDocumentIds(ctx context.Context, projectId string, opts storage.ListOptions) iter.Seq2[string, error] {
+	return s.observeIter("IterDocumentIds", s.inner.IterDocumentIds(ctx, projectId, opts))
+}
+
+// observeIter records a single operation covering the whole iteration, until it completes or the caller stops. The
+// latency only includes the time spent in the inner sequence, not the time the caller spends on each id.
+func (s *Storage) observeIter(method string, seq iter.Seq2[string, error]) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		var err error
+		d := storage.YieldTimed(seq, yield, func(_ string, e error) {
+			err = e
+		})
+		s.observeDuration(method, d, err)
+	}
+}
+
+func (s *Storage) ListBlobs(ctx context.Context, projectId, documentId string) (blobs []storage.BlobIdAndSize, err error) {
+	defer func(start time.Time) { s.observe("ListBlobs", start, err) }(time.Now())
+	return s.inner.ListBlobs(ctx, projectId, documentId)
+}
+
+func (s *Storage) PutBlob(ctx context.Context, projectId, documentId, blobId string, meta map[string]string, blob []byte) (err error) {
+	defer func(start time.Time) { s.observe("PutBlob", start, err) }(time.Now())
+	return s.inner.PutBlob(ctx, projectId, documentId, blobId, meta, blob)
+}
+
+func (s *Storage) PutBlobFromReader(ctx context.Context, projectId, documentId, blobId string, meta map[string]string, src io.Reader, size int64) (err error) {
+	defer func(start time.Time) { s.observe("PutBlobFromReader", start, err) }(time.Now())
+	return storage.PutBlobFromReader(ctx, s.inner, projectId, documentId, blobId, meta, src, size)
+}
+
+func (s *Storage) GetBlob(ctx context.Context, projectId, documentId, blobId string, dst io.Writer) (blob *storage.BlobIdSizeAndMeta, err error) {
+	defer func(start time.Time) { s.observe("GetBlob", start, err) }(time.Now())
+	return s.inner.GetBlob(ctx, projectId, documentId, blobId, dst)
+}
+
+func (s *Storage) HeadBlob(ctx context.Context, projectId, documentId, blobId string) (blob *storage.BlobIdSizeAndMeta, err error) {
+	defer func(start time.Time) { s.observe("HeadBlob", start, err) }(time.Now())
+	return s.inner.HeadBlob(ctx, projectId, documentId, blobId)
+}
+
+func (s *Storage) DeleteBlobs(ctx context.Context, projectId, documentId string, blobIds []string) (err error) {
+	defer func(start time.Time) { s.observe("DeleteBlobs", start, err) }(time.Now())
+	return s.inner.DeleteBlobs(ctx, projectId, documentId, blobIds)
+}
@@ -0,0 +1,71 @@
+package metered
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/astromechza/memory-mouse/internal/metrics"
+	"github.com/astromechza/memory-mouse/internal/storage"
+	"github.com/astromechza/memory-mouse/internal/storage/sqlite"
+	"github.com/astromechza/memory-mouse/internal/storage/sqlitetest"
+	"github.com/astromechza/memory-mouse/internal/storage/storagetest"
+	"github.com/astromechza/memory-mouse/internal/testsupport"
+)
+
+func TestStorage(t *testing.T) {
+	r := metrics.NewRegistry()
+	s := New(sqlitetest.New(t), "sqlite", r)
+	_, isPresigner := s.(storage.BlobPresigner)
+	testsupport.AssertEqual(t, isPresigner, false)
+
+	// The not found semantics must pass straight through the decorator.
+	storagetest.TestNotFoundSemantics(t, s)
+	storagetest.TestIterators(t, s)
+
+	operations := r.NewCounterVec("memorymouse_storage_operations_total", "", "backend", "method", "result")
+	latency := r.NewHistogramVec("memorymouse_storage_operation_duration_seconds", "", nil, "backend", "method")
+	testsupport.AssertEqual(t, operations.With("sqlite", "PutBlob", "ok").Value() > 0, true)
+	testsupport.AssertEqual(t, operations.With("sqlite", "GetBlob", "not_found").Value() > 0, true)
+	testsupport.AssertEqual(t, latency.With("sqlite", "PutBlob").Count() > 0, true)
+	testsupport.AssertEqual(t, operations.With("sqlite", "IterDocumentIds", "ok").Value() > 0, true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := s.ListProjectIds(ctx)
+	testsupport.AssertEqual(t, err != nil, true)
+	testsupport.AssertEqual(t, operations.With("sqlite", "ListProjectIds", "canceled").Value(), 1.0)
+}
+
+func TestStorage_iterator_latency(t *testing.T) {
+	r := metrics.NewRegistry()
+	inner := sqlitetest.New(t)
+	s := New(inner, "sqlite", r)
+	for _, d := range []string{"d1", "d2", "d3"} {
+		testsupport.MustAssertEqual(t, inner.PutBlob(context.Background(), "p", d, "b", nil, []byte("abc")), nil)
+	}
+	// The time the caller spends on each id is not part of the latency.
+	for range s.IterDocumentIds(context.Background(), "p", storage.ListOptions{}) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	latency := r.NewHistogramVec("memorymouse_storage_operation_duration_seconds", "", nil, "backend", "method").With("sqlite", "IterDocumentIds")
+	testsupport.AssertEqual(t, latency.Count(), uint64(1))
+	testsupport.AssertEqual(t, latency.Sum() < 0.02, true)
+}
+
+type fakePresigner struct {
+	*sqlite.Storage
+}
+
+func (f fakePresigner) PresignGetBlob(projectId, documentId, blobId string, expiry time.Duration) (string, error) {
+	return "https://example.com/" + projectId + "/" + documentId + "/" + blobId, nil
+}
+
+func TestStorage_presigner(t *testing.T) {
+	s := New(fakePresigner{sqlitetest.New(t)}, "fake", metrics.NewRegistry())
+	p, ok := s.(storage.BlobPresigner)
+	testsupport.MustAssertEqual(t, ok, true)
+	u, err := p.PresignGetBlob("p", "d", "b", time.Minute)
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, u, "https://example.com/p/d/b")
+}
@@ -0,0 +1,105 @@
+package s3
+
+import (
+	"encoding/xml"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxErrorBodySize limits how much of an error response body we are willing to read.
+const maxErrorBodySize = 64 * 1024
+
+// Error is the structured form of an S3 error response as described in
+// https://docs.aws.amazon.com/AmazonS3/latest/API/ErrorResponses.html. The request id and host id should be included
+// when raising AWS support cases. Use errors.As to retrieve it from the errors returned by Storage.
+type Error struct {
+	// StatusCode is the http status code of the response. This is 200 for the per-key errors of a DeleteObjects call.
+	StatusCode int
+	// Code is the S3 error code such as AccessDenied or SignatureDoesNotMatch. This is empty for HEAD requests since
+	// they have no response body.
+	Code string
+	// Message is the human-readable message accompanying the error code.
+	Message string
+	// Key is the object key the error relates to, if known.
+	Key string
+	// RequestId is the x-amz-request-id of the failed request.
+	RequestId string
+	// HostId is the x-amz-id-2 of the failed request.
+	HostId string
+}
+
+func (e *Error) Error() string {
+	sb := new(strings.Builder)
+	sb.WriteString("s3 error")
+	if e.StatusCode != http.StatusOK {
+		sb.WriteString(": ")
+		sb.WriteString(strconv.Itoa(e.StatusCode))
+		sb.WriteRune(' ')
+		sb.WriteString(http.StatusText(e.StatusCode))
+	}
+	if e.Code != "" {
+		sb.WriteString(": ")
+		sb.WriteString(e.Code)
+	}
+	if e.Key != "" {
+		sb.WriteString(" for '")
+		sb.WriteString(e.Key)
+		sb.WriteRune('\'')
+	}
+	if e.Message != "" {
+		sb.WriteString(": ")
+		sb.WriteString(e.Message)
+	}
+	if e.RequestId != "" {
+		sb.WriteString(" (request id ")
+		sb.WriteString(e.RequestId)
+		sb.WriteRune(')')
+	}
+	return sb.String()
+}
+
+// LogValue allows the error to be logged as a group of slog attributes.
+func (e *Error) LogValue() slog.Value {
+	attrs := []slog.Attr{slog.Int("status", e.StatusCode)}
+	for _, a := range [][2]string{
+		{"code", e.Code}, {"message", e.Message}, {"key", e.Key}, {"request_id", e.RequestId}, {"host_id", e.HostId},
+	} {
+		if a[1] != "" {
+			attrs = append(attrs, slog.String(a[0], a[1]))
+		}
+	}
+	return slog.GroupValue(attrs...)
+}
+
+var _ slog.LogValuer = (*Error)(nil)
+
+type errorResponse struct {
+	Code      string `xml:"Code"`
+	Message   string `xml:"Message"`
+	Key       string `xml:"Key"`
+	RequestId string `xml:"RequestId"`
+	HostId    string `xml:"HostId"`
+}
+
+// newError builds an Error from a non-successful response. The body is consumed but not closed.
+func newError(resp *http.Response) *Error {
+	out := &Error{
+		StatusCode: resp.StatusCode,
+		RequestId:  resp.Header.Get("x-amz-request-id"),
+		HostId:     resp.Header.Get("x-amz-id-2"),
+	}
+	var body errorResponse
+	if resp.Body != nil && xml.NewDecoder(io.LimitReader(resp.Body, maxErrorBodySize)).Decode(&body) == nil {
+		out.Code, out.Message, out.Key = body.Code, body.Message, body.Key
+		if body.RequestId != "" {
+			out.RequestId = body.RequestId
+		}
+		if body.HostId != "" {
+			out.HostId = body.HostId
+		}
+	}
+	return out
+}
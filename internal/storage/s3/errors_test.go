@@ -0,0 +1,51 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/astromechza/memory-mouse/internal/storage"
+	"github.com/astromechza/memory-mouse/internal/testsupport"
+)
+
+func TestError_as(t *testing.T) {
+	f := newFakeS3(t)
+	s := f.newStorage()
+	f.awsSecretAccessKey = "different"
+
+	_, err := s.ListProjectIds(context.Background())
+	var s3Err *Error
+	testsupport.MustAssertEqual(t, errors.As(err, &s3Err), true)
+	testsupport.AssertEqual(t, s3Err.StatusCode, http.StatusForbidden)
+	testsupport.AssertEqual(t, s3Err.Code, "SignatureDoesNotMatch")
+	testsupport.AssertEqual(t, s3Err.RequestId, "FAKEREQUESTID")
+	testsupport.AssertEqual(t, s3Err.HostId, "FAKEHOSTID")
+}
+
+func TestError_head_not_found(t *testing.T) {
+	f := newFakeS3(t)
+	_, err := f.newStorage().HeadBlob(context.Background(), "p", "d", "b")
+	testsupport.AssertEqual(t, errors.Is(err, storage.ErrBlobNotFound), true)
+	var s3Err *Error
+	testsupport.MustAssertEqual(t, errors.As(err, &s3Err), true)
+	// HEAD responses have no body so only the headers are available
+	testsupport.AssertEqual(t, s3Err.Code, "")
+	testsupport.AssertEqual(t, s3Err.RequestId, "FAKEREQUESTID")
+}
+
+func TestError_log_value(t *testing.T) {
+	buff := new(bytes.Buffer)
+	logger := slog.New(slog.NewTextHandler(buff, &slog.HandlerOptions{ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+		if a.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return a
+	}}))
+	logger.Error("failed", slog.Any("err", &Error{StatusCode: 403, Code: "AccessDenied", Message: "Access Denied", RequestId: "R", HostId: "H"}))
+	testsupport.AssertEqual(t, strings.TrimSpace(buff.String()), `level=ERROR msg=failed err.status=403 err.code=AccessDenied err.message="Access Denied" err.request_id=R err.host_id=H`)
+}
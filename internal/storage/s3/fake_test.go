@@ -0,0 +1,494 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeObject is an object stored in the fakeS3.
+type fakeObject struct {
+	content []byte
+	meta    map[string]string
+}
+
+// fakeError is an S3 error response that can be injected into the fakeS3.
+type fakeError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+// fakeS3 is an in-process implementation of the subset of the S3 api used by Storage. It validates the sigv4
+// signatures of every request, including presigned urls and streaming chunk signatures, and supports error injection
+// so that failure paths can be tested without a real S3 endpoint. The signatures are calculated by fakeSigner rather
+// than the client signing code, so that a mistake in the client is not mirrored by the fake.
+type fakeS3 struct {
+	t                  *testing.T
+	server             *httptest.Server
+	bucket             string
+	region             string
+	awsAccessKeyId     string
+	awsSecretAccessKey string
+	// maxKeys is the page size for list requests. This is usually small in order to exercise the pagination.
+	maxKeys int
+	// now is used to check the expiry of presigned urls.
+	now func() time.Time
+
+	lock    sync.Mutex
+	objects map[string]*fakeObject
+	// requests counts the requests received by a short operation name such as "ListObjectsV2" or "PutObject".
+	requests map[string]int
+	// errorHook, if set, is called for every authenticated request and may return an error to respond with instead.
+	errorHook func(operation string, r *http.Request) *fakeError
+	// deleteErrorHook, if set, is called for each key in a DeleteObjects request and may return an error for that key.
+	deleteErrorHook func(key string) *fakeError
+}
+
+// newFakeS3 starts a new fake S3 server which is stopped when the test completes.
+func newFakeS3(t *testing.T) *fakeS3 {
+	t.Helper()
+	f := &fakeS3{
+		t:                  t,
+		bucket:             "fake-bucket",
+		region:             "us-east-1",
+		awsAccessKeyId:     "AKIAFAKE",
+		awsSecretAccessKey: "fake-secret",
+		maxKeys:            2,
+		now:                time.Now,
+		objects:            make(map[string]*fakeObject),
+		requests:           make(map[string]int),
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// newStorage returns a Storage pointed at the fake server with the correct credentials.
+func (f *fakeS3) newStorage(opts ...Option) *Storage {
+	f.t.Helper()
+	s, err := NewWithEndpoint(
+		f.server.Client(), f.server.URL, f.bucket, f.region, f.awsAccessKeyId, f.awsSecretAccessKey,
+		append([]Option{WithAddressingStyle(PathStyle)}, opts...)...,
+	)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return s
+}
+
+func (f *fakeS3) keys() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	keys := make([]string, 0, len(f.objects))
+	for k := range f.objects {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func (f *fakeS3) requestCount(operation string) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.requests[operation]
+}
+
+func (f *fakeS3) writeError(w http.ResponseWriter, r *http.Request, e *fakeError) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(e.Status)
+	if r.Method != http.MethodHead {
+		_, _ = fmt.Fprintf(
+			w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message><Resource>%s</Resource><RequestId>FAKEREQUESTID</RequestId><HostId>FAKEHOSTID</HostId></Error>`,
+			e.Code, e.Message, r.URL.Path,
+		)
+	}
+}
+
+func (f *fakeS3) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("x-amz-request-id", "FAKEREQUESTID")
+	w.Header().Set("x-amz-id-2", "FAKEHOSTID")
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+f.bucket+"/")
+	if !ok && r.URL.Path != "/"+f.bucket {
+		f.writeError(w, r, &fakeError{http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist"})
+		return
+	}
+	var operation string
+	switch {
+	case key == "" && r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		operation = "ListObjectsV2"
+	case key == "" && r.Method == http.MethodPost && r.URL.Query().Has("delete"):
+		operation = "DeleteObjects"
+	case key == "" && r.Method == http.MethodHead:
+		operation = "HeadBucket"
+	case key != "" && r.Method == http.MethodPut:
+		operation = "PutObject"
+	case key != "" && r.Method == http.MethodGet:
+		operation = "GetObject"
+	case key != "" && r.Method == http.MethodHead:
+		operation = "HeadObject"
+	default:
+		f.writeError(w, r, &fakeError{http.StatusNotImplemented, "NotImplemented", "The fake does not implement this operation"})
+		return
+	}
+
+	body, e := f.authenticate(r)
+	if e != nil {
+		f.writeError(w, r, e)
+		return
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.requests[operation]++
+	if f.errorHook != nil {
+		if e := f.errorHook(operation, r); e != nil {
+			f.writeError(w, r, e)
+			return
+		}
+	}
+
+	switch operation {
+	case "HeadBucket":
+		w.WriteHeader(http.StatusOK)
+	case "ListObjectsV2":
+		f.listObjectsV2(w, r)
+	case "DeleteObjects":
+		f.deleteObjects(w, r, body)
+	case "PutObject":
+		meta := make(map[string]string)
+		for k, v := range r.Header {
+			if mk, ok := strings.CutPrefix(strings.ToLower(k), "x-amz-meta-"); ok {
+				meta[mk] = v[0]
+			}
+		}
+		f.objects[key] = &fakeObject{content: body, meta: meta}
+		w.WriteHeader(http.StatusOK)
+	case "GetObject", "HeadObject":
+		o, ok := f.objects[key]
+		if !ok {
+			f.writeError(w, r, &fakeError{http.StatusNotFound, "NoSuchKey", "The specified key does not exist."})
+			return
+		}
+		for k, v := range o.meta {
+			w.Header().Set("x-amz-meta-"+k, v)
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(o.content)))
+		w.WriteHeader(http.StatusOK)
+		if operation == "GetObject" {
+			_, _ = w.Write(o.content)
+		}
+	}
+}
+
+func (f *fakeS3) listObjectsV2(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+	startAfter := q.Get("start-after")
+	if token := q.Get("continuation-token"); token != "" {
+		raw, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			f.writeError(w, r, &fakeError{http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect"})
+			return
+		}
+		startAfter = string(raw)
+	}
+	maxKeys := f.maxKeys
+	if v := q.Get("max-keys"); v != "" {
+		maxKeys, _ = strconv.Atoi(v)
+	}
+
+	keys := make([]string, 0, len(f.objects))
+	for k := range f.objects {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	out := &listBucketResult{}
+	lastEntry := ""
+	for _, k := range keys {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		entry := k
+		if delimiter != "" {
+			if i := strings.Index(k[len(prefix):], delimiter); i >= 0 {
+				entry = k[:len(prefix)+i+len(delimiter)]
+			}
+		}
+		if entry <= startAfter || entry == lastEntry {
+			continue
+		}
+		if len(out.Contents)+len(out.CommonPrefixes) >= maxKeys {
+			out.IsTruncated = true
+			out.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(lastEntry))
+			break
+		}
+		lastEntry = entry
+		if entry != k {
+			out.CommonPrefixes = append(out.CommonPrefixes, listBucketCommonPrefix{Prefix: entry})
+		} else {
+			out.Contents = append(out.Contents, listBucketObject{Key: k, Size: int64(len(f.objects[k].content))})
+		}
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"ListBucketResult"`
+		*listBucketResult
+	}{listBucketResult: out})
+}
+
+func (f *fakeS3) deleteObjects(w http.ResponseWriter, r *http.Request, body []byte) {
+	var req deleteObjectsBody
+	if err := xml.Unmarshal(body, &req); err != nil {
+		f.writeError(w, r, &fakeError{http.StatusBadRequest, "MalformedXML", err.Error()})
+		return
+	}
+	if len(req.Objects) > maxDeleteObjects {
+		f.writeError(w, r, &fakeError{http.StatusBadRequest, "MalformedXML", "too many objects"})
+		return
+	} else if h := md5.Sum(body); r.Header.Get("Content-MD5") != base64.StdEncoding.EncodeToString(h[:]) {
+		f.writeError(w, r, &fakeError{http.StatusBadRequest, "InvalidDigest", "The Content-MD5 you specified was invalid."})
+		return
+	}
+	out := struct {
+		XMLName xml.Name `xml:"DeleteResult"`
+		deleteObjectsResult
+	}{}
+	for _, o := range req.Objects {
+		if f.deleteErrorHook != nil {
+			if e := f.deleteErrorHook(o.Key); e != nil {
+				out.Errors = append(out.Errors, deleteObjectsError{Key: o.Key, Code: e.Code, Message: e.Message})
+				continue
+			}
+		}
+		delete(f.objects, o.Key)
+		if !req.Quiet {
+			out.Deleted = append(out.Deleted, deleteObjectsObject{Key: o.Key})
+		}
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(out)
+}
+
+// authenticate validates the header or query string signature of the request and returns the decoded body.
+func (f *fakeS3) authenticate(r *http.Request) ([]byte, *fakeError) {
+	accessDenied := func(msg string, args ...any) *fakeError {
+		f.t.Logf("fake s3 rejected %s %s: %s", r.Method, r.URL, fmt.Sprintf(msg, args...))
+		return &fakeError{http.StatusForbidden, "AccessDenied", fmt.Sprintf(msg, args...)}
+	}
+	signatureMismatch := &fakeError{http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided."}
+
+	q := r.URL.Query()
+	presigned := q.Has("X-Amz-Signature")
+	var credential, signedHeaders, signature, amzDate, payloadHash string
+	if presigned {
+		credential, signedHeaders, signature = q.Get("X-Amz-Credential"), q.Get("X-Amz-SignedHeaders"), q.Get("X-Amz-Signature")
+		amzDate, payloadHash = q.Get("X-Amz-Date"), "UNSIGNED-PAYLOAD"
+	} else {
+		auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+		if !ok {
+			return nil, accessDenied("missing or unsupported authorization header")
+		}
+		for _, part := range strings.Split(auth, ",") {
+			k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch k {
+			case "Credential":
+				credential = v
+			case "SignedHeaders":
+				signedHeaders = v
+			case "Signature":
+				signature = v
+			}
+		}
+		amzDate, payloadHash = r.Header.Get("x-amz-date"), r.Header.Get("x-amz-content-sha256")
+	}
+	t, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil {
+		return nil, accessDenied("invalid date: %v", err)
+	}
+	if credential != f.awsAccessKeyId+"/"+t.Format("20060102")+"/"+f.region+"/s3/aws4_request" {
+		return nil, accessDenied("invalid credential %s", credential)
+	}
+	if presigned {
+		expires, _ := strconv.Atoi(q.Get("X-Amz-Expires"))
+		if f.now().After(t.Add(time.Duration(expires) * time.Second)) {
+			return nil, accessDenied("request has expired")
+		}
+	}
+
+	if presigned {
+		q.Del("X-Amz-Signature")
+	}
+	signer := fakeSigner{region: f.region, secretKey: f.awsSecretAccessKey, amzDate: amzDate, t: t}
+	if signer.requestSignature(r, q, strings.Split(signedHeaders, ";"), payloadHash) != signature {
+		return nil, signatureMismatch
+	}
+
+	var body []byte
+	if r.Body != nil {
+		if body, err = io.ReadAll(r.Body); err != nil {
+			return nil, &fakeError{http.StatusBadRequest, "IncompleteBody", err.Error()}
+		}
+	}
+	switch payloadHash {
+	case "UNSIGNED-PAYLOAD":
+	case "STREAMING-AWS4-HMAC-SHA256-PAYLOAD":
+		if body, err = signer.decodeChunks(signature, body); err != nil {
+			f.t.Logf("fake s3 rejected chunked body: %v", err)
+			return nil, signatureMismatch
+		} else if strconv.Itoa(len(body)) != r.Header.Get("x-amz-decoded-content-length") {
+			return nil, &fakeError{http.StatusBadRequest, "IncompleteBody", "decoded length does not match"}
+		}
+	default:
+		h := sha256.Sum256(body)
+		if hex.EncodeToString(h[:]) != payloadHash {
+			return nil, &fakeError{http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed."}
+		}
+	}
+	return body, nil
+}
+
+// decodeChunks decodes an aws-chunked body and validates the chain of chunk signatures.
+func (s fakeSigner) decodeChunks(seedSignature string, body []byte) ([]byte, error) {
+	out := new(bytes.Buffer)
+	br := bufio.NewReader(bytes.NewReader(body))
+	previous := seedSignature
+	for {
+		header, err := br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chunk header: %w", err)
+		}
+		sizeHex, sig, ok := strings.Cut(strings.TrimSuffix(header, "\r\n"), ";chunk-signature=")
+		if !ok {
+			return nil, fmt.Errorf("invalid chunk header %q", header)
+		}
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk size: %w", err)
+		}
+		chunk := make([]byte, size+2)
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			return nil, fmt.Errorf("failed to read chunk: %w", err)
+		}
+		chunk = chunk[:size]
+		if expected := s.chunkSignature(previous, chunk); sig != expected {
+			return nil, fmt.Errorf("invalid chunk signature")
+		}
+		previous = sig
+		out.Write(chunk)
+		if size == 0 {
+			return out.Bytes(), nil
+		}
+	}
+}
+
+// fakeSigner calculates sigv4 signatures for the fakeS3 following
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html. It is written separately from the
+// client signing code and is checked against the examples in the AWS documentation.
+type fakeSigner struct {
+	region    string
+	secretKey string
+	// amzDate is the request time as it appears in the request, and t is the parsed form.
+	amzDate string
+	t       time.Time
+}
+
+// fakeUriEncode percent encodes every byte except the unreserved characters, and optionally '/'.
+func fakeUriEncode(s string, keepSlash bool) string {
+	sb := new(strings.Builder)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || strings.IndexByte("-_.~", c) >= 0 || (keepSlash && c == '/') {
+			sb.WriteByte(c)
+		} else {
+			_, _ = fmt.Fprintf(sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// canonicalRequest builds the canonical request from the request, with the query and signed headers given separately
+// since a presigned url must be checked without its signature.
+func (s fakeSigner) canonicalRequest(r *http.Request, query url.Values, signedHeaders []string, payloadHash string) string {
+	type pair struct{ k, v string }
+	pairs := make([]pair, 0, len(query))
+	for k, vs := range query {
+		for _, v := range vs {
+			pairs = append(pairs, pair{fakeUriEncode(k, false), fakeUriEncode(v, false)})
+		}
+	}
+	slices.SortFunc(pairs, func(a, b pair) int {
+		if c := strings.Compare(a.k, b.k); c != 0 {
+			return c
+		}
+		return strings.Compare(a.v, b.v)
+	})
+	encodedQuery := make([]string, len(pairs))
+	for i, p := range pairs {
+		encodedQuery[i] = p.k + "=" + p.v
+	}
+	path := r.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	lines := []string{r.Method, fakeUriEncode(path, true), strings.Join(encodedQuery, "&")}
+	for _, h := range signedHeaders {
+		v := r.Header.Get(h)
+		switch h {
+		case "host":
+			v = r.Host
+		case "content-length":
+			v = strconv.FormatInt(r.ContentLength, 10)
+		}
+		lines = append(lines, h+":"+strings.TrimSpace(v))
+	}
+	return strings.Join(append(lines, "", strings.Join(signedHeaders, ";"), payloadHash), "\n")
+}
+
+func (s fakeSigner) sign(stringToSign string) string {
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	key := mac([]byte("AWS4"+s.secretKey), s.t.Format("20060102"))
+	key = mac(key, s.region)
+	key = mac(key, "s3")
+	key = mac(key, "aws4_request")
+	return hex.EncodeToString(mac(key, stringToSign))
+}
+
+func (s fakeSigner) scope() string {
+	return s.t.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+}
+
+// requestSignature returns the signature of the request, which is the seed signature for a chunked body.
+func (s fakeSigner) requestSignature(r *http.Request, query url.Values, signedHeaders []string, payloadHash string) string {
+	h := sha256.Sum256([]byte(s.canonicalRequest(r, query, signedHeaders, payloadHash)))
+	return s.sign("AWS4-HMAC-SHA256\n" + s.amzDate + "\n" + s.scope() + "\n" + hex.EncodeToString(h[:]))
+}
+
+// chunkSignature returns the signature of a chunk of an aws-chunked body, chained from the previous signature.
+func (s fakeSigner) chunkSignature(previous string, chunk []byte) string {
+	empty, h := sha256.Sum256(nil), sha256.Sum256(chunk)
+	return s.sign("AWS4-HMAC-SHA256-PAYLOAD\n" + s.amzDate + "\n" + s.scope() + "\n" + previous + "\n" + hex.EncodeToString(empty[:]) + "\n" + hex.EncodeToString(h[:]))
+}
@@ -0,0 +1,235 @@
+package s3
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/astromechza/memory-mouse/internal/storage"
+)
+
+// maxShardChars is the largest supported shard width. Listing projects or documents requires a request per shard, so
+// 2 hex characters (256 shards) is already a lot of requests.
+const maxShardChars = 2
+
+// WithHashedShards changes the object key layout from <prefix>/<project>/<document>/<blob> to
+// <prefix>/<shard>/<project>/<document>/<blob> where the shard is the first n hex characters of the sha256 of the
+// project and document id. This spreads the request rate of busy projects across S3 partitions at the cost of
+// listing operations needing a request per shard. A value of 0 disables sharding, and values above 2 are capped.
+//
+// NOTE: changing this for an existing bucket will make existing documents invisible, they must be migrated instead.
+func WithHashedShards(n int) Option {
+	return func(s *Storage) {
+		s.shardChars = max(0, min(n, maxShardChars))
+	}
+}
+
+// shardRoots returns the set of prefixes under which projects are stored. This is just the key prefix when sharding is
+// disabled.
+func (s *Storage) shardRoots() []string {
+	if s.shardChars == 0 {
+		return []string{s.keyPrefix}
+	}
+	const hexChars = "0123456789abcdef"
+	roots := []string{""}
+	for range s.shardChars {
+		next := make([]string, 0, len(roots)*len(hexChars))
+		for _, r := range roots {
+			for _, c := range hexChars {
+				next = append(next, r+string(c))
+			}
+		}
+		roots = next
+	}
+	for i, r := range roots {
+		roots[i] = s.keyPrefix + r + "/"
+	}
+	return roots
+}
+
+// documentRoot returns the prefix under which the project folder for this document is stored.
+func (s *Storage) documentRoot(projectId, documentId string) string {
+	if s.shardChars == 0 {
+		return s.keyPrefix
+	}
+	h := sha256.Sum256([]byte(projectId + "/" + documentId))
+	return s.keyPrefix + hex.EncodeToString(h[:])[:s.shardChars] + "/"
+}
+
+func (s *Storage) documentPrefix(projectId, documentId string) string {
+	return s.documentRoot(projectId, documentId) + projectId + "/" + documentId + "/"
+}
+
+func (s *Storage) blobKey(projectId, documentId, blobId string) string {
+	return s.documentPrefix(projectId, documentId) + blobId
+}
+
+// listAcrossShards lists the common prefixes under root+suffix for every shard root and passes each result to the
+// callback along with the root it was listed under. The shards are listed concurrently but the callback is called
+// while holding a lock so it does not need to be thread safe.
+func (s *Storage) listAcrossShards(ctx context.Context, suffix string, callback func(root string, r *listBucketResult)) error {
+	roots := s.shardRoots()
+	if len(roots) == 1 {
+		r, err := s.listObjectsV2All(ctx, roots[0]+suffix, "/")
+		if err != nil {
+			return fmt.Errorf("failed to list all objects: %w", err)
+		}
+		callback(roots[0], r)
+		return nil
+	}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	errs := make([]error, len(roots))
+	// Limit the concurrency so that we don't fire off hundreds of requests at once.
+	sem := make(chan struct{}, 16)
+	for i, root := range roots {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			r, err := s.listObjectsV2All(ctx, root+suffix, "/")
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to list all objects under shard '%s': %w", root, err)
+				return
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			callback(root, r)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// shardCursor pages through the ids under one shard root for iterAcrossShards.
+type shardCursor struct {
+	// root is trimmed from the common prefixes to get the ids.
+	root   string
+	prefix string
+	// after is the StartAfter id. Resuming from it can also list ids that sort before it, such as "a" after "a-b".
+	after      string
+	startAfter string
+	token      string
+	done       bool
+	// pending holds the listed ids in order which may still be preceded by an id that hasn't been listed yet.
+	pending []string
+	// ids holds the ids which are ready to be merged, in order.
+	ids []string
+}
+
+// fill fetches pages until there is at least one id or the listing is complete.
+func (c *shardCursor) fill(ctx context.Context, s *Storage, pageSize int) error {
+	for len(c.ids) == 0 && !c.done {
+		r, err := s.listObjectsV2(ctx, c.prefix, "/", c.startAfter, c.token, pageSize)
+		if err != nil {
+			return fmt.Errorf("failed to list objects under '%s': %w", c.prefix, err)
+		}
+		for _, p := range r.CommonPrefixes {
+			if id := strings.TrimSuffix(strings.TrimPrefix(p.Prefix, c.root), "/"); id > c.after {
+				c.add(id)
+			}
+		}
+		c.token, c.done = r.NextContinuationToken, !r.IsTruncated
+		if c.done {
+			c.ids, c.pending = append(c.ids, c.pending...), nil
+		}
+	}
+	return nil
+}
+
+// add inserts the id which was just listed into the pending ids, and moves the ids which can no longer be preceded by
+// a later id to the ready ids.
+func (c *shardCursor) add(id string) {
+	i, _ := slices.BinarySearch(c.pending, id)
+	c.pending = slices.Insert(c.pending, i, id)
+	n := 0
+	for n < len(c.pending) && !mayBePreceded(c.pending[n], id) {
+		n++
+	}
+	c.ids, c.pending = append(c.ids, c.pending[:n]...), c.pending[n:]
+}
+
+// mayBePreceded returns whether an id that sorts before id can still be listed after last. S3 lists the ids in the
+// order of the common prefixes, which is the order of the id followed by '/'. So an id is only listed after all of the
+// ids that extend it with a byte below '/', for example "a" is listed after "a-b" and "a.b".
+func mayBePreceded(id, last string) bool {
+	for i := 1; i < len(id); i++ {
+		if id[i] < '/' && id[:i]+"/" > last+"/" {
+			return true
+		}
+	}
+	return false
+}
+
+// iterAcrossShards yields the ids under root+suffix in lexicographic order, merging the shards as it goes. The merge
+// needs the next id of every shard, so the first page of each shard is fetched up front, concurrently, and later
+// pages are fetched when the merge reaches them. This means that even reading a single id costs a request per shard,
+// which is 256 requests with 2 shard characters.
+func (s *Storage) iterAcrossShards(ctx context.Context, suffix string, opts storage.ListOptions) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		pageSize := opts.PageSize
+		if pageSize <= 0 {
+			pageSize = storage.DefaultPageSize
+		}
+		roots := s.shardRoots()
+		cursors := make([]*shardCursor, len(roots))
+		for i, root := range roots {
+			cursors[i] = &shardCursor{root: root + suffix, prefix: root + suffix + opts.Prefix, after: opts.StartAfter}
+			if opts.StartAfter != "" {
+				// Every key of an id after StartAfter sorts after StartAfter itself.
+				cursors[i].startAfter = root + suffix + opts.StartAfter
+			}
+		}
+		var wg sync.WaitGroup
+		errs := make([]error, len(cursors))
+		// Limit the concurrency so that we don't fire off hundreds of requests at once.
+		sem := make(chan struct{}, 16)
+		for i, c := range cursors {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				errs[i] = c.fill(ctx, s, pageSize)
+			}()
+		}
+		wg.Wait()
+		if err := errors.Join(errs...); err != nil {
+			yield("", err)
+			return
+		}
+		var last string
+		for {
+			var next *shardCursor
+			for _, c := range cursors {
+				if len(c.ids) > 0 && (next == nil || c.ids[0] < next.ids[0]) {
+					next = c
+				}
+			}
+			if next == nil {
+				return
+			}
+			id := next.ids[0]
+			next.ids = next.ids[1:]
+			if err := next.fill(ctx, s, pageSize); err != nil {
+				yield("", err)
+				return
+			}
+			// With the sharded layout, the same project will appear under many shards.
+			if id == last {
+				continue
+			}
+			last = id
+			if !yield(id, nil) {
+				return
+			}
+		}
+	}
+}
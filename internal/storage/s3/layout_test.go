@@ -0,0 +1,36 @@
+package s3
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/astromechza/memory-mouse/internal/testsupport"
+)
+
+func TestShardRoots(t *testing.T) {
+	s, err := New(http.DefaultClient, "http://localhost:9000/bucket/", "us-east-1", "a", "b", WithKeyPrefix("x"))
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, s.shardRoots(), []string{"x/"})
+
+	WithHashedShards(1)(s)
+	roots := s.shardRoots()
+	testsupport.AssertEqual(t, len(roots), 16)
+	testsupport.AssertEqual(t, roots[0], "x/0/")
+	testsupport.AssertEqual(t, roots[15], "x/f/")
+
+	WithHashedShards(5)(s)
+	roots = s.shardRoots()
+	testsupport.AssertEqual(t, len(roots), 256)
+	testsupport.AssertEqual(t, roots[255], "x/ff/")
+}
+
+func TestBlobKey_sharded(t *testing.T) {
+	s, err := New(http.DefaultClient, "http://localhost:9000/bucket/", "us-east-1", "a", "b", WithHashedShards(2))
+	testsupport.MustAssertEqual(t, err, nil)
+	key := s.blobKey("project", "document", "blob")
+	// sha256("project/document") starts with b7
+	testsupport.AssertEqual(t, key, "b7/project/document/blob")
+	testsupport.AssertContains(t, s.shardRoots(), strings.TrimSuffix(key, "project/document/blob"))
+	testsupport.AssertEqual(t, s.documentPrefix("project", "document"), "b7/project/document/")
+}
@@ -3,11 +3,19 @@ package s3
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -25,6 +33,64 @@ type Storage struct {
 	region             string
 	awsAccessKeyId     string
 	awsSecretAccessKey string
+	payloadSigning     PayloadSigning
+	addressingStyle    AddressingStyle
+	keyPrefix          string
+	shardChars         int
+}
+
+// Option is an optional configuration function for the Storage.
+type Option func(s *Storage)
+
+// AddressingStyle determines how the bucket name is included in the request urls.
+type AddressingStyle int
+
+const (
+	// VirtualHostedStyle puts the bucket name in the host name like https://bucket.s3.us-east-1.amazonaws.com/key. This
+	// is the default and is what AWS recommends.
+	VirtualHostedStyle AddressingStyle = iota
+	// PathStyle puts the bucket name as the first element of the path like http://localhost:9000/bucket/key. This is
+	// generally needed for MinIO, localstack, and other endpoints without wildcard dns.
+	PathStyle
+)
+
+func (a AddressingStyle) String() string {
+	switch a {
+	case VirtualHostedStyle:
+		return "virtual-hosted"
+	case PathStyle:
+		return "path"
+	default:
+		return "AddressingStyle(" + strconv.Itoa(int(a)) + ")"
+	}
+}
+
+// WithAddressingStyle sets the addressing style used by NewWithEndpoint. It has no effect when used with New since
+// the bucket url is already given.
+func WithAddressingStyle(style AddressingStyle) Option {
+	return func(s *Storage) {
+		s.addressingStyle = style
+	}
+}
+
+// WithKeyPrefix stores all the projects under the given prefix in the bucket rather than at the root. This allows
+// multiple deployments to share a bucket. Leading and trailing slashes are ignored.
+func WithKeyPrefix(prefix string) Option {
+	return func(s *Storage) {
+		if prefix = strings.Trim(prefix, "/"); prefix != "" {
+			s.keyPrefix = prefix + "/"
+		} else {
+			s.keyPrefix = ""
+		}
+	}
+}
+
+// WithPayloadSigning sets how uploaded blob content is included in the request signature. The default is
+// PayloadSigned.
+func WithPayloadSigning(mode PayloadSigning) Option {
+	return func(s *Storage) {
+		s.payloadSigning = mode
+	}
 }
 
 type listBucketResult struct {
@@ -44,12 +110,18 @@ type listBucketObject struct {
 }
 
 // listObjectsV2 performs https://docs.aws.amazon.com/AmazonS3/latest/API/API_ListObjectsV2.html.
-func (s *Storage) listObjectsV2(ctx context.Context, prefix, delimiter, continuationToken string) (*listBucketResult, error) {
+func (s *Storage) listObjectsV2(ctx context.Context, prefix, delimiter, startAfter, continuationToken string, maxKeys int) (*listBucketResult, error) {
 	q := make(url.Values)
 	q.Set("list-type", "2")
+	if maxKeys > 0 {
+		q.Set("max-keys", strconv.Itoa(maxKeys))
+	}
 	if prefix != "" {
 		q.Set("prefix", prefix)
 	}
+	if startAfter != "" {
+		q.Set("start-after", startAfter)
+	}
 	if delimiter != "" {
 		q.Set("delimiter", delimiter)
 	}
@@ -71,7 +143,7 @@ func (s *Storage) listObjectsV2(ctx context.Context, prefix, delimiter, continua
 	} else {
 		defer resp.Body.Close()
 		if resp.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("failed to list objects: %s", resp.Status)
+			return nil, fmt.Errorf("failed to list objects: %w", newError(resp))
 		}
 		var out listBucketResult
 		if err := xml.NewDecoder(resp.Body).Decode(&out); err != nil {
@@ -86,7 +158,7 @@ func (s *Storage) listObjectsV2All(ctx context.Context, prefix, delimiter string
 	out := &listBucketResult{}
 	continuationToken := ""
 	for {
-		r, err := s.listObjectsV2(ctx, prefix, delimiter, continuationToken)
+		r, err := s.listObjectsV2(ctx, prefix, delimiter, "", continuationToken, 0)
 		if err != nil {
 			return nil, fmt.Errorf("failed to list objects: %w", err)
 		}
@@ -102,36 +174,57 @@ func (s *Storage) listObjectsV2All(ctx context.Context, prefix, delimiter string
 }
 
 func (s *Storage) ListProjectIds(ctx context.Context) (projectIds []string, err error) {
-	r, err := s.listObjectsV2All(ctx, "", "/")
-	if err != nil {
-		return nil, fmt.Errorf("failed to list all objects: %w", err)
+	projectIds = make([]string, 0)
+	seen := make(map[string]bool)
+	if err := s.listAcrossShards(ctx, "", func(root string, r *listBucketResult) {
+		for _, prefix := range r.CommonPrefixes {
+			// With the sharded layout, the same project will appear under many shards.
+			if id := strings.TrimSuffix(strings.TrimPrefix(prefix.Prefix, root), "/"); !seen[id] {
+				seen[id] = true
+				projectIds = append(projectIds, id)
+			}
+		}
+	}); err != nil {
+		return nil, err
 	}
-	projectIds = make([]string, 0, len(r.CommonPrefixes))
-	for _, prefix := range r.CommonPrefixes {
-		projectIds = append(projectIds, strings.TrimSuffix(prefix.Prefix, "/"))
+	if s.shardChars > 0 {
+		slices.Sort(projectIds)
 	}
 	return projectIds, nil
 }
 
 func (s *Storage) ListDocumentIds(ctx context.Context, projectId string) (documentIds []string, err error) {
-	prefix := projectId + "/"
-	r, err := s.listObjectsV2All(ctx, prefix, "/")
-	if err != nil {
-		return nil, fmt.Errorf("failed to list all objects: %w", err)
+	documentIds = make([]string, 0)
+	if err := s.listAcrossShards(ctx, projectId+"/", func(root string, r *listBucketResult) {
+		for _, p := range r.CommonPrefixes {
+			documentIds = append(documentIds, strings.TrimSuffix(strings.TrimPrefix(p.Prefix, root+projectId+"/"), "/"))
+		}
+	}); err != nil {
+		return nil, err
 	}
-	documentIds = make([]string, 0, len(r.CommonPrefixes))
-	for _, p := range r.CommonPrefixes {
-		documentIds = append(documentIds, strings.TrimSuffix(strings.TrimPrefix(p.Prefix, prefix), "/"))
+	if s.shardChars > 0 {
+		slices.Sort(documentIds)
 	}
 	return documentIds, nil
 }
 
+func (s *Storage) IterProjectIds(ctx context.Context, opts storage.ListOptions) iter.Seq2[string, error] {
+	return s.iterAcrossShards(ctx, "", opts)
+}
+
+func (s *Storage) IterDocumentIds(ctx context.Context, projectId string, opts storage.ListOptions) iter.Seq2[string, error] {
+	return s.iterAcrossShards(ctx, projectId+"/", opts)
+}
+
 func (s *Storage) ListBlobs(ctx context.Context, projectId, documentId string) (blobs []storage.BlobIdAndSize, err error) {
-	prefix := fmt.Sprintf("%s/%s/", projectId, documentId)
+	prefix := s.documentPrefix(projectId, documentId)
 	r, err := s.listObjectsV2All(ctx, prefix, "")
 	if err != nil {
 		return nil, fmt.Errorf("failed to list all objects: %w", err)
 	}
+	if len(r.Contents) == 0 {
+		return nil, storage.ErrDocumentNotFound
+	}
 	blobs = make([]storage.BlobIdAndSize, 0, len(r.Contents))
 	for _, content := range r.Contents {
 		blobs = append(blobs, storage.BlobIdAndSize{
@@ -143,15 +236,46 @@ func (s *Storage) ListBlobs(ctx context.Context, projectId, documentId string) (
 }
 
 func (s *Storage) PutBlob(ctx context.Context, projectId, documentId, blobId string, meta map[string]string, blob []byte) error {
-	key := fmt.Sprintf("%s/%s/%s", projectId, documentId, blobId)
-	r, err := http.NewRequestWithContext(ctx, http.MethodPut, s.bucketUrl.ResolveReference(&url.URL{Path: key}).String(), bytes.NewReader(blob))
+	contentSha256 := ""
+	if s.payloadSigning == PayloadSigned {
+		// We already have the content in memory, so hash it here rather than having the signer copy the body.
+		h := sha256.Sum256(blob)
+		contentSha256 = hex.EncodeToString(h[:])
+	}
+	return s.putObject(ctx, s.blobKey(projectId, documentId, blobId), meta, bytes.NewReader(blob), int64(len(blob)), contentSha256)
+}
+
+// PutBlobFromReader is the same as PutBlob but streams the content from the reader which must produce exactly size
+// bytes. With the PayloadSigned mode the content must still be buffered in order to sign it, so the PayloadStreaming or
+// PayloadUnsigned modes should be used to avoid holding the whole blob in memory.
+func (s *Storage) PutBlobFromReader(ctx context.Context, projectId, documentId, blobId string, meta map[string]string, src io.Reader, size int64) error {
+	return s.putObject(ctx, s.blobKey(projectId, documentId, blobId), meta, src, size, "")
+}
+
+// putObject performs https://docs.aws.amazon.com/AmazonS3/latest/API/API_PutObject.html using the configured payload
+// signing mode. If the content sha256 is already known, it can be passed in to avoid reading the body twice.
+func (s *Storage) putObject(ctx context.Context, key string, meta map[string]string, body io.Reader, size int64, contentSha256 string) error {
+	r, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectUrl(key), body)
 	if err != nil {
 		return fmt.Errorf("failed to build request: %w", err)
 	}
+	r.ContentLength = size
 	for s2, s3 := range meta {
 		r.Header.Set("x-amz-meta-"+s2, s3)
 	}
-	if err := signSigV4(r, s.clock, s.region, s.awsAccessKeyId, s.awsSecretAccessKey); err != nil {
+	switch s.payloadSigning {
+	case PayloadStreaming:
+		err = signSigV4Streaming(r, s.clock, s.region, s.awsAccessKeyId, s.awsSecretAccessKey, defaultChunkSize)
+	case PayloadUnsigned:
+		r.Header.Set("x-amz-content-sha256", unsignedPayload)
+		err = signSigV4(r, s.clock, s.region, s.awsAccessKeyId, s.awsSecretAccessKey)
+	default:
+		if contentSha256 != "" {
+			r.Header.Set("x-amz-content-sha256", contentSha256)
+		}
+		err = signSigV4(r, s.clock, s.region, s.awsAccessKeyId, s.awsSecretAccessKey)
+	}
+	if err != nil {
 		return fmt.Errorf("failed to sign request: %w", err)
 	}
 	resp, err := s.client.Do(r)
@@ -160,15 +284,14 @@ func (s *Storage) PutBlob(ctx context.Context, projectId, documentId, blobId str
 	} else {
 		defer resp.Body.Close()
 		if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("failed to make request due to status code: %s", resp.Status)
+			return fmt.Errorf("failed to put object: %w", newError(resp))
 		}
 	}
 	return nil
 }
 
 func (s *Storage) readBlob(ctx context.Context, projectId, documentId, blobId, method string, dst io.Writer) (blob *storage.BlobIdSizeAndMeta, err error) {
-	key := fmt.Sprintf("%s/%s/%s", projectId, documentId, blobId)
-	r, err := http.NewRequestWithContext(ctx, method, s.bucketUrl.ResolveReference(&url.URL{Path: key}).String(), nil)
+	r, err := http.NewRequestWithContext(ctx, method, s.objectUrl(s.blobKey(projectId, documentId, blobId)), nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build request: %w", err)
 	}
@@ -180,8 +303,14 @@ func (s *Storage) readBlob(ctx context.Context, projectId, documentId, blobId, m
 		return nil, fmt.Errorf("failed to make request: %w", err)
 	} else {
 		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("failed to make request due to status code: %s", resp.Status)
+		if resp.StatusCode == http.StatusNotFound {
+			if e := newError(resp); e.Code == "" || e.Code == "NoSuchKey" {
+				return nil, fmt.Errorf("%w: %w", storage.ErrBlobNotFound, e)
+			} else {
+				return nil, fmt.Errorf("failed to read object: %w", e)
+			}
+		} else if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to read object: %w", newError(resp))
 		}
 		if dst != nil {
 			if _, err := io.Copy(dst, resp.Body); err != nil {
@@ -213,8 +342,32 @@ func (s *Storage) HeadBlob(ctx context.Context, projectId, documentId, blobId st
 	return s.readBlob(ctx, projectId, documentId, blobId, http.MethodHead, io.Discard)
 }
 
+// Ping sends a HeadBucket request to check that the bucket exists and the credentials can access it.
+func (s *Storage) Ping(ctx context.Context) error {
+	r, err := http.NewRequestWithContext(ctx, http.MethodHead, s.bucketUrl.String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	if err := signSigV4(r, s.clock, s.region, s.awsAccessKeyId, s.awsSecretAccessKey); err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+	resp, err := s.client.Do(r)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to head bucket: %w", newError(resp))
+	}
+	return nil
+}
+
+// maxDeleteObjects is the maximum number of keys that can be deleted in a single DeleteObjects request.
+const maxDeleteObjects = 1000
+
 type deleteObjectsBody struct {
 	XMLName xml.Name              `xml:"Delete"`
+	Quiet   bool                  `xml:"Quiet"`
 	Objects []deleteObjectsObject `xml:"Object"`
 }
 
@@ -222,46 +375,192 @@ type deleteObjectsObject struct {
 	Key string `xml:"Key"`
 }
 
+type deleteObjectsResult struct {
+	Deleted []deleteObjectsObject `xml:"Deleted"`
+	Errors  []deleteObjectsError  `xml:"Error"`
+}
+
+type deleteObjectsError struct {
+	Key     string `xml:"Key"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
 func (s *Storage) DeleteBlobs(ctx context.Context, projectId, documentId string, blobIds []string) error {
-	body := &deleteObjectsBody{Objects: make([]deleteObjectsObject, 0, len(blobIds))}
+	if len(blobIds) == 0 {
+		return nil
+	}
+	// S3 reports missing keys as deleted, so we need to check whether the document exists up front.
+	if r, err := s.listObjectsV2(ctx, s.documentPrefix(projectId, documentId), "", "", "", 1); err != nil {
+		return fmt.Errorf("failed to check document existence: %w", err)
+	} else if len(r.Contents) == 0 {
+		return storage.ErrDocumentNotFound
+	}
+	keys := make([]string, 0, len(blobIds))
 	for _, id := range blobIds {
-		body.Objects = append(body.Objects, deleteObjectsObject{Key: fmt.Sprintf("%s/%s/%s", projectId, documentId, id)})
+		keys = append(keys, s.blobKey(projectId, documentId, id))
+	}
+	errs := make([]error, 0)
+	for batch := range slices.Chunk(keys, maxDeleteObjects) {
+		if err := s.deleteObjects(ctx, batch); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// deleteObjects performs https://docs.aws.amazon.com/AmazonS3/latest/API/API_DeleteObjects.html in quiet mode. S3
+// returns a 200 even if some or all of the keys could not be deleted, so the failed keys are returned as a joined error.
+func (s *Storage) deleteObjects(ctx context.Context, keys []string) error {
+	body := &deleteObjectsBody{Quiet: true, Objects: make([]deleteObjectsObject, 0, len(keys))}
+	for _, key := range keys {
+		body.Objects = append(body.Objects, deleteObjectsObject{Key: key})
 	}
 	rawBod, _ := xml.Marshal(body)
 	r, err := http.NewRequestWithContext(ctx, http.MethodPost, s.bucketUrl.ResolveReference(&url.URL{RawQuery: "delete"}).String(), bytes.NewReader(rawBod))
 	if err != nil {
 		return fmt.Errorf("failed to build request: %w", err)
 	}
+	// S3 requires an integrity check on the multi-object delete body.
+	bodMd5 := md5.Sum(rawBod)
+	r.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(bodMd5[:]))
 	if err := signSigV4(r, s.clock, s.region, s.awsAccessKeyId, s.awsSecretAccessKey); err != nil {
 		return fmt.Errorf("failed to sign request: %w", err)
 	}
 	resp, err := s.client.Do(r)
 	if err != nil {
 		return fmt.Errorf("failed to make request: %w", err)
-	} else {
-		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("failed to make request due to status code: %s", resp.Status)
-		}
 	}
-	return nil
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to delete objects: %w", newError(resp))
+	}
+	var out deleteObjectsResult
+	if err := xml.NewDecoder(resp.Body).Decode(&out); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to decode delete objects response: %w", err)
+	}
+	errs := make([]error, 0, len(out.Errors))
+	for _, e := range out.Errors {
+		errs = append(errs, &Error{
+			StatusCode: resp.StatusCode, Code: e.Code, Message: e.Message, Key: e.Key,
+			RequestId: resp.Header.Get("x-amz-request-id"), HostId: resp.Header.Get("x-amz-id-2"),
+		})
+	}
+	return errors.Join(errs...)
+}
+
+// Presign returns a url for the given method and raw object key within the bucket that can be used by another client
+// without credentials until the expiry has elapsed. This is useful for offloading large downloads or uploads directly
+// to the client.
+func (s *Storage) Presign(method, key string, expiry time.Duration) (string, error) {
+	r, err := http.NewRequest(method, s.objectUrl(key), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to build request: %w", err)
+	}
+	if err := presignSigV4(r, s.clock, s.region, s.awsAccessKeyId, s.awsSecretAccessKey, expiry); err != nil {
+		return "", fmt.Errorf("failed to presign request: %w", err)
+	}
+	return r.URL.String(), nil
+}
+
+// PresignGetBlob returns a url which can be used to GET the blob contents without credentials until the expiry has
+// elapsed. Presigning is a local calculation, so this doesn't check that the blob exists.
+func (s *Storage) PresignGetBlob(projectId, documentId, blobId string, expiry time.Duration) (string, error) {
+	return s.Presign(http.MethodGet, s.blobKey(projectId, documentId, blobId), expiry)
 }
 
-func New(client HttpDoer, bucketUrl string, region, awsAccessKeyId, awsSecretAccessKey string) (*Storage, error) {
+func (s *Storage) objectUrl(key string) string {
+	return s.bucketUrl.ResolveReference(&url.URL{Path: key}).String()
+}
+
+func New(client HttpDoer, bucketUrl string, region, awsAccessKeyId, awsSecretAccessKey string, opts ...Option) (*Storage, error) {
 	u, err := url.Parse(bucketUrl)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse S3 URL: %w", err)
 	} else if u.Path != "" && !strings.HasSuffix(u.Path, "/") {
 		return nil, fmt.Errorf("bucket url path must end with /")
 	}
-	return &Storage{
+	s := &Storage{
 		client:             client,
 		clock:              time.Now,
 		bucketUrl:          u,
 		region:             region,
 		awsAccessKeyId:     awsAccessKeyId,
 		awsSecretAccessKey: awsSecretAccessKey,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, nil
+}
+
+// NewWithEndpoint builds the bucket url from the endpoint, bucket name, and addressing style rather than requiring the
+// caller to know the exact shape of the bucket url. If the endpoint is empty, the regional AWS endpoint is used. For
+// example "https://s3.us-east-1.amazonaws.com" with VirtualHostedStyle, "http://localhost:9000" with PathStyle for
+// MinIO, or "https://<account>.r2.cloudflarestorage.com" with region "auto" for R2.
+func NewWithEndpoint(client HttpDoer, endpoint, bucket, region, awsAccessKeyId, awsSecretAccessKey string, opts ...Option) (*Storage, error) {
+	style := new(Storage)
+	for _, opt := range opts {
+		opt(style)
+	}
+	u, err := buildBucketUrl(endpoint, bucket, region, style.addressingStyle)
+	if err != nil {
+		return nil, err
+	}
+	return New(client, u.String(), region, awsAccessKeyId, awsSecretAccessKey, opts...)
+}
+
+// buildBucketUrl converts the endpoint and bucket into the bucket url with a trailing slash.
+func buildBucketUrl(endpoint, bucket, region string, style AddressingStyle) (*url.URL, error) {
+	if !validBucketName(bucket) {
+		return nil, fmt.Errorf("invalid bucket name '%s'", bucket)
+	}
+	if endpoint == "" {
+		if region == "" {
+			return nil, fmt.Errorf("a region is required when no endpoint is given")
+		}
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse S3 endpoint: %w", err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("S3 endpoint must have an http or https scheme")
+	} else if u.Host == "" {
+		return nil, fmt.Errorf("S3 endpoint must have a host")
+	} else if u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("S3 endpoint must not have a query or fragment")
+	}
+	out := &url.URL{Scheme: u.Scheme, Host: u.Host, Path: strings.TrimSuffix(u.Path, "/") + "/"}
+	switch style {
+	case VirtualHostedStyle:
+		out.Host = bucket + "." + u.Host
+	case PathStyle:
+		out.Path += bucket + "/"
+	default:
+		return nil, fmt.Errorf("unknown addressing style %s", style)
+	}
+	return out, nil
+}
+
+// validBucketName is a loose version of https://docs.aws.amazon.com/AmazonS3/latest/userguide/bucketnamingrules.html
+// which is enough to ensure the name is safe to use in a host name or path.
+func validBucketName(bucket string) bool {
+	if len(bucket) < 3 || len(bucket) > 63 {
+		return false
+	}
+	for i, c := range []byte(bucket) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+			continue
+		} else if (c == '-' || c == '.') && i > 0 && i < len(bucket)-1 {
+			continue
+		}
+		return false
+	}
+	return true
 }
 
 var _ storage.BlobStorage = (*Storage)(nil)
+var _ storage.BlobPresigner = (*Storage)(nil)
+var _ storage.Pinger = (*Storage)(nil)
+var _ storage.BlobStreamer = (*Storage)(nil)
@@ -9,10 +9,42 @@ import (
 	"io"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// PayloadSigning controls how the request body is included in the sigv4 signature.
+type PayloadSigning int
+
+const (
+	// PayloadSigned hashes the entire body before the request is sent. This is the default and works everywhere, but
+	// the body must be read twice.
+	PayloadSigned PayloadSigning = iota
+	// PayloadUnsigned excludes the body from the signature entirely. This should only be used with TLS endpoints since
+	// the body could otherwise be modified in transit.
+	PayloadUnsigned
+	// PayloadStreaming signs the body in chunks as it is sent using the aws-chunked content encoding. The body is only
+	// read once, but the content length must be known up front.
+	PayloadStreaming
+)
+
+func (p PayloadSigning) String() string {
+	switch p {
+	case PayloadSigned:
+		return "signed"
+	case PayloadUnsigned:
+		return "unsigned"
+	case PayloadStreaming:
+		return "streaming"
+	default:
+		return "PayloadSigning(" + strconv.Itoa(int(p)) + ")"
+	}
+}
+
+// unsignedPayload is the payload hash used when the body is not included in the signature.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
 // signSigV4 appends a AWS sigv4 signature to the request according to the reference at
 // https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html.
 func signSigV4(r *http.Request, clock func() time.Time, region, keyId, secretKey string) error {
@@ -31,14 +63,42 @@ func signSigV4(r *http.Request, clock func() time.Time, region, keyId, secretKey
 	return nil
 }
 
+// maxPresignExpiry is the longest expiry that S3 will accept for a sigv4 presigned url.
+const maxPresignExpiry = 7 * 24 * time.Hour
+
+// presignSigV4 adds the AWS sigv4 query string parameters to the request url so that the url can be used by another
+// client without any credentials or additional headers until it expires. This follows the reference at
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-query-string-auth.html. The payload is always unsigned.
+func presignSigV4(r *http.Request, clock func() time.Time, region, keyId, secretKey string, expiry time.Duration) error {
+	if expiry < time.Second || expiry > maxPresignExpiry {
+		return fmt.Errorf("expiry must be between 1s and %s", maxPresignExpiry)
+	}
+	t := clock()
+	r.Header.Set("Host", r.Host)
+	q := r.URL.Query()
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", keyId+"/"+t.UTC().Format("20060102")+"/"+region+"/s3/aws4_request")
+	q.Set("X-Amz-Date", t.UTC().Format("20060102T150405Z"))
+	q.Set("X-Amz-Expires", strconv.FormatInt(int64(expiry/time.Second), 10))
+	q.Set("X-Amz-SignedHeaders", strings.Join(canonicalHeaderKeys(r.Header), ";"))
+	q.Del("X-Amz-Signature")
+	r.URL.RawQuery = q.Encode()
+	cr := writeCanonicalRequest(r, unsignedPayload)
+	q.Set("X-Amz-Signature", buildSignature(t, region, secretKey, buildStringToSign(t, region, cr)))
+	r.URL.RawQuery = q.Encode()
+	return nil
+}
+
+const upperHex = "0123456789ABCDEF"
+
 // uriEncode is an implementation of the uri encoding function as specified in https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html.
 func uriEncode(in string, out io.Writer) {
 	for _, bb := range []byte(in) {
 		if (bb >= 'A' && bb <= 'Z') || (bb >= 'a' && bb <= 'z') || (bb >= '0' && bb <= '9') || bb == '-' || bb == '_' || bb == '.' || bb == '~' {
 			_, _ = out.Write([]byte{bb})
 		} else {
-			_, _ = out.Write([]byte{'%'})
-			_, _ = hex.NewEncoder(out).Write([]byte{bb})
+			// NOTE: the hex digits must be upper case, the hex encoder only produces lower case.
+			_, _ = out.Write([]byte{'%', upperHex[bb>>4], upperHex[bb&0x0f]})
 		}
 	}
 }
@@ -46,7 +106,12 @@ func uriEncode(in string, out io.Writer) {
 func buildCanonicalRequest(r *http.Request, t time.Time) (string, error) {
 	r.Header.Set("x-amz-date", t.UTC().Format("20060102T150405Z"))
 	r.Header.Set("Host", r.Host)
-	contentSha256 := r.Header.Get("x-amz-checksum-sha256")
+	// The payload hash may already have been chosen by the caller, for example to use an unsigned or streaming payload,
+	// or to avoid reading the body a second time when the hash is already known.
+	contentSha256 := r.Header.Get("x-amz-content-sha256")
+	if contentSha256 == "" {
+		contentSha256 = r.Header.Get("x-amz-checksum-sha256")
+	}
 	if contentSha256 == "" {
 		h := sha256.New()
 		if r.Body != nil {
@@ -60,7 +125,25 @@ func buildCanonicalRequest(r *http.Request, t time.Time) (string, error) {
 		contentSha256 = fmt.Sprintf("%x", h.Sum(nil))
 	}
 	r.Header.Set("x-amz-content-sha256", contentSha256)
+	return writeCanonicalRequest(r, contentSha256), nil
+}
 
+// canonicalHeaderKeys returns the sorted lower case header names which participate in the signature.
+func canonicalHeaderKeys(h http.Header) []string {
+	headerKeys := make([]string, 0, len(h))
+	for k := range h {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-amz-") || k == "content-md5" || k == "content-encoding" || k == "content-length" || k == "date" || k == "host" || k == "range" {
+			headerKeys = append(headerKeys, k)
+		}
+	}
+	sort.Strings(headerKeys)
+	return headerKeys
+}
+
+// writeCanonicalRequest builds the canonical request from the method, url, and headers of the request. Unlike
+// buildCanonicalRequest, this does not modify the request.
+func writeCanonicalRequest(r *http.Request, payloadHash string) string {
 	sb := new(strings.Builder)
 	sb.WriteString(r.Method)
 	sb.WriteRune('\n')
@@ -88,14 +171,7 @@ func buildCanonicalRequest(r *http.Request, t time.Time) (string, error) {
 		uriEncode(qv.Get(k), sb)
 	}
 	sb.WriteRune('\n')
-	headerKeys := make([]string, 0, len(r.Header))
-	for k := range r.Header {
-		k = strings.ToLower(k)
-		if strings.HasPrefix(k, "x-amz-") || k == "content-md5" || k == "date" || k == "host" || k == "range" {
-			headerKeys = append(headerKeys, k)
-		}
-	}
-	sort.Strings(headerKeys)
+	headerKeys := canonicalHeaderKeys(r.Header)
 	for _, k := range headerKeys {
 		sb.WriteString(k)
 		sb.WriteRune(':')
@@ -110,8 +186,8 @@ func buildCanonicalRequest(r *http.Request, t time.Time) (string, error) {
 		sb.WriteString(key)
 	}
 	sb.WriteRune('\n')
-	sb.WriteString(contentSha256)
-	return sb.String(), nil
+	sb.WriteString(payloadHash)
+	return sb.String()
 }
 
 func hmacSha(key []byte, data []byte) []byte {
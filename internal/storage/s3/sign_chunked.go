@@ -0,0 +1,137 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// streamingPayload is the payload hash used when the body is signed chunk by chunk.
+	streamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	// defaultChunkSize is the size of each signed chunk. S3 requires at least 8KB per chunk except for the last.
+	defaultChunkSize = 64 * 1024
+	// emptySha256 is the hex sha256 of an empty string which appears in every chunk string to sign.
+	emptySha256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	// chunkSignatureLength is the length of the hex encoded sha256 hmac of each chunk.
+	chunkSignatureLength = 64
+)
+
+// signSigV4Streaming signs the request headers using the STREAMING-AWS4-HMAC-SHA256-PAYLOAD payload hash and replaces
+// the body with one that produces signed chunks as it is read according to the reference at
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-streaming.html. The request must have a known content length
+// since S3 requires the total encoded length up front.
+func signSigV4Streaming(r *http.Request, clock func() time.Time, region, keyId, secretKey string, chunkSize int) error {
+	if r.Body == nil || r.ContentLength < 0 {
+		return errors.New("streaming signature requires a body with a known content length")
+	} else if chunkSize <= 0 {
+		return errors.New("chunk size must be positive")
+	}
+	decodedLength := r.ContentLength
+	r.ContentLength = chunkedContentLength(decodedLength, chunkSize)
+	r.Header.Set("Content-Encoding", "aws-chunked")
+	r.Header.Set("Content-Length", strconv.FormatInt(r.ContentLength, 10))
+	r.Header.Set("x-amz-content-sha256", streamingPayload)
+	r.Header.Set("x-amz-decoded-content-length", strconv.FormatInt(decodedLength, 10))
+
+	t := clock()
+	cr, err := buildCanonicalRequest(r, t)
+	if err != nil {
+		return err
+	}
+	crParts := strings.Split(cr, "\n")
+	seedSignature := buildSignature(t, region, secretKey, buildStringToSign(t, region, cr))
+	r.Header.Set("Authorization", buildAuthHeader(t, region, keyId, crParts[len(crParts)-2], seedSignature))
+	r.Body = &chunkSigningReader{
+		src: r.Body, buffer: make([]byte, chunkSize),
+		t: t, region: region, secretKey: secretKey, previousSignature: seedSignature,
+	}
+	// The body can only be produced once since the chunk signatures are chained.
+	r.GetBody = nil
+	return nil
+}
+
+// chunkedContentLength calculates the total encoded length of a body of the given size once it is split into chunks
+// and each chunk has a header and trailing crlf. This includes the final empty chunk.
+func chunkedContentLength(decodedLength int64, chunkSize int) int64 {
+	chunkOverhead := func(size int64) int64 {
+		return int64(len(strconv.FormatInt(size, 16))+len(";chunk-signature=")+chunkSignatureLength+2) + size + 2
+	}
+	fullChunks := decodedLength / int64(chunkSize)
+	total := fullChunks * chunkOverhead(int64(chunkSize))
+	if rem := decodedLength % int64(chunkSize); rem > 0 {
+		total += chunkOverhead(rem)
+	}
+	return total + chunkOverhead(0)
+}
+
+func buildChunkStringToSign(t time.Time, region, previousSignature string, chunk []byte) string {
+	h := sha256.Sum256(chunk)
+	stringToSign := new(strings.Builder)
+	stringToSign.WriteString("AWS4-HMAC-SHA256-PAYLOAD\n")
+	stringToSign.WriteString(t.UTC().Format("20060102T150405Z"))
+	stringToSign.WriteRune('\n')
+	stringToSign.WriteString(t.UTC().Format("20060102"))
+	stringToSign.WriteRune('/')
+	stringToSign.WriteString(region)
+	stringToSign.WriteString("/s3/aws4_request\n")
+	stringToSign.WriteString(previousSignature)
+	stringToSign.WriteRune('\n')
+	stringToSign.WriteString(emptySha256)
+	stringToSign.WriteRune('\n')
+	stringToSign.WriteString(hex.EncodeToString(h[:]))
+	return stringToSign.String()
+}
+
+// chunkSigningReader reads the source body one chunk at a time and emits each chunk in the aws-chunked format with a
+// signature chained from the previous one. Only a single chunk is held in memory at a time.
+type chunkSigningReader struct {
+	src               io.ReadCloser
+	buffer            []byte
+	t                 time.Time
+	region            string
+	secretKey         string
+	previousSignature string
+
+	pending bytes.Buffer
+	done    bool
+}
+
+func (c *chunkSigningReader) Read(p []byte) (int, error) {
+	for c.pending.Len() == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(c.src, c.buffer)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, fmt.Errorf("failed to read chunk from body: %w", err)
+		}
+		c.writeChunk(c.buffer[:n])
+		if n == 0 {
+			c.done = true
+		}
+	}
+	return c.pending.Read(p)
+}
+
+func (c *chunkSigningReader) writeChunk(chunk []byte) {
+	signature := buildSignature(c.t, c.region, c.secretKey, buildChunkStringToSign(c.t, c.region, c.previousSignature, chunk))
+	c.previousSignature = signature
+	c.pending.WriteString(strconv.FormatInt(int64(len(chunk)), 16))
+	c.pending.WriteString(";chunk-signature=")
+	c.pending.WriteString(signature)
+	c.pending.WriteString("\r\n")
+	c.pending.Write(chunk)
+	c.pending.WriteString("\r\n")
+}
+
+func (c *chunkSigningReader) Close() error {
+	return c.src.Close()
+}
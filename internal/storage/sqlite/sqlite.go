@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"log/slog"
 	"strings"
 
@@ -20,6 +21,8 @@ type Storage struct {
 	reader *sql.DB
 }
 
+var _ storage.Pinger = (*Storage)(nil)
+
 func newConn(connString string, maxConnections int) (*sql.DB, error) {
 	slog.Debug("opening connection", slog.String("conn", connString))
 	db, err := sql.Open("sqlite3", connString)
@@ -81,9 +84,19 @@ func (s *Storage) Close() error {
 	return errors.Join(s.writer.Close(), s.reader.Close())
 }
 
+// Ping checks that both the writer and reader connections are usable.
+func (s *Storage) Ping(ctx context.Context) error {
+	if err := s.writer.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to ping writer: %w", err)
+	} else if err := s.reader.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to ping reader: %w", err)
+	}
+	return nil
+}
+
 func (s *Storage) ListProjectIds(ctx context.Context) (projectIds []string, err error) {
 	slog.Debug("executing list project ids query")
-	if r, err := s.reader.QueryContext(ctx, `SELECT DISTINCT project_id FROM blobs`); err != nil {
+	if r, err := s.reader.QueryContext(ctx, `SELECT DISTINCT project_id FROM blobs ORDER BY project_id`); err != nil {
 		return nil, fmt.Errorf("failed to perform list project ids query: %w", err)
 	} else {
 		defer func() {
@@ -108,7 +121,7 @@ func (s *Storage) ListProjectIds(ctx context.Context) (projectIds []string, err
 
 func (s *Storage) ListDocumentIds(ctx context.Context, projectId string) (documentIds []string, err error) {
 	slog.Debug("executing list document ids query", slog.String("project", projectId))
-	if r, err := s.reader.QueryContext(ctx, `SELECT DISTINCT document_id FROM blobs WHERE project_id = $1`, projectId); err != nil {
+	if r, err := s.reader.QueryContext(ctx, `SELECT DISTINCT document_id FROM blobs WHERE project_id = $1 ORDER BY document_id`, projectId); err != nil {
 		return nil, fmt.Errorf("failed to perform list document ids query: %w", err)
 	} else {
 		defer func() {
@@ -131,6 +144,92 @@ func (s *Storage) ListDocumentIds(ctx context.Context, projectId string) (docume
 	}
 }
 
+func (s *Storage) IterProjectIds(ctx context.Context, opts storage.ListOptions) iter.Seq2[string, error] {
+	return s.iterIds(ctx, "project_id", "", nil, opts)
+}
+
+func (s *Storage) IterDocumentIds(ctx context.Context, projectId string, opts storage.ListOptions) iter.Seq2[string, error] {
+	return s.iterIds(ctx, "document_id", "project_id = ? AND ", []any{projectId}, opts)
+}
+
+// iterIds pages through the distinct values of the column with a keyset query per page, so that each page is a range
+// scan on the primary key rather than an offset. The filter is a condition on the preceding key columns and must end
+// with AND.
+func (s *Storage) iterIds(ctx context.Context, column, filter string, filterArgs []any, opts storage.ListOptions) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		pageSize := opts.PageSize
+		if pageSize <= 0 {
+			pageSize = storage.DefaultPageSize
+		}
+		// The prefix is converted into a range so that it can use the index, since LIKE is case-insensitive.
+		condition := filter + column + ` > ? AND ` + column + ` >= ?`
+		upper, bounded := prefixUpperBound(opts.Prefix)
+		if bounded {
+			condition += ` AND ` + column + ` < ?`
+		}
+		query := `SELECT DISTINCT ` + column + ` FROM blobs WHERE ` + condition + ` ORDER BY ` + column + ` LIMIT ?`
+		after := opts.StartAfter
+		for {
+			args := append(append(make([]any, 0, len(filterArgs)+4), filterArgs...), after, opts.Prefix)
+			if bounded {
+				args = append(args, upper)
+			}
+			slog.Debug("executing iterate ids query", slog.String("column", column), slog.String("after", after))
+			ids, err := s.queryIds(ctx, query, append(args, pageSize)...)
+			if err != nil {
+				yield("", err)
+				return
+			}
+			for _, id := range ids {
+				if !yield(id, nil) {
+					return
+				}
+			}
+			if len(ids) < pageSize {
+				return
+			}
+			after = ids[len(ids)-1]
+		}
+	}
+}
+
+func (s *Storage) queryIds(ctx context.Context, query string, args ...any) ([]string, error) {
+	r, err := s.reader.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to perform iterate ids query: %w", err)
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			slog.Warn("failed to close query", slog.Any("err", err))
+		}
+	}()
+	out := make([]string, 0)
+	for r.Next() {
+		var id string
+		if err := r.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		out = append(out, id)
+	}
+	if err := r.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %w", err)
+	}
+	return out, nil
+}
+
+// prefixUpperBound returns the smallest string which is greater than every string with the prefix, or false if there
+// is no such bound because the prefix is empty or all 0xff bytes.
+func prefixUpperBound(prefix string) (string, bool) {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1]), true
+		}
+	}
+	return "", false
+}
+
 func (s *Storage) ListBlobs(ctx context.Context, projectId, documentId string) (blobs []storage.BlobIdAndSize, err error) {
 	slog.Debug("executing list blob ids query", slog.String("project", projectId), slog.String("document", documentId))
 	if r, err := s.reader.QueryContext(ctx, `SELECT blob_id, length(content) FROM blobs WHERE project_id = $1 AND document_id = $2`, projectId, documentId); err != nil {
@@ -152,6 +251,8 @@ func (s *Storage) ListBlobs(ctx context.Context, projectId, documentId string) (
 		}
 		if err := r.Err(); err != nil {
 			return nil, fmt.Errorf("failed to iterate rows: %w", err)
+		} else if len(out) == 0 {
+			return nil, storage.ErrDocumentNotFound
 		}
 		return out, nil
 	}
@@ -161,6 +262,11 @@ func (s *Storage) PutBlob(ctx context.Context, projectId, documentId, blobId str
 	if meta == nil {
 		meta = map[string]string{}
 	}
+	if blob == nil {
+		// A nil slice is written as NULL, which the content column does not allow. Empty blobs such as the trash
+		// tombstones are often read back into a nil slice, for example when migrating.
+		blob = []byte{}
+	}
 	metaRaw, _ := json.Marshal(meta)
 	slog.Debug("executing put blob", slog.String("project", projectId), slog.String("document", documentId), slog.String("blob", blobId), slog.Int("#content", len(blob)))
 	if r, err := s.writer.ExecContext(ctx, `INSERT INTO blobs VALUES ($1, $2, $3, $4, $5)
@@ -234,7 +340,15 @@ func (s *Storage) DeleteBlobs(ctx context.Context, projectId, documentId string,
 	if r, err := s.writer.ExecContext(ctx, `DELETE FROM blobs WHERE project_id = $1 AND document_id = $2 AND blob_id IN (`+argsPositions.String()+`)`, args...); err != nil {
 		return fmt.Errorf("failed to perform delete blobs query: %w", err)
 	} else if rc, _ := r.RowsAffected(); rc == 0 {
-		return storage.ErrDocumentNotFound
+		// None of the blobs existed, so this is only an error if the document itself doesn't exist.
+		var exists bool
+		if err := s.writer.QueryRowContext(
+			ctx, `SELECT EXISTS(SELECT 1 FROM blobs WHERE project_id = $1 AND document_id = $2)`, projectId, documentId,
+		).Scan(&exists); err != nil {
+			return fmt.Errorf("failed to check document existence: %w", err)
+		} else if !exists {
+			return storage.ErrDocumentNotFound
+		}
 	}
 	return nil
 }
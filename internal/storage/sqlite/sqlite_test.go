@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"testing"
 
+	"github.com/astromechza/memory-mouse/internal/storage/storagetest"
 	"github.com/astromechza/memory-mouse/internal/testsupport"
 )
 
@@ -20,6 +21,7 @@ func Test(t *testing.T) {
 	t.Parallel()
 	s, err := New(context.Background(), randomInMemoryDbString(), 2)
 	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, s.Ping(context.Background()), nil)
 
 	pId := strconv.Itoa(rand.Int())
 	dId := strconv.Itoa(rand.Int())
@@ -60,3 +62,44 @@ func Test(t *testing.T) {
 	testsupport.AssertEqual(t, err, nil)
 	testsupport.AssertEqual(t, ids, []string{})
 }
+
+func TestNotFoundSemantics(t *testing.T) {
+	t.Parallel()
+	s, err := New(context.Background(), randomInMemoryDbString(), 2)
+	testsupport.MustAssertEqual(t, err, nil)
+	storagetest.TestNotFoundSemantics(t, s)
+}
+
+func TestIterators(t *testing.T) {
+	t.Parallel()
+	s, err := New(context.Background(), randomInMemoryDbString(), 2)
+	testsupport.MustAssertEqual(t, err, nil)
+	storagetest.TestIterators(t, s)
+}
+
+func TestPutBlob_nil(t *testing.T) {
+	t.Parallel()
+	s, err := New(context.Background(), randomInMemoryDbString(), 2)
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.MustAssertEqual(t, s.PutBlob(context.Background(), "p", "d", "b", nil, nil), nil)
+	blob, err := s.HeadBlob(context.Background(), "p", "d", "b")
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, blob.Size, int64(0))
+}
+
+func TestPrefixUpperBound(t *testing.T) {
+	for _, tc := range []struct {
+		prefix   string
+		expected string
+		ok       bool
+	}{
+		{"", "", false},
+		{"ab", "ac", true},
+		{"a\xff", "b", true},
+		{"\xff\xff", "", false},
+	} {
+		upper, ok := prefixUpperBound(tc.prefix)
+		testsupport.AssertEqual(t, upper, tc.expected)
+		testsupport.AssertEqual(t, ok, tc.ok)
+	}
+}
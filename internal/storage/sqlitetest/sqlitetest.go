@@ -0,0 +1,25 @@
+// Package sqlitetest provides the in-memory sqlite storage used by the tests of the packages built on
+// storage.BlobStorage.
+package sqlitetest
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/astromechza/memory-mouse/internal/storage/sqlite"
+	"github.com/astromechza/memory-mouse/internal/testsupport"
+)
+
+// New returns an empty in-memory database which is closed when the test completes. Each database has a random name so
+// that tests don't share state through the shared cache.
+func New(t *testing.T) *sqlite.Storage {
+	t.Helper()
+	s, err := sqlite.New(context.Background(), fmt.Sprintf("file:db-%d.db?cache=shared&mode=memory", rand.Int64()), 0)
+	testsupport.MustAssertEqual(t, err, nil)
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+	return s
+}
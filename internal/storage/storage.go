@@ -3,7 +3,10 @@ package storage
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"iter"
+	"time"
 )
 
 var ErrDocumentNotFound = errors.New("document not found")
@@ -19,17 +22,37 @@ type BlobIdSizeAndMeta struct {
 	Metadata map[string]string
 }
 
+// DefaultPageSize is the number of ids fetched per request by the iterating listings when ListOptions.PageSize is 0.
+const DefaultPageSize = 1000
+
+// ListOptions filters and pages the iterating listings.
+type ListOptions struct {
+	// Prefix limits the listing to the ids that start with it.
+	Prefix string
+	// StartAfter skips the ids up to and including it, so passing the last id seen resumes a listing.
+	StartAfter string
+	// PageSize is the number of ids fetched from the backend per request, 0 means DefaultPageSize.
+	PageSize int
+}
+
 // BlobStorage is our abstraction over the backing storage interface whether it is an object storage api or another
 // backing storage like Sqlite or DuckDB.
 type BlobStorage interface {
 	// ListProjectIds is generally internal only for us to find all the projects and fully enumerate the space.
 	ListProjectIds(ctx context.Context) (projectIds []string, err error)
-	// ListDocumentIds allows us to list the document ids under a project. One day we might need to be able to retrieve
-	// more information per document, like descriptions or estimated sizes and things, but for now this api just returns
-	// the basic ids - which should always be possible without much stress.
+	// ListDocumentIds allows us to list the document ids under a project. This api just returns the basic ids - which
+	// should always be possible without much stress. More information per document, like titles and descriptions, is
+	// kept in a metadata blob by the documents package. The ids are returned in lexicographic order, which for
+	// uid.DocumentUid ids is also creation order.
 	ListDocumentIds(ctx context.Context, projectId string) (documentIds []string, err error)
+	// IterProjectIds is the paged form of ListProjectIds. Each page is only fetched once the iteration reaches it, so
+	// the caller can stop early without enumerating everything. An error is yielded at most once and ends the iteration.
+	IterProjectIds(ctx context.Context, opts ListOptions) iter.Seq2[string, error]
+	// IterDocumentIds is the paged form of ListDocumentIds, with the same semantics as IterProjectIds. The ids are
+	// yielded in lexicographic byte order on every backend, so a StartAfter cursor works the same way everywhere.
+	IterDocumentIds(ctx context.Context, projectId string, opts ListOptions) iter.Seq2[string, error]
 	// ListBlobs should list all the blobs for a document. No particular order is assumed, so the ids themselves should
-	// help to indicate the desired order. May return ErrDocumentNotFound.
+	// help to indicate the desired order (see uid.ChunkId). Returns ErrDocumentNotFound if the document has no blobs.
 	ListBlobs(ctx context.Context, projectId, documentId string) (blobs []BlobIdAndSize, err error)
 	// PutBlob will write or overwrite the target blob and set the given metadata. The blob is specified as a byte array
 	// rather than an io reader because the blobs are assumed to be in memory document dumps and we need a good way
@@ -40,7 +63,79 @@ type BlobStorage interface {
 	GetBlob(ctx context.Context, projectId, documentId, blobId string, dst io.Writer) (blob *BlobIdSizeAndMeta, err error)
 	// HeadBlob is the same as GetBlob but doesn't retrieve the content. This may return ErrBlobNotFound or ErrDocumentNotFound.
 	HeadBlob(ctx context.Context, projectId, documentId, blobId string) (blob *BlobIdSizeAndMeta, err error)
-	// DeleteBlobs deletes one or more blobs by id from the storage. This may return ErrDocumentNotFound but does not
-	// return ErrBlobNotFound - missing blobs are treated as deleted.
+	// DeleteBlobs deletes one or more blobs by id from the storage. This returns ErrDocumentNotFound if the document has
+	// no blobs at all, but does not return ErrBlobNotFound - missing blobs are treated as deleted.
 	DeleteBlobs(ctx context.Context, projectId, documentId string, blobIds []string) error
 }
+
+// BlobPresigner is an optional interface for BlobStorage implementations that can produce urls which allow a client to
+// download a blob directly from the backing storage without the content passing through this server.
+type BlobPresigner interface {
+	// PresignGetBlob returns a url which can be used to GET the blob contents until the expiry has elapsed. The blob is
+	// not checked for existence.
+	PresignGetBlob(projectId, documentId, blobId string, expiry time.Duration) (string, error)
+}
+
+// BlobStreamer is an optional interface for BlobStorage implementations that can write a blob from a reader without
+// holding the whole content in memory. Decorators should implement it by calling PutBlobFromReader on the inner storage.
+type BlobStreamer interface {
+	// PutBlobFromReader is the same as PutBlob but reads the content from the reader, which must produce exactly size
+	// bytes.
+	PutBlobFromReader(ctx context.Context, projectId, documentId, blobId string, meta map[string]string, src io.Reader, size int64) error
+}
+
+// PutBlobFromReader writes the blob with the BlobStreamer of the storage, or reads the content into memory and calls
+// PutBlob if the storage can't stream.
+func PutBlobFromReader(ctx context.Context, s BlobStorage, projectId, documentId, blobId string, meta map[string]string, src io.Reader, size int64) error {
+	if bs, ok := s.(BlobStreamer); ok {
+		return bs.PutBlobFromReader(ctx, projectId, documentId, blobId, meta, src, size)
+	}
+	blob := make([]byte, size)
+	if _, err := io.ReadFull(src, blob); err != nil {
+		return fmt.Errorf("failed to read %d bytes of content: %w", size, err)
+	} else if n, _ := src.Read(make([]byte, 1)); n > 0 {
+		return fmt.Errorf("content is longer than %d bytes", size)
+	}
+	return s.PutBlob(ctx, projectId, documentId, blobId, meta, blob)
+}
+
+// Pinger is an optional interface for BlobStorage implementations which can cheaply check that the backend is reachable
+// and the credentials are valid, for example for a readiness probe.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Ping checks the storage with the first Pinger found while unwrapping any decorators. Decorators should provide an
+// Unwrap() BlobStorage method so that optional interfaces remain reachable. Storage which can't be checked is assumed
+// to be reachable.
+func Ping(ctx context.Context, s BlobStorage) error {
+	for s != nil {
+		if p, ok := s.(Pinger); ok {
+			return p.Ping(ctx)
+		}
+		u, ok := s.(interface{ Unwrap() BlobStorage })
+		if !ok {
+			break
+		}
+		s = u.Unwrap()
+	}
+	return nil
+}
+
+// YieldTimed passes each id and error of the sequence to yield until it returns false, calling observe before each
+// yield. It returns the time spent in the sequence itself, excluding the time the caller spent in yield, so that the
+// decorators measure the storage rather than whatever the caller does with each id.
+func YieldTimed(seq iter.Seq2[string, error], yield func(string, error) bool, observe func(id string, err error)) time.Duration {
+	var elapsed time.Duration
+	resumed := time.Now()
+	for id, err := range seq {
+		elapsed += time.Since(resumed)
+		observe(id, err)
+		ok := yield(id, err)
+		resumed = time.Now()
+		if !ok {
+			break
+		}
+	}
+	return elapsed + time.Since(resumed)
+}
@@ -0,0 +1,138 @@
+// Package storagetest contains a conformance suite that every storage.BlobStorage implementation should pass so that
+// the handlers behave the same regardless of the backend.
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"iter"
+	"math/rand/v2"
+	"strconv"
+	"testing"
+
+	"github.com/astromechza/memory-mouse/internal/storage"
+	"github.com/astromechza/memory-mouse/internal/testsupport"
+)
+
+// TestNotFoundSemantics checks the ErrDocumentNotFound and ErrBlobNotFound behavior documented on storage.BlobStorage.
+func TestNotFoundSemantics(t *testing.T, s storage.BlobStorage) {
+	ctx := context.Background()
+	pId := strconv.Itoa(rand.Int())
+	dId := strconv.Itoa(rand.Int())
+
+	t.Run("missing document", func(t *testing.T) {
+		_, err := s.ListBlobs(ctx, pId, dId)
+		testsupport.AssertErrorIs(t, err, storage.ErrDocumentNotFound)
+		testsupport.AssertErrorIs(t, s.DeleteBlobs(ctx, pId, dId, []string{"0001"}), storage.ErrDocumentNotFound)
+		_, err = s.HeadBlob(ctx, pId, dId, "0001")
+		testsupport.AssertEqual(t, errors.Is(err, storage.ErrBlobNotFound) || errors.Is(err, storage.ErrDocumentNotFound), true)
+		_, err = s.GetBlob(ctx, pId, dId, "0001", new(bytes.Buffer))
+		testsupport.AssertEqual(t, errors.Is(err, storage.ErrBlobNotFound) || errors.Is(err, storage.ErrDocumentNotFound), true)
+	})
+
+	testsupport.MustAssertEqual(t, s.PutBlob(ctx, pId, dId, "0001", nil, []byte("a")), nil)
+
+	t.Run("missing blob in existing document", func(t *testing.T) {
+		_, err := s.HeadBlob(ctx, pId, dId, "0002")
+		testsupport.AssertErrorIs(t, err, storage.ErrBlobNotFound)
+		_, err = s.GetBlob(ctx, pId, dId, "0002", new(bytes.Buffer))
+		testsupport.AssertErrorIs(t, err, storage.ErrBlobNotFound)
+		// missing blobs are treated as already deleted
+		testsupport.AssertEqual(t, s.DeleteBlobs(ctx, pId, dId, []string{"0002"}), nil)
+		testsupport.AssertEqual(t, s.DeleteBlobs(ctx, pId, dId, []string{}), nil)
+	})
+
+	t.Run("deleted document", func(t *testing.T) {
+		testsupport.MustAssertEqual(t, s.DeleteBlobs(ctx, pId, dId, []string{"0001", "0002"}), nil)
+		_, err := s.ListBlobs(ctx, pId, dId)
+		testsupport.AssertErrorIs(t, err, storage.ErrDocumentNotFound)
+		testsupport.AssertErrorIs(t, s.DeleteBlobs(ctx, pId, dId, []string{"0001"}), storage.ErrDocumentNotFound)
+	})
+}
+
+// Collect drains the iterator into a slice, stopping at the first error.
+func Collect(seq iter.Seq2[string, error]) ([]string, error) {
+	out := make([]string, 0)
+	for id, err := range seq {
+		if err != nil {
+			return out, err
+		}
+		out = append(out, id)
+	}
+	return out, nil
+}
+
+// TestIterators checks the filtering and paging of IterProjectIds and IterDocumentIds.
+func TestIterators(t *testing.T, s storage.BlobStorage) {
+	ctx := context.Background()
+	pId := "iter-" + strconv.Itoa(rand.Int())
+	for _, dId := range []string{"b2", "a1", "b1", "a2", "b3"} {
+		testsupport.MustAssertEqual(t, s.PutBlob(ctx, pId, dId, "0001", nil, []byte("a")), nil)
+		testsupport.MustAssertEqual(t, s.PutBlob(ctx, pId, dId, "0002", nil, []byte("b")), nil)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		opts     storage.ListOptions
+		expected []string
+	}{
+		{"all", storage.ListOptions{}, []string{"a1", "a2", "b1", "b2", "b3"}},
+		{"paged", storage.ListOptions{PageSize: 2}, []string{"a1", "a2", "b1", "b2", "b3"}},
+		{"prefix", storage.ListOptions{Prefix: "b", PageSize: 1}, []string{"b1", "b2", "b3"}},
+		{"start after", storage.ListOptions{StartAfter: "a2", PageSize: 2}, []string{"b1", "b2", "b3"}},
+		{"start after missing id", storage.ListOptions{StartAfter: "b15"}, []string{"b2", "b3"}},
+		{"start after and prefix", storage.ListOptions{Prefix: "a", StartAfter: "a1"}, []string{"a2"}},
+		{"no match", storage.ListOptions{Prefix: "c"}, []string{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ids, err := Collect(s.IterDocumentIds(ctx, pId, tc.opts))
+			testsupport.AssertEqual(t, err, nil)
+			testsupport.AssertEqual(t, ids, tc.expected)
+		})
+	}
+
+	t.Run("stop early", func(t *testing.T) {
+		var ids []string
+		for id, err := range s.IterDocumentIds(ctx, pId, storage.ListOptions{PageSize: 1}) {
+			testsupport.MustAssertEqual(t, err, nil)
+			if ids = append(ids, id); len(ids) == 2 {
+				break
+			}
+		}
+		testsupport.AssertEqual(t, ids, []string{"a1", "a2"})
+	})
+
+	t.Run("projects", func(t *testing.T) {
+		ids, err := Collect(s.IterProjectIds(ctx, storage.ListOptions{Prefix: pId, PageSize: 1}))
+		testsupport.AssertEqual(t, err, nil)
+		testsupport.AssertEqual(t, ids, []string{pId})
+	})
+
+	// The ids are in plain byte order even when they contain bytes that sort before the '/' separator of S3 keys,
+	// where "a/" sorts after "a-b/" and "a.b/".
+	t.Run("bytes before slash", func(t *testing.T) {
+		pId := "iter-" + strconv.Itoa(rand.Int())
+		for _, dId := range []string{"a.b", "b", "a0", "a-b", "a", "a-b-c"} {
+			testsupport.MustAssertEqual(t, s.PutBlob(ctx, pId, dId, "0001", nil, []byte("a")), nil)
+		}
+		for _, tc := range []struct {
+			name     string
+			opts     storage.ListOptions
+			expected []string
+		}{
+			{"all", storage.ListOptions{}, []string{"a", "a-b", "a-b-c", "a.b", "a0", "b"}},
+			{"paged", storage.ListOptions{PageSize: 1}, []string{"a", "a-b", "a-b-c", "a.b", "a0", "b"}},
+			{"start after", storage.ListOptions{StartAfter: "a", PageSize: 1}, []string{"a-b", "a-b-c", "a.b", "a0", "b"}},
+			{"start after extended id", storage.ListOptions{StartAfter: "a-b", PageSize: 1}, []string{"a-b-c", "a.b", "a0", "b"}},
+			{"prefix", storage.ListOptions{Prefix: "a-", PageSize: 1}, []string{"a-b", "a-b-c"}},
+			{"prefix and start after", storage.ListOptions{Prefix: "a", StartAfter: "a.b"}, []string{"a0"}},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				ids, err := Collect(s.IterDocumentIds(ctx, pId, tc.opts))
+				testsupport.AssertEqual(t, err, nil)
+				testsupport.AssertEqual(t, ids, tc.expected)
+			})
+		}
+	})
+}
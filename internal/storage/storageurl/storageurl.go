@@ -0,0 +1,128 @@
+// Package storageurl opens a storage.BlobStorage from a url so that the server and the command line tools can select
+// and configure a backend with a single flag.
+//
+// The supported forms are:
+//
+//	sqlite:<connection string>        for example sqlite:data.db or sqlite:file:x.db?mode=memory&cache=shared
+//	s3://<bucket>[/<key prefix>]?...   with the optional query parameters below
+//
+// The s3 query parameters are endpoint (defaults to AWS), region (defaults to $AWS_REGION or us-east-1), style (virtual
+// or path), shards (0 to 2), and payload (signed, unsigned, or streaming). The credentials are always read from
+// $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY so that they never appear in flags or logs.
+package storageurl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/astromechza/memory-mouse/internal/storage"
+	"github.com/astromechza/memory-mouse/internal/storage/s3"
+	"github.com/astromechza/memory-mouse/internal/storage/sqlite"
+)
+
+// Backend is an opened storage along with the name of the backend, which is useful as a metric label.
+type Backend struct {
+	Name    string
+	Storage storage.BlobStorage
+	close   func() error
+}
+
+// Close releases any resources held by the storage.
+func (b *Backend) Close() error {
+	if b.close == nil {
+		return nil
+	}
+	return b.close()
+}
+
+// Option modifies how the storage is opened.
+type Option func(o *options)
+
+type options struct {
+	client s3.HttpDoer
+}
+
+// WithHttpClient sets the client used by http based backends, for example to add tracing. The default is
+// http.DefaultClient.
+func WithHttpClient(client s3.HttpDoer) Option {
+	return func(o *options) {
+		o.client = client
+	}
+}
+
+// Open parses the url and opens the storage it describes.
+func Open(ctx context.Context, rawUrl string, opts ...Option) (*Backend, error) {
+	o := &options{client: http.DefaultClient}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if connString, ok := strings.CutPrefix(rawUrl, "sqlite:"); ok {
+		if connString == "" {
+			return nil, fmt.Errorf("sqlite storage url requires a connection string")
+		}
+		s, err := sqlite.New(ctx, connString, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open sqlite storage: %w", err)
+		}
+		return &Backend{Name: "sqlite", Storage: s, close: s.Close}, nil
+	} else if strings.HasPrefix(rawUrl, "s3://") {
+		s, err := openS3(rawUrl, o.client, os.Getenv)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open s3 storage: %w", err)
+		}
+		return &Backend{Name: "s3", Storage: s}, nil
+	}
+	return nil, fmt.Errorf("unsupported storage url '%s': expected sqlite:<connection string> or s3://<bucket>", rawUrl)
+}
+
+func openS3(rawUrl string, client s3.HttpDoer, getenv func(string) string) (*s3.Storage, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse url: %w", err)
+	}
+	q := u.Query()
+	var opts []s3.Option
+	if prefix := strings.Trim(u.Path, "/"); prefix != "" {
+		opts = append(opts, s3.WithKeyPrefix(prefix))
+	}
+	switch style := q.Get("style"); style {
+	case "", "virtual":
+	case "path":
+		opts = append(opts, s3.WithAddressingStyle(s3.PathStyle))
+	default:
+		return nil, fmt.Errorf("invalid style '%s': expected virtual or path", style)
+	}
+	if raw := q.Get("shards"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid shards '%s': %w", raw, err)
+		}
+		opts = append(opts, s3.WithHashedShards(n))
+	}
+	switch payload := q.Get("payload"); payload {
+	case "", "signed":
+	case "unsigned":
+		opts = append(opts, s3.WithPayloadSigning(s3.PayloadUnsigned))
+	case "streaming":
+		opts = append(opts, s3.WithPayloadSigning(s3.PayloadStreaming))
+	default:
+		return nil, fmt.Errorf("invalid payload '%s': expected signed, unsigned, or streaming", payload)
+	}
+	region := q.Get("region")
+	if region == "" {
+		region = getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	keyId, secret := getenv("AWS_ACCESS_KEY_ID"), getenv("AWS_SECRET_ACCESS_KEY")
+	if keyId == "" || secret == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	return s3.NewWithEndpoint(client, q.Get("endpoint"), u.Host, region, keyId, secret, opts...)
+}
@@ -0,0 +1,62 @@
+package storageurl
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/astromechza/memory-mouse/internal/testsupport"
+)
+
+func TestOpen_sqlite(t *testing.T) {
+	b, err := Open(context.Background(), "sqlite:file:storageurl.db?mode=memory&cache=shared")
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, b.Name, "sqlite")
+	ids, err := b.Storage.ListProjectIds(context.Background())
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, len(ids), 0)
+	testsupport.AssertEqual(t, b.Close(), nil)
+}
+
+func TestOpen_errors(t *testing.T) {
+	for _, tc := range []struct {
+		in, expected string
+	}{
+		{"", "unsupported storage url '': expected sqlite:<connection string> or s3://<bucket>"},
+		{"postgres://x", "unsupported storage url 'postgres://x': expected sqlite:<connection string> or s3://<bucket>"},
+		{"sqlite:", "sqlite storage url requires a connection string"},
+	} {
+		_, err := Open(context.Background(), tc.in)
+		testsupport.AssertErrorEqual(t, err, tc.expected)
+	}
+}
+
+func TestOpenS3(t *testing.T) {
+	env := map[string]string{"AWS_ACCESS_KEY_ID": "AKIAFAKE", "AWS_SECRET_ACCESS_KEY": "fake-secret"}
+	getenv := func(k string) string {
+		return env[k]
+	}
+	for _, tc := range []struct {
+		in, expected string
+	}{
+		{"s3://bucket", ""},
+		{"s3://bucket/some/prefix?endpoint=http://localhost:9000&style=path&shards=1&payload=streaming&region=eu-west-1", ""},
+		{"s3://bucket?style=sideways", "invalid style 'sideways': expected virtual or path"},
+		{"s3://bucket?shards=x", "invalid shards 'x': strconv.Atoi: parsing \"x\": invalid syntax"},
+		{"s3://bucket?payload=maybe", "invalid payload 'maybe': expected signed, unsigned, or streaming"},
+		{"s3://Not_A_Bucket", "invalid bucket name 'Not_A_Bucket'"},
+	} {
+		t.Run(tc.in, func(t *testing.T) {
+			s, err := openS3(tc.in, http.DefaultClient, getenv)
+			if tc.expected == "" {
+				testsupport.AssertEqual(t, err, nil)
+				testsupport.AssertEqual(t, s != nil, true)
+			} else {
+				testsupport.AssertErrorEqual(t, err, tc.expected)
+			}
+		})
+	}
+	delete(env, "AWS_SECRET_ACCESS_KEY")
+	_, err := openS3("s3://bucket", http.DefaultClient, getenv)
+	testsupport.AssertErrorEqual(t, err, "AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+}
@@ -0,0 +1,136 @@
+// Package traced provides a storage.BlobStorage decorator which wraps every call in a span carrying the project,
+// document, and blob ids, so that slow requests can be broken down by the storage calls they make.
+package traced
+
+import (
+	"context"
+	"errors"
+	"io"
+	"iter"
+
+	"github.com/astromechza/memory-mouse/internal/storage"
+	"github.com/astromechza/memory-mouse/internal/tracing"
+)
+
+// Storage wraps another storage.BlobStorage and records a span for each call.
+type Storage struct {
+	inner   storage.BlobStorage
+	backend string
+	tracer  *tracing.Tracer
+}
+
+var _ storage.BlobStorage = (*Storage)(nil)
+var _ storage.BlobStreamer = (*Storage)(nil)
+
+// New wraps the inner storage. If the inner storage implements storage.BlobPresigner, so does the returned storage.
+func New(inner storage.BlobStorage, backend string, tracer *tracing.Tracer) storage.BlobStorage {
+	s := &Storage{inner: inner, backend: backend, tracer: tracer}
+	if p, ok := inner.(storage.BlobPresigner); ok {
+		return &presignerStorage{Storage: s, BlobPresigner: p}
+	}
+	return s
+}
+
+type presignerStorage struct {
+	*Storage
+	storage.BlobPresigner
+}
+
+// Unwrap returns the inner storage.
+func (s *Storage) Unwrap() storage.BlobStorage {
+	return s.inner
+}
+
+func (s *Storage) start(ctx context.Context, method string, attrs ...tracing.Attribute) (context.Context, *tracing.Span) {
+	return s.tracer.Start(ctx, tracing.SpanKindInternal, "BlobStorage."+method, append(attrs, tracing.String("storage.backend", s.backend))...)
+}
+
+// end records the error on the span and ends it. Not found errors are part of normal operation, so they are recorded
+// as an attribute rather than marking the span as failed.
+func end(span *tracing.Span, err error) {
+	if errors.Is(err, storage.ErrDocumentNotFound) || errors.Is(err, storage.ErrBlobNotFound) {
+		span.SetAttributes(tracing.Bool("storage.not_found", true))
+	} else {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+func (s *Storage) ListProjectIds(ctx context.Context) (projectIds []string, err error) {
+	ctx, span := s.start(ctx, "ListProjectIds")
+	defer func() { end(span, err) }()
+	return s.inner.ListProjectIds(ctx)
+}
+
+func (s *Storage) ListDocumentIds(ctx context.Context, projectId string) (documentIds []string, err error) {
+	ctx, span := s.start(ctx, "ListDocumentIds", tracing.String("project.id", projectId))
+	defer func() { end(span, err) }()
+	return s.inner.ListDocumentIds(ctx, projectId)
+}
+
+func (s *Storage) IterProjectIds(ctx context.Context, opts storage.ListOptions) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		ctx, span := s.start(ctx, "IterProjectIds", tracing.String("list.prefix", opts.Prefix))
+		traceIter(span, s.inner.IterProjectIds(ctx, opts), yield)
+	}
+}
+
+func (s *Storage) IterDocumentIds(ctx context.Context, projectId string, opts storage.ListOptions) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		ctx, span := s.start(ctx, "IterDocumentIds", tracing.String("project.id", projectId), tracing.String("list.prefix", opts.Prefix))
+		traceIter(span, s.inner.IterDocumentIds(ctx, projectId, opts), yield)
+	}
+}
+
+// traceIter passes the ids through to yield and ends the span with the number of ids once the iteration completes or
+// the caller stops. The span stays open for the whole iteration so that the requests of the inner sequence are nested
+// within it, so the time spent in the inner sequence alone is recorded as the list.duration_ms attribute.
+func traceIter(span *tracing.Span, seq iter.Seq2[string, error], yield func(string, error) bool) {
+	var err error
+	var count int
+	d := storage.YieldTimed(seq, yield, func(_ string, e error) {
+		if err = e; err == nil {
+			count++
+		}
+	})
+	span.SetAttributes(tracing.Int("list.count", count), tracing.Int("list.duration_ms", int(d.Milliseconds())))
+	end(span, err)
+}
+
+func (s *Storage) ListBlobs(ctx context.Context, projectId, documentId string) (blobs []storage.BlobIdAndSize, err error) {
+	ctx, span := s.start(ctx, "ListBlobs", tracing.String("project.id", projectId), tracing.String("document.id", documentId))
+	defer func() { end(span, err) }()
+	return s.inner.ListBlobs(ctx, projectId, documentId)
+}
+
+func (s *Storage) PutBlob(ctx context.Context, projectId, documentId, blobId string, meta map[string]string, blob []byte) (err error) {
+	ctx, span := s.start(ctx, "PutBlob", tracing.String("project.id", projectId), tracing.String("document.id", documentId),
+		tracing.String("blob.id", blobId), tracing.Int("blob.size", len(blob)))
+	defer func() { end(span, err) }()
+	return s.inner.PutBlob(ctx, projectId, documentId, blobId, meta, blob)
+}
+
+func (s *Storage) PutBlobFromReader(ctx context.Context, projectId, documentId, blobId string, meta map[string]string, src io.Reader, size int64) (err error) {
+	ctx, span := s.start(ctx, "PutBlobFromReader", tracing.String("project.id", projectId), tracing.String("document.id", documentId),
+		tracing.String("blob.id", blobId), tracing.Int("blob.size", int(size)))
+	defer func() { end(span, err) }()
+	return storage.PutBlobFromReader(ctx, s.inner, projectId, documentId, blobId, meta, src, size)
+}
+
+func (s *Storage) GetBlob(ctx context.Context, projectId, documentId, blobId string, dst io.Writer) (blob *storage.BlobIdSizeAndMeta, err error) {
+	ctx, span := s.start(ctx, "GetBlob", tracing.String("project.id", projectId), tracing.String("document.id", documentId), tracing.String("blob.id", blobId))
+	defer func() { end(span, err) }()
+	return s.inner.GetBlob(ctx, projectId, documentId, blobId, dst)
+}
+
+func (s *Storage) HeadBlob(ctx context.Context, projectId, documentId, blobId string) (blob *storage.BlobIdSizeAndMeta, err error) {
+	ctx, span := s.start(ctx, "HeadBlob", tracing.String("project.id", projectId), tracing.String("document.id", documentId), tracing.String("blob.id", blobId))
+	defer func() { end(span, err) }()
+	return s.inner.HeadBlob(ctx, projectId, documentId, blobId)
+}
+
+func (s *Storage) DeleteBlobs(ctx context.Context, projectId, documentId string, blobIds []string) (err error) {
+	ctx, span := s.start(ctx, "DeleteBlobs", tracing.String("project.id", projectId), tracing.String("document.id", documentId), tracing.Int("blob.count", len(blobIds)))
+	defer func() { end(span, err) }()
+	return s.inner.DeleteBlobs(ctx, projectId, documentId, blobIds)
+}
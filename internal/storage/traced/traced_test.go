@@ -0,0 +1,125 @@
+package traced
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/astromechza/memory-mouse/internal/storage"
+	"github.com/astromechza/memory-mouse/internal/storage/sqlitetest"
+	"github.com/astromechza/memory-mouse/internal/testsupport"
+	"github.com/astromechza/memory-mouse/internal/tracing"
+)
+
+type recordingExporter struct {
+	lock  sync.Mutex
+	spans []*tracing.SpanData
+}
+
+func (r *recordingExporter) Export(ctx context.Context, spans []*tracing.SpanData) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func (r *recordingExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func TestStorage(t *testing.T) {
+	inner := sqlitetest.New(t)
+	e := new(recordingExporter)
+	tracer := tracing.NewTracer(e)
+	defer tracer.Shutdown(context.Background())
+	s := New(inner, "sqlite", tracer)
+
+	ctx, parent := tracer.Start(context.Background(), tracing.SpanKindServer, "parent")
+	testsupport.AssertEqual(t, s.PutBlob(ctx, "p", "d", "b", nil, []byte("abc")), nil)
+	_, err := s.HeadBlob(ctx, "p", "d", "missing")
+	testsupport.AssertEqual(t, err != nil, true)
+	parent.End()
+
+	testsupport.MustAssertEqual(t, tracer.Flush(context.Background()), nil)
+	testsupport.MustAssertEqual(t, len(e.spans), 3)
+	put, head := e.spans[0], e.spans[1]
+	testsupport.AssertEqual(t, put.Name, "BlobStorage.PutBlob")
+	testsupport.AssertEqual(t, put.Parent, parent.Context().SpanId)
+	testsupport.AssertEqual(t, put.Attributes, []tracing.Attribute{
+		tracing.String("project.id", "p"), tracing.String("document.id", "d"), tracing.String("blob.id", "b"),
+		tracing.Int("blob.size", 3), tracing.String("storage.backend", "sqlite"),
+	})
+	testsupport.AssertEqual(t, head.Name, "BlobStorage.HeadBlob")
+	testsupport.AssertEqual(t, head.Error, false)
+	testsupport.AssertEqual(t, head.Attributes[len(head.Attributes)-1], tracing.Bool("storage.not_found", true))
+
+	_, isPresigner := s.(storage.BlobPresigner)
+	testsupport.AssertEqual(t, isPresigner, false)
+}
+
+func TestStorage_iterators(t *testing.T) {
+	inner := sqlitetest.New(t)
+	e := new(recordingExporter)
+	tracer := tracing.NewTracer(e)
+	defer tracer.Shutdown(context.Background())
+	s := New(inner, "sqlite", tracer)
+	for _, d := range []string{"d1", "d2", "d3"} {
+		testsupport.MustAssertEqual(t, inner.PutBlob(context.Background(), "p", d, "b", nil, []byte("abc")), nil)
+	}
+
+	// The span covers the iteration and ends when the caller stops.
+	for range s.IterDocumentIds(context.Background(), "p", storage.ListOptions{PageSize: 1}) {
+		break
+	}
+	testsupport.MustAssertEqual(t, tracer.Flush(context.Background()), nil)
+	testsupport.MustAssertEqual(t, len(e.spans), 1)
+	testsupport.AssertEqual(t, e.spans[0].Name, "BlobStorage.IterDocumentIds")
+	attrs := e.spans[0].Attributes
+	testsupport.MustAssertEqual(t, len(attrs), 5)
+	testsupport.AssertEqual(t, attrs[:4], []tracing.Attribute{
+		tracing.String("project.id", "p"), tracing.String("list.prefix", ""), tracing.String("storage.backend", "sqlite"),
+		tracing.Int("list.count", 1),
+	})
+	testsupport.AssertEqual(t, attrs[4].Key, "list.duration_ms")
+
+	// The time the caller spends on each id is part of the span but not of the duration attribute.
+	e.spans = nil
+	for range s.IterDocumentIds(context.Background(), "p", storage.ListOptions{}) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	testsupport.MustAssertEqual(t, tracer.Flush(context.Background()), nil)
+	testsupport.MustAssertEqual(t, len(e.spans), 1)
+	testsupport.AssertEqual(t, e.spans[0].End.Sub(e.spans[0].Start) >= 60*time.Millisecond, true)
+	testsupport.AssertEqual(t, e.spans[0].Attributes[3], tracing.Int("list.count", 3))
+	testsupport.AssertEqual(t, e.spans[0].Attributes[4].Value.(int64) < 20, true)
+}
+
+func TestStorage_put_blob_from_reader(t *testing.T) {
+	inner := sqlitetest.New(t)
+	e := new(recordingExporter)
+	tracer := tracing.NewTracer(e)
+	defer tracer.Shutdown(context.Background())
+	s := New(inner, "sqlite", tracer)
+
+	// The inner storage can't stream, so the content is buffered and written with PutBlob.
+	testsupport.MustAssertEqual(t, storage.PutBlobFromReader(context.Background(), s, "p", "d", "b", nil, strings.NewReader("abc"), 3), nil)
+	testsupport.MustAssertEqual(t, tracer.Flush(context.Background()), nil)
+	testsupport.MustAssertEqual(t, len(e.spans), 1)
+	testsupport.AssertEqual(t, e.spans[0].Name, "BlobStorage.PutBlobFromReader")
+	testsupport.AssertEqual(t, e.spans[0].Attributes, []tracing.Attribute{
+		tracing.String("project.id", "p"), tracing.String("document.id", "d"), tracing.String("blob.id", "b"),
+		tracing.Int("blob.size", 3), tracing.String("storage.backend", "sqlite"),
+	})
+	buff := new(bytes.Buffer)
+	_, err := inner.GetBlob(context.Background(), "p", "d", "b", buff)
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, buff.String(), "abc")
+
+	err = storage.PutBlobFromReader(context.Background(), s, "p", "d", "c", nil, strings.NewReader("abcd"), 3)
+	testsupport.AssertErrorEqual(t, err, "content is longer than 3 bytes")
+	err = storage.PutBlobFromReader(context.Background(), s, "p", "d", "c", nil, strings.NewReader("ab"), 3)
+	testsupport.AssertErrorEqual(t, err, "failed to read 3 bytes of content: unexpected EOF")
+}
@@ -1,6 +1,7 @@
 package testsupport
 
 import (
+	"errors"
 	"reflect"
 	"slices"
 	"testing"
@@ -42,3 +43,12 @@ func AssertErrorEqual(t *testing.T, actual error, expected string) bool {
 	}
 	return true
 }
+
+func AssertErrorIs(t *testing.T, actual, target error) bool {
+	t.Helper()
+	if !errors.Is(actual, target) {
+		t.Errorf("expected error %v to be %v", actual, target)
+		return false
+	}
+	return true
+}
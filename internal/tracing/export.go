@@ -0,0 +1,204 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Exporter sends batches of ended spans somewhere.
+type Exporter interface {
+	Export(ctx context.Context, spans []*SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+// HttpDoer is the subset of the http.Client used to send requests.
+type HttpDoer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+const serviceName = "memory-mouse"
+
+// The otlp* types are the OTLP/HTTP JSON encoding of an ExportTraceServiceRequest, see
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding. The ids are hex encoded and the 64-bit integers are
+// encoded as strings.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	Flags             uint32          `json:"flags"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+type otlpStatus struct {
+	Message string `json:"message,omitempty"`
+	Code    int    `json:"code,omitempty"`
+}
+
+// otlpStatusError is the OTLP STATUS_CODE_ERROR value. Spans without an error are left unset rather than ok.
+const otlpStatusError = 2
+
+func toOtlpValue(v any) otlpValue {
+	switch tv := v.(type) {
+	case string:
+		return otlpValue{StringValue: &tv}
+	case int64:
+		s := strconv.FormatInt(tv, 10)
+		return otlpValue{IntValue: &s}
+	case bool:
+		return otlpValue{BoolValue: &tv}
+	default:
+		s := fmt.Sprint(tv)
+		return otlpValue{StringValue: &s}
+	}
+}
+
+func toOtlpAttributes(attrs []Attribute) []otlpAttribute {
+	out := make([]otlpAttribute, len(attrs))
+	for i, a := range attrs {
+		out[i] = otlpAttribute{Key: a.Key, Value: toOtlpValue(a.Value)}
+	}
+	return out
+}
+
+// encodeOtlp converts the spans into a single OTLP request.
+func encodeOtlp(spans []*SpanData) *otlpRequest {
+	out := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		out[i] = otlpSpan{
+			TraceId:           s.Context.TraceId.String(),
+			SpanId:            s.Context.SpanId.String(),
+			Flags:             1,
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        toOtlpAttributes(s.Attributes),
+		}
+		if s.Parent.IsValid() {
+			out[i].ParentSpanId = s.Parent.String()
+		}
+		if s.Error {
+			out[i].Status = otlpStatus{Code: otlpStatusError, Message: s.StatusMessage}
+		}
+	}
+	return &otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: toOtlpAttributes([]Attribute{String("service.name", serviceName)})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: serviceName}, Spans: out}},
+	}}}
+}
+
+type otlpExporter struct {
+	client HttpDoer
+	url    string
+}
+
+// NewOtlpExporter returns an exporter which posts the spans to an OTLP/HTTP collector using the JSON encoding. The
+// endpoint is the base url of the collector, for example http://localhost:4318, and the /v1/traces path is added if it
+// is missing. The client should not itself be traced, otherwise each export would produce more spans.
+func NewOtlpExporter(client HttpDoer, endpoint string) Exporter {
+	u := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(u, "/v1/traces") {
+		u += "/v1/traces"
+	}
+	return &otlpExporter{client: client, url: u}
+}
+
+func (e *otlpExporter) Export(ctx context.Context, spans []*SpanData) error {
+	body, err := json.Marshal(encodeOtlp(spans))
+	if err != nil {
+		return fmt.Errorf("failed to encode spans: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send spans: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to send spans: collector returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func (e *otlpExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+type jsonExporter struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+// NewJsonExporter returns an exporter which writes each batch as a line of OTLP JSON, the same format as the
+// OpenTelemetry collector file exporter. This is useful for debugging without a collector.
+func NewJsonExporter(w io.Writer) Exporter {
+	return &jsonExporter{w: w}
+}
+
+func (e *jsonExporter) Export(ctx context.Context, spans []*SpanData) error {
+	body, err := json.Marshal(encodeOtlp(spans))
+	if err != nil {
+		return fmt.Errorf("failed to encode spans: %w", err)
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if _, err := e.w.Write(append(body, '\n')); err != nil {
+		return fmt.Errorf("failed to write spans: %w", err)
+	}
+	return nil
+}
+
+func (e *jsonExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
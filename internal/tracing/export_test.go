@@ -0,0 +1,65 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/astromechza/memory-mouse/internal/testsupport"
+)
+
+func testSpans() []*SpanData {
+	start := time.Unix(1700000000, 5)
+	return []*SpanData{{
+		Name:          "GET /documents/{id}",
+		Kind:          SpanKindServer,
+		Context:       SpanContext{TraceId: TraceId{0xab, 15: 0x01}, SpanId: SpanId{0xcd, 7: 0x02}, Sampled: true},
+		Parent:        SpanId{0xef, 7: 0x03},
+		Start:         start,
+		End:           start.Add(time.Millisecond),
+		Attributes:    []Attribute{String("document.id", "x"), Int("http.response.status_code", 500), Bool("flag", true)},
+		Error:         true,
+		StatusMessage: "500 Internal Server Error",
+	}}
+}
+
+const expectedOtlp = `{"resourceSpans":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"memory-mouse"}}]},` +
+	`"scopeSpans":[{"scope":{"name":"memory-mouse"},"spans":[{"traceId":"ab000000000000000000000000000001","spanId":"cd00000000000002",` +
+	`"parentSpanId":"ef00000000000003","flags":1,"name":"GET /documents/{id}","kind":2,"startTimeUnixNano":"1700000000000000005",` +
+	`"endTimeUnixNano":"1700000000001000005","attributes":[{"key":"document.id","value":{"stringValue":"x"}},` +
+	`{"key":"http.response.status_code","value":{"intValue":"500"}},{"key":"flag","value":{"boolValue":true}}],` +
+	`"status":{"message":"500 Internal Server Error","code":2}}]}]}]}`
+
+func TestJsonExporter(t *testing.T) {
+	buff := new(bytes.Buffer)
+	e := NewJsonExporter(buff)
+	testsupport.AssertEqual(t, e.Export(context.Background(), testSpans()), nil)
+	testsupport.AssertEqual(t, buff.String(), expectedOtlp+"\n")
+	testsupport.AssertEqual(t, e.Shutdown(context.Background()), nil)
+}
+
+func TestOtlpExporter(t *testing.T) {
+	var received []string
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+		received = append(received, request.Method+" "+request.URL.Path+" "+request.Header.Get("Content-Type")+" "+string(body))
+		if status != http.StatusOK {
+			http.Error(writer, "nope", status)
+		}
+	}))
+	defer server.Close()
+
+	e := NewOtlpExporter(server.Client(), server.URL+"/")
+	testsupport.AssertEqual(t, e.Export(context.Background(), testSpans()), nil)
+	testsupport.AssertEqual(t, received, []string{"POST /v1/traces application/json " + expectedOtlp})
+
+	status = http.StatusBadRequest
+	err := NewOtlpExporter(server.Client(), server.URL+"/v1/traces").Export(context.Background(), testSpans())
+	testsupport.AssertErrorEqual(t, err, "failed to send spans: collector returned 400 Bad Request: nope")
+	testsupport.AssertEqual(t, len(received), 2)
+}
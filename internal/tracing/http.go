@@ -0,0 +1,80 @@
+package tracing
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/astromechza/memory-mouse/internal/httpx"
+)
+
+// InstrumentHandler wraps the handler, which is expected to be a http.ServeMux, in a server span per request. The span
+// continues the trace from the incoming traceparent header, if any. The span is named after the mux pattern that
+// matched the request and carries the document id if the pattern has an {id} wildcard.
+func InstrumentHandler(tracer *Tracer, next http.Handler) http.Handler {
+	if tracer == nil {
+		return next
+	}
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		if sc, err := ParseTraceparent(request.Header.Get("traceparent")); err == nil {
+			ctx = ContextWithSpanContext(ctx, sc)
+		}
+		ctx, span := tracer.Start(ctx, SpanKindServer, request.Method, String("http.request.method", request.Method), String("url.path", request.URL.Path))
+		defer span.End()
+		request = request.WithContext(ctx)
+		sw := httpx.NewStatusWriter(writer)
+		next.ServeHTTP(sw, request)
+		// The mux sets the pattern and path values on the request once it has been routed.
+		if request.Pattern != "" {
+			span.SetName(request.Pattern)
+			span.SetAttributes(String("http.route", request.Pattern))
+		}
+		if id := request.PathValue("id"); id != "" {
+			span.SetAttributes(String("document.id", id))
+		}
+		span.SetAttributes(Int("http.response.status_code", sw.Status))
+		if sw.Status >= 500 {
+			span.RecordError(&statusError{sw.Status})
+		}
+	})
+}
+
+type statusError struct {
+	status int
+}
+
+func (e *statusError) Error() string {
+	return strconv.Itoa(e.status) + " " + http.StatusText(e.status)
+}
+
+type tracingDoer struct {
+	tracer *Tracer
+	inner  HttpDoer
+}
+
+// InstrumentDoer wraps the client so that each request gets a client span and carries the traceparent header of that
+// span. The header is added after the caller has built the request, so it is not part of any request signature.
+func InstrumentDoer(tracer *Tracer, inner HttpDoer) HttpDoer {
+	if tracer == nil {
+		return inner
+	}
+	return &tracingDoer{tracer: tracer, inner: inner}
+}
+
+func (d *tracingDoer) Do(req *http.Request) (*http.Response, error) {
+	ctx, span := d.tracer.Start(req.Context(), SpanKindClient, "HTTP "+req.Method,
+		String("http.request.method", req.Method), String("server.address", req.URL.Host), String("url.path", req.URL.Path))
+	defer span.End()
+	req = req.WithContext(ctx)
+	req.Header.Set("traceparent", span.Context().Traceparent())
+	resp, err := d.inner.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	span.SetAttributes(Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 500 {
+		span.RecordError(&statusError{resp.StatusCode})
+	}
+	return resp, nil
+}
@@ -0,0 +1,71 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/astromechza/memory-mouse/internal/testsupport"
+)
+
+func TestInstrumentHandler(t *testing.T) {
+	e := new(recordingExporter)
+	tracer := NewTracer(e)
+	defer tracer.Shutdown(context.Background())
+
+	var inner SpanContext
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /documents/{id}", func(writer http.ResponseWriter, request *http.Request) {
+		inner = SpanFromContext(request.Context()).Context()
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/documents/abc", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	InstrumentHandler(tracer, mux).ServeHTTP(httptest.NewRecorder(), req)
+
+	testsupport.MustAssertEqual(t, tracer.Flush(context.Background()), nil)
+	testsupport.MustAssertEqual(t, len(e.spans), 1)
+	s := e.spans[0]
+	testsupport.AssertEqual(t, s.Name, "GET /documents/{id}")
+	testsupport.AssertEqual(t, s.Kind, SpanKindServer)
+	testsupport.AssertEqual(t, s.Context, inner)
+	testsupport.AssertEqual(t, s.Context.TraceId.String(), "4bf92f3577b34da6a3ce929d0e0e4736")
+	testsupport.AssertEqual(t, s.Parent.String(), "00f067aa0ba902b7")
+	testsupport.AssertEqual(t, s.Attributes, []Attribute{
+		String("http.request.method", "GET"), String("url.path", "/documents/abc"), String("http.route", "GET /documents/{id}"),
+		String("document.id", "abc"), Int("http.response.status_code", 503),
+	})
+	testsupport.AssertEqual(t, s.Error, true)
+	testsupport.AssertEqual(t, s.StatusMessage, "503 Service Unavailable")
+}
+
+func TestInstrumentDoer(t *testing.T) {
+	e := new(recordingExporter)
+	tracer := NewTracer(e)
+	defer tracer.Shutdown(context.Background())
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		traceparent = request.Header.Get("traceparent")
+	}))
+	defer server.Close()
+
+	ctx, parent := tracer.Start(context.Background(), SpanKindServer, "parent")
+	req, _ := http.NewRequestWithContext(ctx, http.MethodHead, server.URL+"/bucket", nil)
+	resp, err := InstrumentDoer(tracer, server.Client()).Do(req)
+	testsupport.MustAssertEqual(t, err, nil)
+	_ = resp.Body.Close()
+	parent.End()
+
+	testsupport.MustAssertEqual(t, tracer.Flush(context.Background()), nil)
+	testsupport.MustAssertEqual(t, len(e.spans), 2)
+	client := e.spans[0]
+	testsupport.AssertEqual(t, client.Name, "HTTP HEAD")
+	testsupport.AssertEqual(t, client.Kind, SpanKindClient)
+	testsupport.AssertEqual(t, client.Parent, parent.Context().SpanId)
+	testsupport.AssertEqual(t, traceparent, client.Context.Traceparent())
+
+	// without a tracer the client is returned as is
+	testsupport.AssertEqual(t, InstrumentDoer(nil, http.DefaultClient), HttpDoer(http.DefaultClient))
+}
@@ -0,0 +1,368 @@
+// Package tracing is a small tracer which produces OpenTelemetry compatible spans, propagates the W3C trace context
+// (https://www.w3.org/TR/trace-context/), and exports spans with the OTLP/HTTP JSON encoding. Like the metrics package,
+// it only implements what this project needs so that we don't need to pull in the full SDK.
+//
+// A nil *Tracer is valid and produces nil spans, all the span methods are no-ops on a nil span. This allows tracing to
+// be disabled without any conditionals in the instrumented code.
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type TraceId [16]byte
+
+type SpanId [8]byte
+
+func (t TraceId) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+func (t TraceId) IsValid() bool {
+	return t != TraceId{}
+}
+
+func (s SpanId) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+func (s SpanId) IsValid() bool {
+	return s != SpanId{}
+}
+
+// SpanContext is the part of a span that is propagated across process boundaries.
+type SpanContext struct {
+	TraceId TraceId
+	SpanId  SpanId
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceId.IsValid() && sc.SpanId.IsValid()
+}
+
+// Traceparent returns the W3C traceparent header value for the span context.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceId.String() + "-" + sc.SpanId.String() + "-" + flags
+}
+
+// ParseTraceparent parses a W3C traceparent header value. Future versions are accepted as long as they start with the
+// version 00 fields.
+func ParseTraceparent(in string) (SpanContext, error) {
+	var sc SpanContext
+	if len(in) < 55 || in[2] != '-' || in[35] != '-' || in[52] != '-' || (len(in) > 55 && in[55] != '-') {
+		return sc, fmt.Errorf("invalid traceparent '%s': malformed", in)
+	} else if in[:2] == "ff" || (in[:2] == "00" && len(in) != 55) {
+		return sc, fmt.Errorf("invalid traceparent '%s': unsupported version", in)
+	}
+	var version, flags [1]byte
+	for _, part := range []struct {
+		dst []byte
+		src string
+	}{{version[:], in[0:2]}, {sc.TraceId[:], in[3:35]}, {sc.SpanId[:], in[36:52]}, {flags[:], in[53:55]}} {
+		// The hex decoder accepts upper case, but the spec requires lower case.
+		for _, c := range []byte(part.src) {
+			if c >= 'A' && c <= 'F' {
+				return SpanContext{}, fmt.Errorf("invalid traceparent '%s': upper case hex", in)
+			}
+		}
+		if _, err := hex.Decode(part.dst, []byte(part.src)); err != nil {
+			return SpanContext{}, fmt.Errorf("invalid traceparent '%s': %w", in, err)
+		}
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("invalid traceparent '%s': zero trace or span id", in)
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+// SpanKind matches the OTLP span kind values.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// Attribute is a key value pair attached to a span. The value must be a string, int64, or bool.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: int64(value)}
+}
+
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// SpanData is the immutable record of an ended span which is passed to the exporter.
+type SpanData struct {
+	Name          string
+	Kind          SpanKind
+	Context       SpanContext
+	Parent        SpanId
+	Start         time.Time
+	End           time.Time
+	Attributes    []Attribute
+	Error         bool
+	StatusMessage string
+}
+
+// Span is an in-progress span. It is safe for concurrent use.
+type Span struct {
+	tracer *Tracer
+	lock   sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+// Context returns the span context, or the zero span context for a nil span.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.Context
+}
+
+// SetName replaces the span name, this is useful when the name is only known once the work has started.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data.Name = name
+}
+
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+}
+
+// RecordError marks the span as failed with the error message. Nil errors are ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data.Error = true
+	s.data.StatusMessage = err.Error()
+}
+
+// End finishes the span and queues it for export if it is sampled. Calling End more than once has no effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = s.tracer.clock()
+	data := s.data
+	s.lock.Unlock()
+	if data.Context.Sampled {
+		s.tracer.enqueue(&data)
+	}
+}
+
+type spanKey struct{}
+
+// ContextWithSpanContext returns a context with a remote parent span context, for example from an incoming traceparent
+// header. Spans started from the returned context are children of the remote span.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanKey{}, &Span{data: SpanData{Context: sc}})
+}
+
+// SpanFromContext returns the current span or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+const (
+	defaultQueueSize = 2048
+	defaultBatchSize = 512
+	defaultInterval  = 5 * time.Second
+	exportTimeout    = 10 * time.Second
+)
+
+// Tracer creates spans and exports them in batches from a background goroutine.
+type Tracer struct {
+	exporter Exporter
+	clock    func() time.Time
+	queue    chan *SpanData
+	flush    chan chan struct{}
+	stop     chan struct{}
+	stopped  chan struct{}
+	closed   atomic.Bool
+	dropped  atomic.Int64
+}
+
+// NewTracer starts a tracer which exports to the given exporter. Shutdown must be called to flush the remaining spans.
+func NewTracer(exporter Exporter) *Tracer {
+	t := &Tracer{
+		exporter: exporter,
+		clock:    time.Now,
+		queue:    make(chan *SpanData, defaultQueueSize),
+		flush:    make(chan chan struct{}),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go t.run(defaultInterval)
+	return t
+}
+
+// Start starts a new span as a child of the span in the context, or as a new root span if there is none. The returned
+// context contains the new span.
+func (t *Tracer) Start(ctx context.Context, kind SpanKind, name string, attrs ...Attribute) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &Span{tracer: t, data: SpanData{Name: name, Kind: kind, Start: t.clock(), Attributes: attrs}}
+	if parent := SpanFromContext(ctx).Context(); parent.IsValid() {
+		s.data.Context.TraceId = parent.TraceId
+		s.data.Context.Sampled = parent.Sampled
+		s.data.Parent = parent.SpanId
+	} else {
+		randomBytes(s.data.Context.TraceId[:])
+		s.data.Context.Sampled = true
+	}
+	randomBytes(s.data.Context.SpanId[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func randomBytes(b []byte) {
+	for i := range b {
+		b[i] = byte(rand.Uint32())
+	}
+	// An all zero id is invalid, the chance of this is negligible but cheap to rule out.
+	if b[0] == 0 {
+		b[0] = 1
+	}
+}
+
+func (t *Tracer) enqueue(s *SpanData) {
+	if t.closed.Load() {
+		return
+	}
+	select {
+	case t.queue <- s:
+	default:
+		if t.dropped.Add(1) == 1 {
+			slog.Warn("trace export queue is full, dropping spans")
+		}
+	}
+}
+
+func (t *Tracer) run(interval time.Duration) {
+	defer close(t.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	batch := make([]*SpanData, 0, defaultBatchSize)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		defer cancel()
+		if err := t.exporter.Export(ctx, batch); err != nil {
+			slog.Warn("failed to export spans", slog.Int("spans", len(batch)), slog.Any("err", err))
+		}
+		batch = make([]*SpanData, 0, defaultBatchSize)
+	}
+	drain := func() {
+		for {
+			select {
+			case s := <-t.queue:
+				if batch = append(batch, s); len(batch) >= defaultBatchSize {
+					export()
+				}
+			default:
+				export()
+				return
+			}
+		}
+	}
+	for {
+		select {
+		case s := <-t.queue:
+			if batch = append(batch, s); len(batch) >= defaultBatchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case done := <-t.flush:
+			drain()
+			close(done)
+		case <-t.stop:
+			drain()
+			return
+		}
+	}
+}
+
+// Flush exports all the spans that have ended so far.
+func (t *Tracer) Flush(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	done := make(chan struct{})
+	select {
+	case t.flush <- done:
+	case <-t.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown exports the remaining spans and shuts down the exporter. Spans which end after this are discarded.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil || t.closed.Swap(true) {
+		return nil
+	}
+	close(t.stop)
+	select {
+	case <-t.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := t.exporter.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to shutdown exporter: %w", err)
+	}
+	return nil
+}
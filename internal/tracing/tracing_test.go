@@ -0,0 +1,124 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/astromechza/memory-mouse/internal/testsupport"
+)
+
+// recordingExporter keeps the exported spans in memory.
+type recordingExporter struct {
+	lock  sync.Mutex
+	spans []*SpanData
+}
+
+func (r *recordingExporter) Export(ctx context.Context, spans []*SpanData) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func (r *recordingExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func TestParseTraceparent(t *testing.T) {
+	sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, sc.TraceId.String(), "4bf92f3577b34da6a3ce929d0e0e4736")
+	testsupport.AssertEqual(t, sc.SpanId.String(), "00f067aa0ba902b7")
+	testsupport.AssertEqual(t, sc.Sampled, true)
+	testsupport.AssertEqual(t, sc.Traceparent(), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	sc, err = ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future")
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, sc.Sampled, false)
+
+	for _, tc := range []struct {
+		in, expected string
+	}{
+		{"", "invalid traceparent '': malformed"},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-x", "invalid traceparent '00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-x': unsupported version"},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "invalid traceparent 'ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01': unsupported version"},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", "invalid traceparent '00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01': upper case hex"},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01", "invalid traceparent '00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01': encoding/hex: invalid byte: U+0078 'x'"},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", "invalid traceparent '00-00000000000000000000000000000000-00f067aa0ba902b7-01': zero trace or span id"},
+	} {
+		_, err := ParseTraceparent(tc.in)
+		testsupport.AssertErrorEqual(t, err, tc.expected)
+	}
+}
+
+func TestTracer(t *testing.T) {
+	e := new(recordingExporter)
+	tracer := NewTracer(e)
+	ctx, root := tracer.Start(context.Background(), SpanKindServer, "root", String("a", "b"))
+	_, child := tracer.Start(ctx, SpanKindInternal, "child")
+	child.RecordError(errors.New("broken"))
+	child.End()
+	child.End()
+	root.SetName("renamed")
+	root.End()
+	root.SetAttributes(Int("ignored", 1))
+
+	testsupport.MustAssertEqual(t, tracer.Flush(context.Background()), nil)
+	testsupport.MustAssertEqual(t, len(e.spans), 2)
+	testsupport.AssertEqual(t, e.spans[0].Name, "child")
+	testsupport.AssertEqual(t, e.spans[0].Error, true)
+	testsupport.AssertEqual(t, e.spans[0].StatusMessage, "broken")
+	testsupport.AssertEqual(t, e.spans[0].Context.TraceId, root.Context().TraceId)
+	testsupport.AssertEqual(t, e.spans[0].Parent, root.Context().SpanId)
+	testsupport.AssertEqual(t, e.spans[1].Name, "renamed")
+	testsupport.AssertEqual(t, e.spans[1].Parent.IsValid(), false)
+	testsupport.AssertEqual(t, e.spans[1].Attributes, []Attribute{String("a", "b")})
+
+	// unsampled remote parents are propagated but not exported
+	remote := SpanContext{TraceId: TraceId{1}, SpanId: SpanId{2}}
+	_, unsampled := tracer.Start(ContextWithSpanContext(context.Background(), remote), SpanKindServer, "unsampled")
+	testsupport.AssertEqual(t, unsampled.Context().TraceId, remote.TraceId)
+	unsampled.End()
+
+	testsupport.MustAssertEqual(t, tracer.Shutdown(context.Background()), nil)
+	testsupport.AssertEqual(t, len(e.spans), 2)
+	_, late := tracer.Start(context.Background(), SpanKindInternal, "late")
+	late.End()
+	testsupport.AssertEqual(t, tracer.Shutdown(context.Background()), nil)
+	testsupport.AssertEqual(t, tracer.Flush(context.Background()), nil)
+}
+
+func TestTracer_nil(t *testing.T) {
+	var tracer *Tracer
+	ctx, span := tracer.Start(context.Background(), SpanKindInternal, "x")
+	testsupport.AssertEqual(t, ctx, context.Background())
+	span.SetName("y")
+	span.SetAttributes(String("a", "b"))
+	span.RecordError(errors.New("x"))
+	span.End()
+	testsupport.AssertEqual(t, span.Context().IsValid(), false)
+	testsupport.AssertEqual(t, tracer.Flush(context.Background()), nil)
+	testsupport.AssertEqual(t, tracer.Shutdown(context.Background()), nil)
+}
+
+func TestTracer_interval(t *testing.T) {
+	e := new(recordingExporter)
+	tracer := &Tracer{exporter: e, clock: time.Now, queue: make(chan *SpanData, 1), flush: make(chan chan struct{}), stop: make(chan struct{}), stopped: make(chan struct{})}
+	go tracer.run(time.Millisecond)
+	defer tracer.Shutdown(context.Background())
+	_, span := tracer.Start(context.Background(), SpanKindInternal, "x")
+	span.End()
+	for i := 0; i < 1000; i++ {
+		e.lock.Lock()
+		n := len(e.spans)
+		e.lock.Unlock()
+		if n == 1 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("span was not exported on the interval")
+}
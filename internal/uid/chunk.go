@@ -0,0 +1,121 @@
+package uid
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+const (
+	// ChunkSequenceWidth is the number of decimal digits in the sequence part of a chunk id. This matches the
+	// 00000000001 style from the design doc.
+	ChunkSequenceWidth = 11
+	// MaxChunkSequence is the largest sequence number that fits in the fixed width.
+	MaxChunkSequence = 99_999_999_999
+	// chunkEpochWidth is the number of decimal digits in the optional epoch part, this is enough for any uint32.
+	chunkEpochWidth = 10
+)
+
+var ErrInvalidChunkId = errors.New("invalid chunk id")
+
+// ChunkId identifies a blob within a document. The blobs are chunks of changes which must be loaded in order, so the
+// string form is designed so that lexicographic order is the same as logical order: the sequence number is zero padded
+// to a fixed width and is optionally followed by a '-' and the zero padded epoch of the writer that produced it. The
+// epoch allows a new writer to fence off chunks written by a previous owner of the document after a crash or
+// hand-over. An epoch of 0 is the same as no epoch, and it sorts before any other epoch for the same sequence.
+//
+// For example "00000000001" and "00000000002-0000000003".
+type ChunkId struct {
+	Sequence uint64
+	Epoch    uint32
+}
+
+// String returns the canonical string form of the chunk id. It panics if the sequence is larger than MaxChunkSequence.
+func (c ChunkId) String() string {
+	if c.Sequence > MaxChunkSequence {
+		panic(fmt.Sprintf("chunk sequence %d exceeds the maximum", c.Sequence))
+	}
+	if c.Epoch == 0 {
+		return fmt.Sprintf("%0*d", ChunkSequenceWidth, c.Sequence)
+	}
+	return fmt.Sprintf("%0*d-%0*d", ChunkSequenceWidth, c.Sequence, chunkEpochWidth, c.Epoch)
+}
+
+// Compare returns -1, 0, or +1 depending on whether c is before, the same as, or after o. This is always the same as
+// comparing the string forms.
+func (c ChunkId) Compare(o ChunkId) int {
+	if r := cmp.Compare(c.Sequence, o.Sequence); r != 0 {
+		return r
+	}
+	return cmp.Compare(c.Epoch, o.Epoch)
+}
+
+// Next returns the chunk id following this one for the same writer epoch.
+func (c ChunkId) Next() (ChunkId, error) {
+	if c.Sequence >= MaxChunkSequence {
+		return ChunkId{}, fmt.Errorf("%w: sequence exhausted", ErrInvalidChunkId)
+	}
+	return ChunkId{Sequence: c.Sequence + 1, Epoch: c.Epoch}, nil
+}
+
+// ParseChunkId parses and validates the canonical string form of a chunk id. Non-canonical forms, such as an explicit
+// zero epoch or the wrong width, are rejected so that each chunk has exactly one id.
+func ParseChunkId(s string) (ChunkId, error) {
+	seqPart, epochPart := s, ""
+	if len(s) > ChunkSequenceWidth {
+		if s[ChunkSequenceWidth] != '-' {
+			return ChunkId{}, fmt.Errorf("%w '%s': expected '-' after the sequence", ErrInvalidChunkId, s)
+		}
+		seqPart, epochPart = s[:ChunkSequenceWidth], s[ChunkSequenceWidth+1:]
+	}
+	out := ChunkId{}
+	var err error
+	if out.Sequence, err = parseFixedWidth(seqPart, ChunkSequenceWidth); err != nil {
+		return ChunkId{}, fmt.Errorf("%w '%s': sequence %w", ErrInvalidChunkId, s, err)
+	}
+	if len(s) > ChunkSequenceWidth {
+		epoch, err := parseFixedWidth(epochPart, chunkEpochWidth)
+		if err != nil {
+			return ChunkId{}, fmt.Errorf("%w '%s': epoch %w", ErrInvalidChunkId, s, err)
+		} else if epoch == 0 {
+			return ChunkId{}, fmt.Errorf("%w '%s': a zero epoch must be omitted", ErrInvalidChunkId, s)
+		} else if epoch > 1<<32-1 {
+			return ChunkId{}, fmt.Errorf("%w '%s': epoch is too large", ErrInvalidChunkId, s)
+		}
+		out.Epoch = uint32(epoch)
+	}
+	return out, nil
+}
+
+// parseFixedWidth parses a zero padded decimal of exactly the given width.
+func parseFixedWidth(s string, width int) (uint64, error) {
+	if len(s) != width {
+		return 0, fmt.Errorf("must be %d digits", width)
+	}
+	for _, c := range []byte(s) {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("must only contain digits")
+		}
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// ParseChunkIds parses all the given ids and returns them in order. An error is returned if any id is invalid.
+func ParseChunkIds(ids []string) ([]ChunkId, error) {
+	out := make([]ChunkId, 0, len(ids))
+	errs := make([]error, 0)
+	for _, id := range ids {
+		if c, err := ParseChunkId(id); err != nil {
+			errs = append(errs, err)
+		} else {
+			out = append(out, c)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(out, ChunkId.Compare)
+	return out, nil
+}
@@ -0,0 +1,77 @@
+package uid
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/astromechza/memory-mouse/internal/testsupport"
+)
+
+func TestChunkId_roundtrip(t *testing.T) {
+	for _, tc := range []struct {
+		id       ChunkId
+		expected string
+	}{
+		{ChunkId{}, "00000000000"},
+		{ChunkId{Sequence: 1}, "00000000001"},
+		{ChunkId{Sequence: 12, Epoch: 3}, "00000000012-0000000003"},
+		{ChunkId{Sequence: MaxChunkSequence, Epoch: 1<<32 - 1}, "99999999999-4294967295"},
+	} {
+		testsupport.AssertEqual(t, tc.id.String(), tc.expected)
+		c, err := ParseChunkId(tc.expected)
+		if testsupport.AssertEqual(t, err, nil) {
+			testsupport.AssertEqual(t, c, tc.id)
+		}
+	}
+}
+
+func TestParseChunkId_invalid(t *testing.T) {
+	for _, tc := range []struct {
+		in, expected string
+	}{
+		{"", "invalid chunk id '': sequence must be 11 digits"},
+		{"1", "invalid chunk id '1': sequence must be 11 digits"},
+		{"0000000000x", "invalid chunk id '0000000000x': sequence must only contain digits"},
+		{"00000000001_0000000001", "invalid chunk id '00000000001_0000000001': expected '-' after the sequence"},
+		{"00000000001-", "invalid chunk id '00000000001-': epoch must be 10 digits"},
+		{"00000000001-0000000000", "invalid chunk id '00000000001-0000000000': a zero epoch must be omitted"},
+		{"00000000001-9999999999", "invalid chunk id '00000000001-9999999999': epoch is too large"},
+		{"+0000000001", "invalid chunk id '+0000000001': sequence must only contain digits"},
+	} {
+		_, err := ParseChunkId(tc.in)
+		testsupport.AssertErrorEqual(t, err, tc.expected)
+		testsupport.AssertEqual(t, errors.Is(err, ErrInvalidChunkId), true)
+	}
+}
+
+func TestChunkId_order(t *testing.T) {
+	ids := []ChunkId{
+		{Sequence: 10}, {Sequence: 2, Epoch: 1}, {Sequence: 2}, {Sequence: 1, Epoch: 20}, {Sequence: 1, Epoch: 3},
+		{Sequence: 100},
+	}
+	strs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		strs = append(strs, id.String())
+	}
+	slices.SortFunc(ids, ChunkId.Compare)
+	slices.Sort(strs)
+	for i := range ids {
+		testsupport.AssertEqual(t, ids[i].String(), strs[i])
+	}
+
+	parsed, err := ParseChunkIds([]string{"00000000003", "00000000001-0000000002", "00000000001"})
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, parsed, []ChunkId{{Sequence: 1}, {Sequence: 1, Epoch: 2}, {Sequence: 3}})
+
+	_, err = ParseChunkIds([]string{"00000000003", "x"})
+	testsupport.AssertErrorEqual(t, err, "invalid chunk id 'x': sequence must be 11 digits")
+}
+
+func TestChunkId_next(t *testing.T) {
+	n, err := ChunkId{Sequence: 1, Epoch: 2}.Next()
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, n, ChunkId{Sequence: 2, Epoch: 2})
+	_, err = ChunkId{Sequence: MaxChunkSequence}.Next()
+	testsupport.AssertErrorEqual(t, err, "invalid chunk id: sequence exhausted")
+}
@@ -2,13 +2,26 @@ package uid
 
 import (
 	"crypto/rand"
+	"encoding/binary"
+	"fmt"
 	"io"
+	"sync"
+	"time"
 
 	"github.com/astromechza/memory-mouse/internal/cb32"
 )
 
+const (
+	// documentUidBytes is the number of raw bytes in a document uid, this encodes to 24 cb32 characters.
+	documentUidBytes = 5 * 3
+	// timestampBytes is the number of leading bytes in a sortable document uid that hold the unix millisecond timestamp.
+	timestampBytes = 6
+)
+
+// DocumentUidWithSource returns a document uid made up entirely of bytes read from the source. These uids have no
+// meaningful order.
 func DocumentUidWithSource(source io.Reader) string {
-	b := make([]byte, 5*3)
+	b := make([]byte, documentUidBytes)
 	if _, err := source.Read(b); err != nil {
 		panic(err)
 	}
@@ -22,6 +35,106 @@ func (f readerFunc) Read(b []byte) (n int, err error) {
 	return f(b)
 }
 
+// Generator produces ULID-style document uids made of a 48-bit unix millisecond timestamp followed by 72 bits of
+// randomness. Because the cb32 alphabet is in ascending ascii order, the uids sort lexicographically by creation time.
+// Within the same millisecond, the random part of the previous uid is incremented so that the uids produced by a single
+// Generator are strictly increasing even if the clock stalls or goes backwards.
+type Generator struct {
+	clock  func() time.Time
+	source io.Reader
+
+	lock   sync.Mutex
+	lastMs uint64
+	last   [documentUidBytes]byte
+}
+
+// NewGenerator returns a Generator using the given clock and source of randomness.
+func NewGenerator(clock func() time.Time, source io.Reader) *Generator {
+	return &Generator{clock: clock, source: source}
+}
+
+// DocumentUid returns the next sortable document uid.
+func (g *Generator) DocumentUid() string {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	ms := uint64(g.clock().UnixMilli())
+	if ms <= g.lastMs && g.lastMs > 0 {
+		ms = g.lastMs
+		if !increment(g.last[timestampBytes:]) {
+			// We've exhausted the random space for this millisecond, so borrow the next one.
+			ms += 1
+			g.fillRandom()
+		}
+	} else {
+		g.fillRandom()
+	}
+	g.lastMs = ms
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], ms)
+	copy(g.last[:timestampBytes], ts[8-timestampBytes:])
+	o, _ := cb32.EncodeCB32String(g.last[:])
+	return o
+}
+
+func (g *Generator) fillRandom() {
+	if _, err := io.ReadFull(g.source, g.last[timestampBytes:]); err != nil {
+		panic(err)
+	}
+}
+
+// increment adds one to the big endian number in b and returns false if it overflowed.
+func increment(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+var defaultGenerator = NewGenerator(time.Now, readerFunc(rand.Read))
+
+// DocumentUid returns a new sortable document uid using the current time and a cryptographically secure source of
+// randomness.
 func DocumentUid() string {
-	return DocumentUidWithSource(readerFunc(rand.Read))
+	return defaultGenerator.DocumentUid()
+}
+
+// documentUidLength is the number of cb32 characters in an encoded document uid.
+const documentUidLength = documentUidBytes * 8 / 5
+
+// ValidDocumentUid returns whether the string has the shape of a document uid. This allows malformed ids from urls or
+// human input to be rejected before they reach the storage.
+func ValidDocumentUid(documentUid string) bool {
+	if len(documentUid) != documentUidLength {
+		return false
+	}
+	b, err := cb32.DecodeCB32String(documentUid)
+	return err == nil && len(b) == documentUidBytes
+}
+
+// CanonicalDocumentUid returns the canonical form of a valid document uid, replacing lower case letters and the O/I/L
+// aliases. The boolean is false if the input is not a valid document uid. Two ids refer to the same document if and
+// only if their canonical forms are equal, so the canonical form should be used for storage and authorization.
+func CanonicalDocumentUid(documentUid string) (string, bool) {
+	if !ValidDocumentUid(documentUid) {
+		return "", false
+	}
+	o, err := cb32.Canonicalize(documentUid)
+	return o, err == nil
+}
+
+// DocumentUidTime extracts the creation time from a sortable document uid. Uids generated by DocumentUidWithSource will
+// return a meaningless time.
+func DocumentUidTime(documentUid string) (time.Time, error) {
+	b, err := cb32.DecodeCB32String(documentUid)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to decode document uid: %w", err)
+	} else if len(b) != documentUidBytes {
+		return time.Time{}, fmt.Errorf("document uid has %d bytes, expected %d", len(b), documentUidBytes)
+	}
+	var ts [8]byte
+	copy(ts[8-timestampBytes:], b[:timestampBytes])
+	return time.UnixMilli(int64(binary.BigEndian.Uint64(ts[:]))), nil
 }
@@ -1,7 +1,10 @@
 package uid
 
 import (
+	"crypto/rand"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/astromechza/memory-mouse/internal/testsupport"
 )
@@ -25,3 +28,68 @@ func TestDocumentUidWithSource_numeric(t *testing.T) {
 		return len(b), nil
 	})), "000G40R40M30E209185GR38E")
 }
+
+func TestGenerator_sortable(t *testing.T) {
+	now := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	g := NewGenerator(func() time.Time {
+		return now
+	}, readerFunc(func(b []byte) (n int, err error) {
+		for i := range b {
+			b[i] = 0xff
+		}
+		return len(b), nil
+	}))
+
+	first := g.DocumentUid()
+	testsupport.AssertEqual(t, len(first), 24)
+	ts, err := DocumentUidTime(first)
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, ts.Equal(now), true)
+
+	// the random part is all 1s so the next uid in the same millisecond must overflow into the next millisecond
+	second := g.DocumentUid()
+	testsupport.AssertEqual(t, second > first, true)
+	ts, _ = DocumentUidTime(second)
+	testsupport.AssertEqual(t, ts.Equal(now.Add(time.Millisecond)), true)
+
+	// even if the clock goes backwards, the uids keep increasing
+	now = now.Add(-time.Hour)
+	third := g.DocumentUid()
+	testsupport.AssertEqual(t, third > second, true)
+}
+
+func TestGenerator_monotonic(t *testing.T) {
+	g := NewGenerator(time.Now, readerFunc(rand.Read))
+	ids := make([]string, 1000)
+	for i := range ids {
+		ids[i] = g.DocumentUid()
+	}
+	testsupport.AssertEqual(t, slices.IsSorted(ids), true)
+	testsupport.AssertEqual(t, len(slices.Compact(slices.Clone(ids))), len(ids))
+}
+
+func TestDocumentUidTime_invalid(t *testing.T) {
+	_, err := DocumentUidTime("0000")
+	testsupport.AssertErrorEqual(t, err, "document uid has 2 bytes, expected 15")
+}
+
+func TestValidDocumentUid(t *testing.T) {
+	testsupport.AssertEqual(t, ValidDocumentUid(DocumentUid()), true)
+	testsupport.AssertEqual(t, ValidDocumentUid("000G40R40M30E209185GR38E"), true)
+	testsupport.AssertEqual(t, ValidDocumentUid(""), false)
+	testsupport.AssertEqual(t, ValidDocumentUid("000G40R40M30E209185GR38"), false)
+	testsupport.AssertEqual(t, ValidDocumentUid("000G40R40M30E209185GR38EE"), false)
+	testsupport.AssertEqual(t, ValidDocumentUid("000G40R40M30E209185GR38!"), false)
+	testsupport.AssertEqual(t, ValidDocumentUid("000G40R40M30E209185GR38U"), false)
+}
+
+func TestCanonicalDocumentUid(t *testing.T) {
+	o, ok := CanonicalDocumentUid("000g40r40m30e2O9I85gr38e")
+	testsupport.AssertEqual(t, ok, true)
+	testsupport.AssertEqual(t, o, "000G40R40M30E209185GR38E")
+	o, ok = CanonicalDocumentUid("000G40R40M30E209185GR38E")
+	testsupport.AssertEqual(t, ok, true)
+	testsupport.AssertEqual(t, o, "000G40R40M30E209185GR38E")
+	_, ok = CanonicalDocumentUid("000G40R40M30E209185GR38!")
+	testsupport.AssertEqual(t, ok, false)
+}
@@ -2,16 +2,32 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+
+	"github.com/astromechza/memory-mouse/internal/archive"
+	"github.com/astromechza/memory-mouse/internal/documents"
+	"github.com/astromechza/memory-mouse/internal/metrics"
+	"github.com/astromechza/memory-mouse/internal/storage"
+	"github.com/astromechza/memory-mouse/internal/storage/metered"
+	"github.com/astromechza/memory-mouse/internal/storage/storageurl"
+	"github.com/astromechza/memory-mouse/internal/storage/traced"
+	"github.com/astromechza/memory-mouse/internal/tracing"
+	"github.com/astromechza/memory-mouse/internal/uid"
 )
 
 func main() {
@@ -24,6 +40,14 @@ func main() {
 type mainOptions struct {
 	address  string
 	logLevel int
+	storage  string
+
+	otlpEndpoint string
+	traceFile    string
+	drainDelay   time.Duration
+
+	trashRetention time.Duration
+	purgeInterval  time.Duration
 }
 
 func parseFlags(args []string) (*mainOptions, error) {
@@ -31,13 +55,495 @@ func parseFlags(args []string) (*mainOptions, error) {
 	opts := new(mainOptions)
 	fs.StringVar(&opts.address, "address", ":8080", "address to listen on")
 	fs.IntVar(&opts.logLevel, "loglevel", 0, "log level (DEBUG=-1,INFO=0,WARN=1,ERROR=2)")
+	fs.StringVar(&opts.storage, "storage", "sqlite:file:memory-mouse.db?mode=memory&cache=shared", "storage url (sqlite:<connection string> or s3://<bucket>[/<prefix>]?endpoint=&region=&style=&shards=&payload=)")
+	fs.StringVar(&opts.otlpEndpoint, "otlp-endpoint", "", "export trace spans to this OTLP/HTTP collector, for example http://localhost:4318")
+	fs.DurationVar(&opts.drainDelay, "drain-delay", 0, "time to keep serving with a failing /readyz after a shutdown signal, so load balancers can stop routing to this server")
+	fs.DurationVar(&opts.trashRetention, "trash-retention", 30*24*time.Hour, "time to keep deleted documents in the trash before they are purged")
+	fs.DurationVar(&opts.purgeInterval, "purge-interval", time.Hour, "time between runs of the trash purger")
+	fs.StringVar(&opts.traceFile, "trace-file", "", "append trace spans as OTLP JSON lines to this file for debugging")
 	if err := fs.Parse(args[1:]); err != nil {
 		return nil, fmt.Errorf("failed to parse flags: %w", err)
+	} else if opts.otlpEndpoint != "" && opts.traceFile != "" {
+		return nil, fmt.Errorf("only one of -otlp-endpoint and -trace-file can be set")
+	} else if opts.purgeInterval <= 0 {
+		return nil, fmt.Errorf("-purge-interval must be positive")
 	}
 	return opts, nil
 }
 
+// server holds the shared state of the http handlers.
+type server struct {
+	storage storage.BlobStorage
+	backend string
+	started time.Time
+	// draining is set once shutdown has started, so that the readiness probe fails while requests are drained.
+	draining atomic.Bool
+}
+
+// readyTimeout bounds the storage probe so that a hanging backend fails the probe rather than the probe timing out.
+const readyTimeout = 2 * time.Second
+
+// healthRoutes registers the liveness, readiness, and status endpoints on the mux.
+func (s *server) healthRoutes(mux *http.ServeMux) {
+	// healthz only checks that the server can respond, it must not depend on storage since a storage outage should not
+	// cause the server to be restarted.
+	mux.HandleFunc("GET /healthz", func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = writer.Write([]byte("ok\n"))
+	})
+
+	mux.HandleFunc("GET /readyz", func(writer http.ResponseWriter, request *http.Request) {
+		if s.draining.Load() {
+			http.Error(writer, "draining", http.StatusServiceUnavailable)
+			return
+		}
+		ctx, cancel := context.WithTimeout(request.Context(), readyTimeout)
+		defer cancel()
+		if err := storage.Ping(ctx, s.storage); err != nil {
+			slog.Warn("readiness probe failed", slog.Any("err", err.Error()))
+			http.Error(writer, "storage unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = writer.Write([]byte("ok\n"))
+	})
+
+	mux.HandleFunc("GET /debug/status", func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(writer).Encode(s.status())
+	})
+}
+
+// serverStatus is the body of the /debug/status endpoint.
+type serverStatus struct {
+	Started        time.Time `json:"started"`
+	Draining       bool      `json:"draining"`
+	StorageBackend string    `json:"storageBackend"`
+}
+
+func (s *server) status() *serverStatus {
+	return &serverStatus{
+		Started:        s.started.UTC(),
+		Draining:       s.draining.Load(),
+		StorageBackend: s.backend,
+	}
+}
+
+// projectIdHeader is set by the authz / session middleware in front of the server to the project of the request.
+const projectIdHeader = "X-Project-Id"
+
+// projectIdFromHeader returns the project id of the request, or writes a 400 response if it is missing.
+func projectIdFromHeader(writer http.ResponseWriter, request *http.Request) (string, bool) {
+	projectId := request.Header.Get(projectIdHeader)
+	if projectId == "" {
+		http.Error(writer, fmt.Sprintf("missing %s header", projectIdHeader), http.StatusBadRequest)
+		return "", false
+	}
+	return projectId, true
+}
+
+// writableProjectIdsHeader is set by the authz / session middleware to a comma separated list of the other projects that
+// the current user may write to. It is optional, and only used to authorize forks into another project.
+const writableProjectIdsHeader = "X-Writable-Project-Ids"
+
+// projectWritable returns whether the request may write to the project, which is either the project of the request or
+// one of the projects in the writableProjectIdsHeader.
+func projectWritable(request *http.Request, projectId string) bool {
+	if projectId == request.Header.Get(projectIdHeader) {
+		return true
+	}
+	for _, id := range strings.Split(request.Header.Get(writableProjectIdsHeader), ",") {
+		if strings.TrimSpace(id) == projectId {
+			return true
+		}
+	}
+	return false
+}
+
+// userIdHeader is set by the authz / session middleware to the id of the current user. It is optional, and only used to record
+// the creator in the metadata of new documents.
+const userIdHeader = "X-User-Id"
+
+// maxMetadataPatchBytes bounds the size of a metadata patch, which is well above the limit of the stored metadata.
+const maxMetadataPatchBytes = 64 << 10
+
+const (
+	// defaultListLimit and maxListLimit bound the page size of the document listing, since each document in the page
+	// needs a request to read its metadata.
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// documentList is a page of the document listing. Next is the cursor to pass as the after query parameter to get the
+// next page, and is empty on the last page.
+type documentList struct {
+	Documents []documents.DocumentInfo `json:"documents"`
+	Next      string                   `json:"next,omitempty"`
+}
+
+// isCanonicalDocumentUid returns true if the id is a valid document uid in its canonical form.
+func isCanonicalDocumentUid(id string) bool {
+	canonical, ok := uid.CanonicalDocumentUid(id)
+	return ok && canonical == id
+}
+
+// presignExpiry is how long a presigned download url remains valid. It only needs to outlive the redirect.
+const presignExpiry = 5 * time.Minute
+
+// maxImportBytes bounds the size of an archive uploaded to the import endpoint.
+const maxImportBytes = 256 << 20
+
+// documentIdFromPath returns the canonical document id from the request path, or writes a 400 response if it isn't a
+// valid document uid so that obviously wrong ids never reach the storage. Non-canonical ids on GET and HEAD requests are
+// redirected to the canonical url so that clients and caches converge on one url per document, while other methods are
+// just normalized.
+func documentIdFromPath(writer http.ResponseWriter, request *http.Request) (string, bool) {
+	id := request.PathValue("id")
+	canonical, ok := uid.CanonicalDocumentUid(id)
+	if !ok {
+		http.Error(writer, fmt.Sprintf("invalid document id '%s'", id), http.StatusBadRequest)
+		return "", false
+	} else if canonical != id && (request.Method == http.MethodGet || request.Method == http.MethodHead) {
+		u := *request.URL
+		u.Path = strings.Replace(u.Path, "/"+id, "/"+canonical, 1)
+		u.RawPath = ""
+		http.Redirect(writer, request, u.String(), http.StatusMovedPermanently)
+		return "", false
+	}
+	return canonical, true
+}
+
+// checkNotTrashed returns true if the document is not in the trash, or writes a 410 response. A trashed document can
+// only be deleted again or restored until it is purged.
+func (s *server) checkNotTrashed(writer http.ResponseWriter, request *http.Request, projectId, documentId string) bool {
+	trashed, err := documents.IsTrashed(request.Context(), s.storage, projectId, documentId)
+	if err != nil {
+		slog.Error("failed to check trash", slog.String("document", documentId), slog.Any("err", err.Error()))
+		http.Error(writer, "failed to check trash", http.StatusInternalServerError)
+		return false
+	} else if trashed {
+		http.Error(writer, "document is trashed", http.StatusGone)
+		return false
+	}
+	return true
+}
+
+// routes registers the document handlers on the mux.
+func (s *server) routes(mux *http.ServeMux) {
+	// A new document only holds its metadata until the first chunk is written.
+	mux.HandleFunc("POST /documents/", func(writer http.ResponseWriter, request *http.Request) {
+		projectId, ok := projectIdFromHeader(writer, request)
+		if !ok {
+			return
+		}
+		documentId := uid.DocumentUid()
+		m, err := documents.CreateMetadata(request.Context(), s.storage, projectId, documentId, request.Header.Get(userIdHeader), time.Now())
+		if err != nil {
+			slog.Error("failed to create document", slog.String("document", documentId), slog.Any("err", err.Error()))
+			http.Error(writer, "failed to create document", http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Header().Set("Location", "/documents/"+documentId)
+		writer.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(writer).Encode(documents.DocumentInfo{Id: documentId, Metadata: *m})
+	})
+
+	mux.HandleFunc("GET /documents/", func(writer http.ResponseWriter, request *http.Request) {
+		projectId, ok := projectIdFromHeader(writer, request)
+		if !ok {
+			return
+		}
+		query := request.URL.Query()
+		limit := defaultListLimit
+		if raw := query.Get("limit"); raw != "" {
+			var err error
+			if limit, err = strconv.Atoi(raw); err != nil || limit < 1 || limit > maxListLimit {
+				http.Error(writer, fmt.Sprintf("invalid limit '%s': expected 1 to %d", raw, maxListLimit), http.StatusBadRequest)
+				return
+			}
+		}
+		opts := storage.ListOptions{Prefix: query.Get("prefix"), StartAfter: query.Get("after")}
+		infos, next, err := documents.ListDocuments(request.Context(), s.storage, projectId, opts, limit)
+		if err != nil {
+			slog.Error("failed to list documents", slog.String("project", projectId), slog.Any("err", err.Error()))
+			http.Error(writer, "failed to list documents", http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(writer).Encode(documentList{Documents: infos, Next: next})
+	})
+
+	mux.HandleFunc("GET /documents/{id}", func(writer http.ResponseWriter, request *http.Request) {
+		projectId, ok := projectIdFromHeader(writer, request)
+		if !ok {
+			return
+		}
+		documentId, ok := documentIdFromPath(writer, request)
+		if !ok {
+			return
+		}
+		if !s.checkNotTrashed(writer, request, projectId, documentId) {
+			return
+		}
+		h, ok := s.loadHistory(writer, request, projectId, documentId)
+		if !ok {
+			return
+		}
+		// Compacted documents are a single blob, so when the storage can presign, the client downloads it directly
+		// rather than through the server.
+		if presigner, ok := s.storage.(storage.BlobPresigner); ok {
+			if chunkId, ok := h.CompactedChunk(); ok {
+				u, err := presigner.PresignGetBlob(projectId, documentId, chunkId, presignExpiry)
+				if err != nil {
+					slog.Error("failed to presign document", slog.String("document", documentId), slog.Any("err", err.Error()))
+					http.Error(writer, "failed to presign document", http.StatusInternalServerError)
+					return
+				}
+				http.Redirect(writer, request, u, http.StatusTemporaryRedirect)
+				return
+			}
+		}
+		// A new document has no chunks until the first change is written, so its state is empty.
+		if len(h.Chunks) == 0 {
+			writer.Header().Set("Content-Type", "application/octet-stream")
+			writer.WriteHeader(http.StatusOK)
+			return
+		}
+		latest, err := h.Latest()
+		if err != nil {
+			writeHistoryError(writer, documentId, err)
+			return
+		}
+		s.writeContent(writer, request, projectId, documentId, h, latest)
+	})
+
+	mux.HandleFunc("DELETE /documents/{id}", func(writer http.ResponseWriter, request *http.Request) {
+		projectId, ok := projectIdFromHeader(writer, request)
+		if !ok {
+			return
+		}
+		documentId, ok := documentIdFromPath(writer, request)
+		if !ok {
+			return
+		}
+		// Deletes only move the document to the trash, the purger removes the blobs once the retention has passed.
+		if err := documents.Trash(request.Context(), s.storage, projectId, documentId, time.Now()); errors.Is(err, storage.ErrDocumentNotFound) {
+			http.Error(writer, "document not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			slog.Error("failed to trash document", slog.String("document", documentId), slog.Any("err", err.Error()))
+			http.Error(writer, "failed to delete document", http.StatusInternalServerError)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /documents/{id}/restore", func(writer http.ResponseWriter, request *http.Request) {
+		projectId, ok := projectIdFromHeader(writer, request)
+		if !ok {
+			return
+		}
+		documentId, ok := documentIdFromPath(writer, request)
+		if !ok {
+			return
+		}
+		if err := documents.Restore(request.Context(), s.storage, projectId, documentId); errors.Is(err, storage.ErrDocumentNotFound) {
+			http.Error(writer, "document not found", http.StatusNotFound)
+			return
+		} else if errors.Is(err, documents.ErrNotTrashed) {
+			http.Error(writer, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			slog.Error("failed to restore document", slog.String("document", documentId), slog.Any("err", err.Error()))
+			http.Error(writer, "failed to restore document", http.StatusInternalServerError)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /documents/{id}/metadata", func(writer http.ResponseWriter, request *http.Request) {
+		projectId, ok := projectIdFromHeader(writer, request)
+		if !ok {
+			return
+		}
+		documentId, ok := documentIdFromPath(writer, request)
+		if !ok {
+			return
+		}
+		if !s.checkNotTrashed(writer, request, projectId, documentId) {
+			return
+		}
+		m, err := documents.GetMetadata(request.Context(), s.storage, projectId, documentId)
+		if errors.Is(err, storage.ErrDocumentNotFound) {
+			http.Error(writer, "document not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			slog.Error("failed to read metadata", slog.String("document", documentId), slog.Any("err", err.Error()))
+			http.Error(writer, "failed to read metadata", http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(writer).Encode(m)
+	})
+
+	mux.HandleFunc("PATCH /documents/{id}", func(writer http.ResponseWriter, request *http.Request) {
+		projectId, ok := projectIdFromHeader(writer, request)
+		if !ok {
+			return
+		}
+		documentId, ok := documentIdFromPath(writer, request)
+		if !ok {
+			return
+		}
+		if !s.checkNotTrashed(writer, request, projectId, documentId) {
+			return
+		}
+		var patch documents.MetadataPatch
+		decoder := json.NewDecoder(http.MaxBytesReader(writer, request.Body, maxMetadataPatchBytes))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&patch); err != nil {
+			http.Error(writer, fmt.Sprintf("invalid request body: %s", err), http.StatusBadRequest)
+			return
+		}
+		m, err := documents.UpdateMetadata(request.Context(), s.storage, projectId, documentId, patch, time.Now())
+		if errors.Is(err, storage.ErrDocumentNotFound) {
+			http.Error(writer, "document not found", http.StatusNotFound)
+			return
+		} else if errors.Is(err, documents.ErrInvalidMetadata) {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			slog.Error("failed to update metadata", slog.String("document", documentId), slog.Any("err", err.Error()))
+			http.Error(writer, "failed to update metadata", http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(writer).Encode(m)
+	})
+
+	mux.HandleFunc("PUT /documents/{id}", func(writer http.ResponseWriter, request *http.Request) {
+		projectId, ok := projectIdFromHeader(writer, request)
+		if !ok {
+			return
+		}
+		documentId, ok := documentIdFromPath(writer, request)
+		if !ok {
+			return
+		}
+		if !s.checkNotTrashed(writer, request, projectId, documentId) {
+			return
+		}
+	})
+
+	mux.HandleFunc("GET /documents/{id}/export", func(writer http.ResponseWriter, request *http.Request) {
+		projectId, ok := projectIdFromHeader(writer, request)
+		if !ok {
+			return
+		}
+		documentId, ok := documentIdFromPath(writer, request)
+		if !ok {
+			return
+		}
+		if !s.checkNotTrashed(writer, request, projectId, documentId) {
+			return
+		}
+		// The document is loaded before anything is written so that errors can still be returned as a status code.
+		doc, err := archive.LoadDocument(request.Context(), s.storage, projectId, documentId)
+		if errors.Is(err, storage.ErrDocumentNotFound) {
+			http.Error(writer, "document not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			slog.Error("failed to load document for export", slog.String("document", documentId), slog.Any("err", err.Error()))
+			http.Error(writer, "failed to load document", http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/x-tar")
+		writer.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.tar"`, documentId))
+		w := archive.NewWriter(writer)
+		if err := errors.Join(w.Add(doc), w.Close()); err != nil {
+			slog.Warn("failed to write export", slog.String("document", documentId), slog.Any("err", err.Error()))
+		}
+	})
+
+	mux.HandleFunc("POST /documents/import", func(writer http.ResponseWriter, request *http.Request) {
+		projectId, ok := projectIdFromHeader(writer, request)
+		if !ok {
+			return
+		}
+		// The upload is bounded, so the whole archive is checked before anything is written. This way a rejected archive
+		// leaves no documents behind.
+		opts := archive.ImportOptions{ProjectId: projectId, ValidDocumentId: isCanonicalDocumentUid, VerifyFirst: true}
+		if raw := request.URL.Query().Get("overwrite"); raw != "" {
+			var err error
+			if opts.Overwrite, err = strconv.ParseBool(raw); err != nil {
+				http.Error(writer, fmt.Sprintf("invalid overwrite '%s'", raw), http.StatusBadRequest)
+				return
+			}
+		}
+		manifests, err := archive.Import(request.Context(), s.storage, http.MaxBytesReader(writer, request.Body, maxImportBytes), opts)
+		// Archives exported with their metadata keep the original creator, otherwise the importer is the creator. This
+		// includes the documents written before a storage error stopped the import.
+		for _, m := range manifests {
+			if _, merr := documents.CreateMetadata(request.Context(), s.storage, m.ProjectId, m.DocumentId, request.Header.Get(userIdHeader), time.Now()); merr != nil {
+				err = errors.Join(err, fmt.Errorf("failed to create metadata of %s: %w", m.DocumentId, merr))
+			}
+		}
+		var maxBytesErr *http.MaxBytesError
+		if errors.Is(err, archive.ErrInvalidArchive) || errors.As(err, &maxBytesErr) {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		} else if errors.Is(err, archive.ErrDocumentExists) {
+			http.Error(writer, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			slog.Error("failed to import archive", slog.Int("imported", len(manifests)), slog.Any("err", err.Error()))
+			http.Error(writer, "failed to import archive", http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(writer).Encode(manifests)
+	})
+
+	s.historyRoutes(mux)
+}
+
+// newHandler wraps the mux in the metrics and tracing middleware. Both read the mux pattern from the request after it
+// has been routed, and the mux can only set it on the request it was given. Tracing replaces the request to carry the
+// span context, so it must be the outer wrapper for metrics to see the same request as the mux.
+func newHandler(registry *metrics.Registry, tracer *tracing.Tracer, mux *http.ServeMux) http.Handler {
+	return tracing.InstrumentHandler(tracer, metrics.InstrumentHandler(registry, mux))
+}
+
+// newTracer returns the tracer for the configured exporter, or nil if tracing is disabled.
+func newTracer(opts *mainOptions) (*tracing.Tracer, error) {
+	if opts.otlpEndpoint != "" {
+		// The exporter uses its own client so that the exports are not traced themselves.
+		return tracing.NewTracer(tracing.NewOtlpExporter(&http.Client{Timeout: 10 * time.Second}, opts.otlpEndpoint)), nil
+	} else if opts.traceFile != "" {
+		f, err := os.OpenFile(opts.traceFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open trace file: %w", err)
+		}
+		return tracing.NewTracer(&closingExporter{Exporter: tracing.NewJsonExporter(f), closer: f}), nil
+	}
+	return nil, nil
+}
+
+// closingExporter closes the underlying file once the exporter has been shut down.
+type closingExporter struct {
+	tracing.Exporter
+	closer io.Closer
+}
+
+func (e *closingExporter) Shutdown(ctx context.Context) error {
+	return errors.Join(e.Exporter.Shutdown(ctx), e.closer.Close())
+}
+
 func mainInner() error {
+	if isCommand(os.Args) {
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer cancel()
+		return runCommand(ctx, os.Args, os.Stdin, os.Stdout, os.Stderr)
+	}
 	opts, err := parseFlags(os.Args)
 	if err != nil {
 		return err
@@ -57,29 +563,46 @@ func mainInner() error {
 		}
 	}()
 
-	mux := http.NewServeMux()
-
-	mux.HandleFunc("POST /documents/", func(writer http.ResponseWriter, request *http.Request) {
-		// generate uid
-		// generate empty doc
-		// Persist doc to storage bucket under uid
-		// cacne doc in memory
-
-	})
-
-	mux.HandleFunc("GET /documents/", func(writer http.ResponseWriter, request *http.Request) {
+	tracer, err := newTracer(opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := tracer.Shutdown(ctx); err != nil {
+			slog.Error("failed to shutdown tracer", slog.Any("err", err.Error()))
+		}
+	}()
 
-	})
+	backend, err := storageurl.Open(context.Background(), opts.storage, storageurl.WithHttpClient(tracing.InstrumentDoer(tracer, http.DefaultClient)))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := backend.Close(); err != nil {
+			slog.Error("failed to close storage", slog.Any("err", err.Error()))
+		}
+	}()
 
-	mux.HandleFunc("DELETE /documents/{id}", func(writer http.ResponseWriter, request *http.Request) {
+	registry := metrics.NewRegistry()
+	srv := &server{
+		storage: traced.New(metered.New(backend.Storage, backend.Name, registry), backend.Name, tracer),
+		backend: backend.Name,
+		started: time.Now(),
+	}
 
-	})
+	purgeCtx, stopPurger := context.WithCancel(context.Background())
+	defer stopPurger()
+	go documents.RunPurger(purgeCtx, srv.storage, opts.trashRetention, opts.purgeInterval)
 
-	mux.HandleFunc("PUT /documents/{id}", func(writer http.ResponseWriter, request *http.Request) {
+	mux := http.NewServeMux()
 
-	})
+	srv.routes(mux)
+	srv.healthRoutes(mux)
+	mux.Handle("GET /metrics", registry)
 
-	server := &http.Server{Handler: mux}
+	server := &http.Server{Handler: newHandler(registry, tracer, mux)}
 	defer func() {
 		if err := server.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
 			slog.Error("failed to close http server", slog.Any("err", err.Error()))
@@ -90,12 +613,34 @@ func mainInner() error {
 
 	sigChannel := make(chan os.Signal, 1)
 	signal.Notify(sigChannel, syscall.SIGINT, syscall.SIGTERM)
+	return srv.serveUntilSignal(server, listener, sigChannel, opts.drainDelay)
+}
+
+// serveUntilSignal serves on the listener until a signal is received, then fails the readiness probe for the drain
+// delay and shuts down gracefully. A second signal interrupts the drain and closes the server immediately.
+func (s *server) serveUntilSignal(server *http.Server, listener net.Listener, signals <-chan os.Signal, drainDelay time.Duration) error {
 	shutdownFinished := make(chan bool)
+	var finishOnce sync.Once
+	finish := func() {
+		finishOnce.Do(func() {
+			close(shutdownFinished)
+		})
+	}
+	skipShutdown := make(chan bool)
 	go func() {
 		slog.Info("waiting for signal to shutdown")
-		sig := <-sigChannel
+		sig := <-signals
 		slog.Info("signal received - shutting down", slog.Any("signal", sig))
+		s.draining.Store(true)
 		go func() {
+			if drainDelay > 0 {
+				slog.Info("draining before shutdown", slog.Duration("delay", drainDelay))
+				select {
+				case <-time.After(drainDelay):
+				case <-skipShutdown:
+					return
+				}
+			}
 			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 			defer cancel()
 			if err := server.Shutdown(ctx); err != nil {
@@ -103,11 +648,19 @@ func mainInner() error {
 			} else {
 				slog.Info("server shut down")
 			}
-			close(shutdownFinished)
+			finish()
 		}()
-		sig = <-sigChannel
+		select {
+		case sig = <-signals:
+		case <-shutdownFinished:
+			return
+		}
 		slog.Warn("second signal received - skipping shut down", slog.Any("signal", sig))
-		close(shutdownFinished)
+		close(skipShutdown)
+		if err := server.Close(); err != nil {
+			slog.Error("failed to close http server", slog.Any("err", err.Error()))
+		}
+		finish()
 	}()
 
 	slog.Info("serving http", slog.String("address", listener.Addr().String()))
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/astromechza/memory-mouse/internal/archive"
+	"github.com/astromechza/memory-mouse/internal/documents"
+	"github.com/astromechza/memory-mouse/internal/metrics"
+	"github.com/astromechza/memory-mouse/internal/storage"
+	"github.com/astromechza/memory-mouse/internal/storage/metered"
+	"github.com/astromechza/memory-mouse/internal/storage/sqlite"
+	"github.com/astromechza/memory-mouse/internal/testsupport"
+	"github.com/astromechza/memory-mouse/internal/tracing"
+	"github.com/astromechza/memory-mouse/internal/uid"
+)
+
+func TestDocumentIdFromPath(t *testing.T) {
+	mux := http.NewServeMux()
+	var seen string
+	mux.HandleFunc("/documents/{id}", func(writer http.ResponseWriter, request *http.Request) {
+		if id, ok := documentIdFromPath(writer, request); ok {
+			seen = id
+		}
+	})
+
+	for _, tc := range []struct {
+		method, path     string
+		expectedStatus   int
+		expectedId       string
+		expectedLocation string
+	}{
+		{http.MethodGet, "/documents/000G40R40M30E209185GR38E", http.StatusOK, "000G40R40M30E209185GR38E", ""},
+		{http.MethodGet, "/documents/not-an-id", http.StatusBadRequest, "", ""},
+		{http.MethodGet, "/documents/000g40r40m30e2O9I85gr38e?x=y", http.StatusMovedPermanently, "", "/documents/000G40R40M30E209185GR38E?x=y"},
+		{http.MethodPut, "/documents/000g40r40m30e2O9I85gr38e", http.StatusOK, "000G40R40M30E209185GR38E", ""},
+	} {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			seen = ""
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+			testsupport.AssertEqual(t, w.Code, tc.expectedStatus)
+			testsupport.AssertEqual(t, seen, tc.expectedId)
+			testsupport.AssertEqual(t, w.Header().Get("Location"), tc.expectedLocation)
+		})
+	}
+}
+
+func TestParseFlags_tracing(t *testing.T) {
+	opts, err := parseFlags([]string{"mm", "-otlp-endpoint", "http://localhost:4318"})
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, opts.otlpEndpoint, "http://localhost:4318")
+	_, err = parseFlags([]string{"mm", "-otlp-endpoint", "http://localhost:4318", "-trace-file", "spans.jsonl"})
+	testsupport.AssertErrorEqual(t, err, "only one of -otlp-endpoint and -trace-file can be set")
+}
+
+func TestServeUntilSignal(t *testing.T) {
+	serve := func(drainDelay time.Duration) (*server, chan os.Signal, chan error) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		testsupport.MustAssertEqual(t, err, nil)
+		srv := new(server)
+		signals := make(chan os.Signal, 2)
+		done := make(chan error, 1)
+		go func() {
+			done <- srv.serveUntilSignal(&http.Server{Handler: http.NotFoundHandler()}, listener, signals, drainDelay)
+		}()
+		return srv, signals, done
+	}
+	wait := func(t *testing.T, done chan error) {
+		select {
+		case err := <-done:
+			testsupport.AssertEqual(t, err, nil)
+		case <-time.After(5 * time.Second):
+			t.Fatal("server did not stop")
+		}
+	}
+
+	t.Run("graceful", func(t *testing.T) {
+		srv, signals, done := serve(time.Millisecond)
+		signals <- syscall.SIGTERM
+		wait(t, done)
+		testsupport.AssertEqual(t, srv.draining.Load(), true)
+	})
+
+	t.Run("second signal interrupts the drain", func(t *testing.T) {
+		srv, signals, done := serve(time.Hour)
+		signals <- syscall.SIGTERM
+		signals <- syscall.SIGINT
+		wait(t, done)
+		testsupport.AssertEqual(t, srv.draining.Load(), true)
+	})
+}
+
+func TestHealthRoutes(t *testing.T) {
+	inner, err := sqlite.New(context.Background(), "file:health.db?mode=memory&cache=shared", 0)
+	testsupport.MustAssertEqual(t, err, nil)
+	registry := metrics.NewRegistry()
+	srv := &server{
+		storage: metered.New(inner, "sqlite", registry),
+		backend: "sqlite",
+		started: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	mux := http.NewServeMux()
+	srv.healthRoutes(mux)
+	get := func(path string) (int, string) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	code, body := get("/healthz")
+	testsupport.AssertEqual(t, code, http.StatusOK)
+	testsupport.AssertEqual(t, body, "ok\n")
+	code, _ = get("/readyz")
+	testsupport.AssertEqual(t, code, http.StatusOK)
+
+	code, body = get("/debug/status")
+	testsupport.AssertEqual(t, code, http.StatusOK)
+	testsupport.AssertEqual(t, body, `{"started":"2024-01-02T03:04:05Z","draining":false,"storageBackend":"sqlite"}`+"\n")
+
+	srv.draining.Store(true)
+	code, body = get("/readyz")
+	testsupport.AssertEqual(t, code, http.StatusServiceUnavailable)
+	testsupport.AssertEqual(t, body, "draining\n")
+	code, _ = get("/healthz")
+	testsupport.AssertEqual(t, code, http.StatusOK)
+
+	srv.draining.Store(false)
+	testsupport.MustAssertEqual(t, inner.Close(), nil)
+	code, body = get("/readyz")
+	testsupport.AssertEqual(t, code, http.StatusServiceUnavailable)
+	testsupport.AssertEqual(t, body, "storage unavailable\n")
+}
+
+func TestExportImportRoutes(t *testing.T) {
+	inner, err := sqlite.New(context.Background(), "file:export.db?mode=memory&cache=shared", 0)
+	testsupport.MustAssertEqual(t, err, nil)
+	defer inner.Close()
+	srv := &server{storage: inner}
+	mux := http.NewServeMux()
+	srv.routes(mux)
+	do := func(method, path, projectId string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		if projectId != "" {
+			req.Header.Set(projectIdHeader, projectId)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	documentId := uid.DocumentUid()
+	testsupport.MustAssertEqual(t, inner.PutBlob(context.Background(), "p1", documentId, "00000000001", nil, []byte("content")), nil)
+
+	rec := do(http.MethodGet, "/documents/"+documentId+"/export", "", nil)
+	testsupport.AssertEqual(t, rec.Code, http.StatusBadRequest)
+	testsupport.AssertEqual(t, rec.Body.String(), "missing X-Project-Id header\n")
+	rec = do(http.MethodGet, "/documents/"+uid.DocumentUid()+"/export", "p1", nil)
+	testsupport.AssertEqual(t, rec.Code, http.StatusNotFound)
+
+	rec = do(http.MethodGet, "/documents/"+documentId+"/export", "p1", nil)
+	testsupport.MustAssertEqual(t, rec.Code, http.StatusOK)
+	testsupport.AssertEqual(t, rec.Header().Get("Content-Type"), "application/x-tar")
+	exported := rec.Body.Bytes()
+
+	rec = do(http.MethodPost, "/documents/import", "p2", exported)
+	testsupport.MustAssertEqual(t, rec.Code, http.StatusOK)
+	buff := new(bytes.Buffer)
+	_, err = inner.GetBlob(context.Background(), "p2", documentId, "00000000001", buff)
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, buff.String(), "content")
+	// The exported document had no metadata, so the import creates it.
+	_, err = inner.HeadBlob(context.Background(), "p2", documentId, documents.MetadataBlobId)
+	testsupport.AssertEqual(t, err, nil)
+
+	rec = do(http.MethodPost, "/documents/import", "p2", exported)
+	testsupport.AssertEqual(t, rec.Code, http.StatusConflict)
+	// A rejected archive writes none of its documents, even those before the one that was rejected.
+	otherId := uid.DocumentUid()
+	testsupport.MustAssertEqual(t, inner.PutBlob(context.Background(), "p1", otherId, "00000000001", nil, []byte("other")), nil)
+	both := new(bytes.Buffer)
+	_, err = archive.Export(context.Background(), inner, both, "p1", otherId, documentId)
+	testsupport.MustAssertEqual(t, err, nil)
+	rec = do(http.MethodPost, "/documents/import", "p2", both.Bytes())
+	testsupport.AssertEqual(t, rec.Code, http.StatusConflict)
+	_, err = inner.ListBlobs(context.Background(), "p2", otherId)
+	testsupport.AssertErrorIs(t, err, storage.ErrDocumentNotFound)
+
+	rec = do(http.MethodPost, "/documents/import?overwrite=true", "p2", exported)
+	testsupport.AssertEqual(t, rec.Code, http.StatusOK)
+	rec = do(http.MethodPost, "/documents/import?overwrite=maybe", "p2", exported)
+	testsupport.AssertEqual(t, rec.Code, http.StatusBadRequest)
+	rec = do(http.MethodPost, "/documents/import", "p2", []byte("not a tar archive"))
+	testsupport.AssertEqual(t, rec.Code, http.StatusBadRequest)
+
+	// documents that the routes can't address are rejected
+	testsupport.MustAssertEqual(t, inner.PutBlob(context.Background(), "p3", "not-a-uid", "00000000001", nil, []byte("x")), nil)
+	archived := new(bytes.Buffer)
+	_, err = archive.Export(context.Background(), inner, archived, "p3")
+	testsupport.MustAssertEqual(t, err, nil)
+	rec = do(http.MethodPost, "/documents/import", "p2", archived.Bytes())
+	testsupport.AssertEqual(t, rec.Code, http.StatusBadRequest)
+	testsupport.AssertEqual(t, rec.Body.String(), "invalid archive: invalid document id 'not-a-uid'\n")
+}
+
+func TestTrashRoutes(t *testing.T) {
+	inner, err := sqlite.New(context.Background(), "file:trash.db?mode=memory&cache=shared", 0)
+	testsupport.MustAssertEqual(t, err, nil)
+	defer inner.Close()
+	srv := &server{storage: inner}
+	mux := http.NewServeMux()
+	srv.routes(mux)
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set(projectIdHeader, "p1")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	documentId := uid.DocumentUid()
+	testsupport.MustAssertEqual(t, inner.PutBlob(context.Background(), "p1", documentId, "00000000001", nil, []byte("content")), nil)
+
+	listIds := func() []string {
+		rec := do(http.MethodGet, "/documents/")
+		testsupport.MustAssertEqual(t, rec.Code, http.StatusOK)
+		var list documentList
+		testsupport.MustAssertEqual(t, json.Unmarshal(rec.Body.Bytes(), &list), nil)
+		ids := make([]string, 0, len(list.Documents))
+		for _, info := range list.Documents {
+			ids = append(ids, info.Id)
+		}
+		return ids
+	}
+
+	testsupport.AssertEqual(t, listIds(), []string{documentId})
+
+	rec := do(http.MethodDelete, "/documents/"+uid.DocumentUid())
+	testsupport.AssertEqual(t, rec.Code, http.StatusNotFound)
+	rec = do(http.MethodPost, "/documents/"+documentId+"/restore")
+	testsupport.AssertEqual(t, rec.Code, http.StatusConflict)
+
+	rec = do(http.MethodDelete, "/documents/"+documentId)
+	testsupport.AssertEqual(t, rec.Code, http.StatusNoContent)
+	testsupport.AssertEqual(t, listIds(), []string{})
+	// The trashed document can't be read or written until it is restored.
+	for _, route := range []struct{ method, path string }{
+		{http.MethodGet, ""},
+		{http.MethodPut, ""},
+		{http.MethodPatch, ""},
+		{http.MethodGet, "/metadata"},
+		{http.MethodGet, "/export"},
+		{http.MethodGet, "/history"},
+		{http.MethodGet, "/content"},
+		{http.MethodPost, "/fork"},
+		{http.MethodPost, "/snapshots"},
+		{http.MethodDelete, "/snapshots/first"},
+	} {
+		rec = do(route.method, "/documents/"+documentId+route.path)
+		testsupport.AssertEqual(t, rec.Code, http.StatusGone)
+	}
+	rec = do(http.MethodDelete, "/documents/"+documentId)
+	testsupport.AssertEqual(t, rec.Code, http.StatusNoContent)
+
+	rec = do(http.MethodPost, "/documents/"+documentId+"/restore")
+	testsupport.AssertEqual(t, rec.Code, http.StatusNoContent)
+	testsupport.AssertEqual(t, listIds(), []string{documentId})
+	rec = do(http.MethodGet, "/documents/"+documentId+"/content")
+	testsupport.AssertEqual(t, rec.Code, http.StatusOK)
+}
+
+// fakePresigner presigns urls on a fake host so that the redirects can be checked without an S3 endpoint. The urls
+// themselves are checked against the fake S3 in the s3 package.
+type fakePresigner struct {
+	storage.BlobStorage
+}
+
+func (f fakePresigner) PresignGetBlob(projectId, documentId, blobId string, expiry time.Duration) (string, error) {
+	return fmt.Sprintf("https://blobs.example/%s/%s/%s?expires=%s", projectId, documentId, blobId, expiry), nil
+}
+
+func TestGetDocument_presigned(t *testing.T) {
+	inner, err := sqlite.New(context.Background(), "file:presign.db?mode=memory&cache=shared", 0)
+	testsupport.MustAssertEqual(t, err, nil)
+	defer inner.Close()
+	do := func(s storage.BlobStorage, path string) *httptest.ResponseRecorder {
+		srv := &server{storage: s}
+		mux := http.NewServeMux()
+		srv.routes(mux)
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set(projectIdHeader, "p1")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	compacted, uncompacted := uid.DocumentUid(), uid.DocumentUid()
+	testsupport.MustAssertEqual(t, inner.PutBlob(context.Background(), "p1", compacted, "00000000002", map[string]string{documents.CompactedFromMetadataKey: "00000000001"}, []byte("ab")), nil)
+	testsupport.MustAssertEqual(t, inner.PutBlob(context.Background(), "p1", uncompacted, "00000000001", nil, []byte("a")), nil)
+	testsupport.MustAssertEqual(t, inner.PutBlob(context.Background(), "p1", uncompacted, "00000000002", nil, []byte("b")), nil)
+
+	rec := do(fakePresigner{inner}, "/documents/"+compacted)
+	testsupport.AssertEqual(t, rec.Code, http.StatusTemporaryRedirect)
+	testsupport.AssertEqual(t, rec.Header().Get("Location"), "https://blobs.example/p1/"+compacted+"/00000000002?expires=5m0s")
+
+	// The presigner is found through the storage decorators.
+	rec = do(metered.New(fakePresigner{inner}, "fake", metrics.NewRegistry()), "/documents/"+compacted)
+	testsupport.AssertEqual(t, rec.Code, http.StatusTemporaryRedirect)
+
+	// Documents that aren't compacted are streamed through the server.
+	rec = do(fakePresigner{inner}, "/documents/"+uncompacted)
+	testsupport.AssertEqual(t, rec.Code, http.StatusOK)
+	testsupport.AssertEqual(t, rec.Body.String(), "ab")
+	testsupport.AssertEqual(t, rec.Header().Get("X-Chunk-Id"), "00000000002")
+	rec = do(fakePresigner{inner}, "/documents/"+uid.DocumentUid())
+	testsupport.AssertEqual(t, rec.Code, http.StatusNotFound)
+}
+
+func TestGetDocument(t *testing.T) {
+	inner, err := sqlite.New(context.Background(), "file:get.db?mode=memory&cache=shared", 0)
+	testsupport.MustAssertEqual(t, err, nil)
+	defer inner.Close()
+	srv := &server{storage: inner}
+	mux := http.NewServeMux()
+	srv.routes(mux)
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set(projectIdHeader, "p1")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	compacted, uncompacted := uid.DocumentUid(), uid.DocumentUid()
+	testsupport.MustAssertEqual(t, inner.PutBlob(context.Background(), "p1", compacted, "00000000002", map[string]string{documents.CompactedFromMetadataKey: "00000000001"}, []byte("ab")), nil)
+	testsupport.MustAssertEqual(t, inner.PutBlob(context.Background(), "p1", uncompacted, "00000000001", nil, []byte("a")), nil)
+	testsupport.MustAssertEqual(t, inner.PutBlob(context.Background(), "p1", uncompacted, "00000000002", nil, []byte("b")), nil)
+
+	// Without a presigner, every document is streamed through the server.
+	for _, id := range []string{compacted, uncompacted} {
+		rec := do(http.MethodGet, "/documents/"+id)
+		testsupport.AssertEqual(t, rec.Code, http.StatusOK)
+		testsupport.AssertEqual(t, rec.Body.String(), "ab")
+		testsupport.AssertEqual(t, rec.Header().Get("Content-Type"), "application/octet-stream")
+		testsupport.AssertEqual(t, rec.Header().Get("X-Chunk-Id"), "00000000002")
+	}
+
+	// A new document has only its metadata, so it is empty.
+	rec := do(http.MethodPost, "/documents/")
+	testsupport.MustAssertEqual(t, rec.Code, http.StatusCreated)
+	rec = do(http.MethodGet, rec.Header().Get("Location"))
+	testsupport.AssertEqual(t, rec.Code, http.StatusOK)
+	testsupport.AssertEqual(t, rec.Body.String(), "")
+	testsupport.AssertEqual(t, rec.Header().Get("X-Chunk-Id"), "")
+
+	rec = do(http.MethodGet, "/documents/"+uid.DocumentUid())
+	testsupport.AssertEqual(t, rec.Code, http.StatusNotFound)
+}
+
+func TestMetadataRoutes(t *testing.T) {
+	inner, err := sqlite.New(context.Background(), "file:metadata.db?mode=memory&cache=shared", 0)
+	testsupport.MustAssertEqual(t, err, nil)
+	defer inner.Close()
+	srv := &server{storage: inner}
+	mux := http.NewServeMux()
+	srv.routes(mux)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+		req.Header.Set(projectIdHeader, "p1")
+		req.Header.Set(userIdHeader, "alice")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/documents/", "")
+	testsupport.MustAssertEqual(t, rec.Code, http.StatusCreated)
+	var created documents.DocumentInfo
+	testsupport.MustAssertEqual(t, json.Unmarshal(rec.Body.Bytes(), &created), nil)
+	documentId := created.Id
+	testsupport.AssertEqual(t, isCanonicalDocumentUid(documentId), true)
+	testsupport.AssertEqual(t, rec.Header().Get("Location"), "/documents/"+documentId)
+	testsupport.AssertEqual(t, created.Creator, "alice")
+	testsupport.AssertEqual(t, created.CreatedAt.IsZero(), false)
+
+	rec = do(http.MethodPatch, "/documents/"+uid.DocumentUid(), `{"title":"x"}`)
+	testsupport.AssertEqual(t, rec.Code, http.StatusNotFound)
+	rec = do(http.MethodPatch, "/documents/"+documentId, `{"creator":"mallory"}`)
+	testsupport.AssertEqual(t, rec.Code, http.StatusBadRequest)
+	rec = do(http.MethodPatch, "/documents/"+documentId, `{"tags":["not valid"]}`)
+	testsupport.AssertEqual(t, rec.Code, http.StatusBadRequest)
+
+	rec = do(http.MethodPatch, "/documents/"+documentId, `{"title":"Notes","tags":["b","a"]}`)
+	testsupport.MustAssertEqual(t, rec.Code, http.StatusOK)
+	rec = do(http.MethodGet, "/documents/"+documentId+"/metadata", "")
+	testsupport.MustAssertEqual(t, rec.Code, http.StatusOK)
+	var m documents.Metadata
+	testsupport.MustAssertEqual(t, json.Unmarshal(rec.Body.Bytes(), &m), nil)
+	testsupport.AssertEqual(t, m.Title, "Notes")
+	testsupport.AssertEqual(t, m.Tags, []string{"a", "b"})
+	testsupport.AssertEqual(t, m.Creator, "alice")
+	testsupport.AssertEqual(t, m.CreatedAt, created.CreatedAt)
+
+	// Documents written before the metadata existed don't get a creator when they are first edited.
+	legacyId := uid.DocumentUid()
+	testsupport.MustAssertEqual(t, inner.PutBlob(context.Background(), "p1", legacyId, "00000000001", nil, []byte("content")), nil)
+	rec = do(http.MethodPatch, "/documents/"+legacyId, `{"title":"Old"}`)
+	testsupport.MustAssertEqual(t, rec.Code, http.StatusOK)
+	var legacy documents.Metadata
+	testsupport.MustAssertEqual(t, json.Unmarshal(rec.Body.Bytes(), &legacy), nil)
+	testsupport.AssertEqual(t, legacy.Creator, "")
+	testsupport.MustAssertEqual(t, do(http.MethodDelete, "/documents/"+legacyId, "").Code, http.StatusNoContent)
+
+	rec = do(http.MethodGet, "/documents/", "")
+	testsupport.MustAssertEqual(t, rec.Code, http.StatusOK)
+	var list documentList
+	testsupport.MustAssertEqual(t, json.Unmarshal(rec.Body.Bytes(), &list), nil)
+	testsupport.AssertEqual(t, list, documentList{Documents: []documents.DocumentInfo{{Id: documentId, Metadata: m}}})
+}
+
+func TestListRoute_paged(t *testing.T) {
+	inner, err := sqlite.New(context.Background(), "file:list.db?mode=memory&cache=shared", 0)
+	testsupport.MustAssertEqual(t, err, nil)
+	defer inner.Close()
+	srv := &server{storage: inner}
+	mux := http.NewServeMux()
+	srv.routes(mux)
+	do := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/documents/"+query, nil)
+		req.Header.Set(projectIdHeader, "p1")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	ids := make([]string, 3)
+	for i := range ids {
+		ids[i] = uid.DocumentUid()
+		testsupport.MustAssertEqual(t, inner.PutBlob(context.Background(), "p1", ids[i], "00000000001", nil, []byte("content")), nil)
+	}
+	slices.Sort(ids)
+
+	rec := do("?limit=2")
+	testsupport.MustAssertEqual(t, rec.Code, http.StatusOK)
+	var list documentList
+	testsupport.MustAssertEqual(t, json.Unmarshal(rec.Body.Bytes(), &list), nil)
+	testsupport.AssertEqual(t, len(list.Documents), 2)
+	testsupport.AssertEqual(t, list.Next, ids[1])
+
+	rec = do("?limit=2&after=" + list.Next)
+	testsupport.MustAssertEqual(t, rec.Code, http.StatusOK)
+	list = documentList{}
+	testsupport.MustAssertEqual(t, json.Unmarshal(rec.Body.Bytes(), &list), nil)
+	testsupport.AssertEqual(t, len(list.Documents), 1)
+	testsupport.AssertEqual(t, list.Documents[0].Id, ids[2])
+	testsupport.AssertEqual(t, list.Next, "")
+
+	rec = do("?prefix=" + ids[0])
+	list = documentList{}
+	testsupport.MustAssertEqual(t, json.Unmarshal(rec.Body.Bytes(), &list), nil)
+	testsupport.AssertEqual(t, len(list.Documents), 1)
+
+	rec = do("?limit=0")
+	testsupport.AssertEqual(t, rec.Code, http.StatusBadRequest)
+	rec = do("?limit=1001")
+	testsupport.AssertEqual(t, rec.Code, http.StatusBadRequest)
+}
+
+func TestNewHandler_routeLabel(t *testing.T) {
+	registry := metrics.NewRegistry()
+	tracer := tracing.NewTracer(tracing.NewJsonExporter(io.Discard))
+	defer tracer.Shutdown(context.Background())
+	srv := &server{started: time.Now()}
+	mux := http.NewServeMux()
+	srv.healthRoutes(mux)
+	handler := newHandler(registry, tracer, mux)
+
+	for _, path := range []string{"/healthz", "/nope"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	requests := registry.NewCounterVec("memorymouse_http_requests_total", "", "route", "method", "code")
+	testsupport.AssertEqual(t, requests.With("GET /healthz", http.MethodGet, "200").Value(), 1.0)
+	testsupport.AssertEqual(t, requests.With("unmatched", http.MethodGet, "404").Value(), 1.0)
+}
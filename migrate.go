@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/astromechza/memory-mouse/internal/storage"
+)
+
+// migration copies the blobs of every selected document from one storage backend to another. Each blob is streamed from
+// the source to the destination and verified by reading it back from the destination and comparing the sha256 checksum
+// and metadata. Blobs that already exist in the destination
+// with the same content and metadata are skipped, so an interrupted migration can simply be run again.
+type migration struct {
+	from, to storage.BlobStorage
+	workers  int
+	dryRun   bool
+	// projects and documents filter the ids to migrate, empty means all.
+	projects  []string
+	documents []string
+	// checkpoint records the completed documents so that a resumed migration doesn't need to check them again, it may
+	// be nil. A document is skipped as a whole once it is recorded, so a catch-up pass after live writes must run
+	// without the checkpoint of the first pass to pick up the chunks and metadata written since then.
+	checkpoint *checkpoint
+}
+
+type migrationResult struct {
+	Documents        int   `json:"documents"`
+	SkippedDocuments int   `json:"skippedDocuments"`
+	FailedDocuments  int   `json:"failedDocuments"`
+	CopiedBlobs      int   `json:"copiedBlobs"`
+	CopiedBytes      int64 `json:"copiedBytes"`
+	SkippedBlobs     int   `json:"skippedBlobs"`
+}
+
+type documentRef struct {
+	projectId, documentId string
+}
+
+func (m *migration) run(ctx context.Context) (*migrationResult, error) {
+	result := new(migrationResult)
+	var lock sync.Mutex
+	var errs []error
+
+	jobs := make(chan documentRef)
+	var wg sync.WaitGroup
+	for range max(1, m.workers) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ref := range jobs {
+				dr, err := m.migrateDocument(ctx, ref)
+				if err == nil && m.checkpoint != nil && !m.dryRun {
+					err = m.checkpoint.add(ref)
+				}
+				lock.Lock()
+				result.Documents++
+				result.CopiedBlobs += dr.CopiedBlobs
+				result.CopiedBytes += dr.CopiedBytes
+				result.SkippedBlobs += dr.SkippedBlobs
+				if err != nil {
+					result.FailedDocuments++
+					errs = append(errs, fmt.Errorf("%s/%s: %w", ref.projectId, ref.documentId, err))
+					slog.Error("failed to migrate document", slog.String("project", ref.projectId), slog.String("document", ref.documentId), slog.Any("err", err.Error()))
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+
+	walkErr := m.walk(ctx, func(ref documentRef) error {
+		if m.checkpoint.has(ref) {
+			lock.Lock()
+			result.SkippedDocuments++
+			lock.Unlock()
+			return nil
+		}
+		select {
+		case jobs <- ref:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(jobs)
+	wg.Wait()
+	if walkErr != nil {
+		return result, fmt.Errorf("failed to list documents: %w", walkErr)
+	} else if len(errs) > 0 {
+		return result, fmt.Errorf("failed to migrate %d documents: %w", len(errs), errors.Join(errs...))
+	}
+	return result, nil
+}
+
+// walk calls the function for each document in the source which matches the filters.
+func (m *migration) walk(ctx context.Context, f func(ref documentRef) error) error {
+	projectIds := m.projects
+	if len(projectIds) == 0 {
+		var err error
+		if projectIds, err = m.from.ListProjectIds(ctx); err != nil {
+			return err
+		}
+	}
+	for _, projectId := range projectIds {
+		documentIds, err := m.from.ListDocumentIds(ctx, projectId)
+		if err != nil {
+			return err
+		}
+		for _, documentId := range documentIds {
+			if len(m.documents) > 0 && !slices.Contains(m.documents, documentId) {
+				continue
+			}
+			if err := f(documentRef{projectId, documentId}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *migration) migrateDocument(ctx context.Context, ref documentRef) (*migrationResult, error) {
+	result := new(migrationResult)
+	blobs, err := m.from.ListBlobs(ctx, ref.projectId, ref.documentId)
+	if errors.Is(err, storage.ErrDocumentNotFound) {
+		// The document was deleted since it was listed.
+		return result, nil
+	} else if err != nil {
+		return result, fmt.Errorf("failed to list blobs: %w", err)
+	}
+	for _, blob := range blobs {
+		if copied, err := m.migrateBlob(ctx, ref, blob); err != nil {
+			return result, fmt.Errorf("blob %s: %w", blob.Id, err)
+		} else if copied {
+			result.CopiedBlobs++
+			result.CopiedBytes += blob.Size
+		} else {
+			result.SkippedBlobs++
+		}
+	}
+	return result, nil
+}
+
+// migrateBlob copies the blob unless the destination already has the same content and metadata and returns whether it
+// was copied. In dry run mode the destination is only checked by size and metadata, and nothing is written.
+func (m *migration) migrateBlob(ctx context.Context, ref documentRef, blob storage.BlobIdAndSize) (bool, error) {
+	existing, err := m.to.HeadBlob(ctx, ref.projectId, ref.documentId, blob.Id)
+	if err != nil && !errors.Is(err, storage.ErrBlobNotFound) && !errors.Is(err, storage.ErrDocumentNotFound) {
+		return false, fmt.Errorf("failed to check destination: %w", err)
+	}
+	src, err := m.from.HeadBlob(ctx, ref.projectId, ref.documentId, blob.Id)
+	if errors.Is(err, storage.ErrBlobNotFound) || errors.Is(err, storage.ErrDocumentNotFound) {
+		// The blob was deleted since it was listed, for example by compaction.
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to check source: %w", err)
+	}
+	// Metadata-only changes, such as a new title or a snapshot, keep the size and content of the blob the same.
+	same := existing != nil && existing.Size == src.Size && maps.Equal(existing.Metadata, src.Metadata)
+	if m.dryRun {
+		if !same {
+			slog.Info("would copy blob", slog.String("project", ref.projectId), slog.String("document", ref.documentId), slog.String("blob", blob.Id), slog.Int64("size", src.Size))
+		}
+		return !same, nil
+	}
+
+	if same {
+		srcSum, _, err := readSum(ctx, m.from, ref, blob.Id)
+		if errors.Is(err, storage.ErrBlobNotFound) || errors.Is(err, storage.ErrDocumentNotFound) {
+			return false, nil
+		} else if err != nil {
+			return false, fmt.Errorf("failed to read source: %w", err)
+		}
+		if dstSum, _, err := readSum(ctx, m.to, ref, blob.Id); err == nil && dstSum == srcSum {
+			return false, nil
+		}
+	}
+	sum, err := m.copyBlob(ctx, ref, blob.Id, src)
+	if errors.Is(err, storage.ErrBlobNotFound) || errors.Is(err, storage.ErrDocumentNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if dstSum, dstMeta, err := readSum(ctx, m.to, ref, blob.Id); err != nil {
+		return false, fmt.Errorf("failed to verify destination: %w", err)
+	} else if dstSum != sum {
+		return false, fmt.Errorf("checksum mismatch after copy: expected %x but got %x", sum, dstSum)
+	} else if !maps.Equal(dstMeta, src.Metadata) {
+		return false, fmt.Errorf("metadata mismatch after copy: expected %v but got %v", src.Metadata, dstMeta)
+	}
+	return true, nil
+}
+
+// copyBlob streams the blob from the source to the destination, so that a destination which implements
+// storage.BlobStreamer never holds the whole blob in memory. It returns the sha256 checksum of the content that was read
+// from the source.
+func (m *migration) copyBlob(ctx context.Context, ref documentRef, blobId string, src *storage.BlobIdSizeAndMeta) ([sha256.Size]byte, error) {
+	pr, pw := io.Pipe()
+	h := sha256.New()
+	read := make(chan error, 1)
+	go func() {
+		blob, err := m.from.GetBlob(ctx, ref.projectId, ref.documentId, blobId, io.MultiWriter(pw, h))
+		if err == nil && !maps.Equal(blob.Metadata, src.Metadata) {
+			err = fmt.Errorf("metadata changed during the copy")
+		}
+		_ = pw.CloseWithError(err)
+		read <- err
+	}()
+	err := storage.PutBlobFromReader(ctx, m.to, ref.projectId, ref.documentId, blobId, src.Metadata, pr, src.Size)
+	// The destination stops reading once it has the expected size, which unblocks the source if it has more.
+	if err == nil {
+		_ = pr.CloseWithError(fmt.Errorf("source is longer than %d bytes", src.Size))
+	} else {
+		_ = pr.CloseWithError(err)
+	}
+	if readErr := <-read; readErr != nil {
+		return [sha256.Size]byte{}, fmt.Errorf("failed to read source: %w", readErr)
+	} else if err != nil {
+		return [sha256.Size]byte{}, fmt.Errorf("failed to write destination: %w", err)
+	}
+	return [sha256.Size]byte(h.Sum(nil)), nil
+}
+
+// readSum reads the blob and returns its sha256 checksum and metadata.
+func readSum(ctx context.Context, s storage.BlobStorage, ref documentRef, blobId string) ([sha256.Size]byte, map[string]string, error) {
+	h := sha256.New()
+	blob, err := s.GetBlob(ctx, ref.projectId, ref.documentId, blobId, h)
+	if err != nil {
+		return [sha256.Size]byte{}, nil, err
+	}
+	return [sha256.Size]byte(h.Sum(nil)), blob.Metadata, nil
+}
+
+// checkpoint is an append-only file with a line per completed document.
+type checkpoint struct {
+	lock sync.Mutex
+	done map[documentRef]bool
+	f    *os.File
+}
+
+func openCheckpoint(path string) (*checkpoint, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint: %w", err)
+	}
+	c := &checkpoint{done: make(map[documentRef]bool), f: f}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if p, d, ok := strings.Cut(scanner.Text(), "\t"); ok {
+			c.done[documentRef{p, d}] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	return c, nil
+}
+
+func (c *checkpoint) has(ref documentRef) bool {
+	if c == nil {
+		return false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.done[ref]
+}
+
+func (c *checkpoint) add(ref documentRef) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, err := fmt.Fprintf(c.f, "%s\t%s\n", ref.projectId, ref.documentId); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	c.done[ref] = true
+	return nil
+}
+
+func (c *checkpoint) Close() error {
+	return c.f.Close()
+}
+
+// stringsFlag is a flag which can be repeated or contain a comma separated list.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(v string) error {
+	for _, part := range strings.Split(v, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			*s = append(*s, part)
+		}
+	}
+	return nil
+}
+
+func migrateCommand(fs *flag.FlagSet) func(ctx context.Context, c *commandEnv) error {
+	from := newStorageFlag(fs, "from", "source storage url")
+	to := newStorageFlag(fs, "to", "destination storage url")
+	m := new(migration)
+	fs.IntVar(&m.workers, "workers", 8, "number of documents to copy in parallel")
+	fs.BoolVar(&m.dryRun, "dry-run", false, "only report the blobs that would be copied")
+	fs.Var((*stringsFlag)(&m.projects), "project", "only migrate these projects, may be repeated or comma separated")
+	fs.Var((*stringsFlag)(&m.documents), "document", "only migrate these documents, may be repeated or comma separated")
+	checkpointPath := fs.String("checkpoint", "", "record completed documents in this file and skip them when the migration is resumed, don't reuse it for a catch-up pass after live writes")
+	return func(ctx context.Context, c *commandEnv) error {
+		if from.url != "" && from.url == to.url {
+			return fmt.Errorf("-from and -to must be different")
+		}
+		src, err := from.open(ctx)
+		if err != nil {
+			return err
+		}
+		defer closeBackend(src)
+		dst, err := to.open(ctx)
+		if err != nil {
+			return err
+		}
+		defer closeBackend(dst)
+		m.from, m.to = src.Storage, dst.Storage
+		if *checkpointPath != "" {
+			if m.checkpoint, err = openCheckpoint(*checkpointPath); err != nil {
+				return err
+			}
+			defer m.checkpoint.Close()
+		}
+		result, err := m.run(ctx)
+		if perr := c.print(result, func() []string {
+			verb := "copied"
+			if m.dryRun {
+				verb = "would copy"
+			}
+			return []string{fmt.Sprintf(
+				"%s %d blobs (%d bytes) and skipped %d existing blobs across %d documents, %d documents skipped by checkpoint, %d failed",
+				verb, result.CopiedBlobs, result.CopiedBytes, result.SkippedBlobs, result.Documents, result.SkippedDocuments, result.FailedDocuments,
+			)}
+		}); perr != nil {
+			return errors.Join(err, perr)
+		}
+		return err
+	}
+}
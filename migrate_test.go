@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/astromechza/memory-mouse/internal/metrics"
+	"github.com/astromechza/memory-mouse/internal/storage"
+	"github.com/astromechza/memory-mouse/internal/storage/metered"
+	"github.com/astromechza/memory-mouse/internal/storage/sqlite"
+	"github.com/astromechza/memory-mouse/internal/storage/sqlitetest"
+	"github.com/astromechza/memory-mouse/internal/testsupport"
+)
+
+func newMigrationSource(t *testing.T) *sqlite.Storage {
+	s := sqlitetest.New(t)
+	for _, ref := range []documentRef{{"p1", "d1"}, {"p1", "d2"}, {"p2", "d3"}} {
+		for _, b := range []string{"00000000001", "00000000002"} {
+			testsupport.MustAssertEqual(t, s.PutBlob(context.Background(), ref.projectId, ref.documentId, b, map[string]string{"k": b}, []byte(ref.documentId+b)), nil)
+		}
+	}
+	return s
+}
+
+func TestMigration(t *testing.T) {
+	src, dst := newMigrationSource(t), sqlitetest.New(t)
+	m := &migration{from: src, to: dst, workers: 2}
+	result, err := m.run(context.Background())
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, *result, migrationResult{Documents: 3, CopiedBlobs: 6, CopiedBytes: 6 * 13})
+
+	buff := new(bytes.Buffer)
+	blob, err := dst.GetBlob(context.Background(), "p2", "d3", "00000000002", buff)
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, buff.String(), "d300000000002")
+	testsupport.AssertEqual(t, blob.Metadata, map[string]string{"k": "00000000002"})
+
+	// running again is a no-op apart from the verification
+	result, err = m.run(context.Background())
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, *result, migrationResult{Documents: 3, SkippedBlobs: 6})
+
+	// a changed blob is copied again
+	testsupport.MustAssertEqual(t, src.PutBlob(context.Background(), "p1", "d1", "00000000001", nil, []byte("different!!!!")), nil)
+	result, err = m.run(context.Background())
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, *result, migrationResult{Documents: 3, CopiedBlobs: 1, CopiedBytes: 13, SkippedBlobs: 5})
+}
+
+func TestMigration_metadata_only_change(t *testing.T) {
+	ctx := context.Background()
+	src, dst := newMigrationSource(t), sqlitetest.New(t)
+	testsupport.MustAssertEqual(t, src.PutBlob(ctx, "p1", "d1", "metadata", map[string]string{"title": "old"}, []byte{}), nil)
+	m := &migration{from: src, to: dst, workers: 1, documents: []string{"d1"}}
+	result, err := m.run(ctx)
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, *result, migrationResult{Documents: 1, CopiedBlobs: 3, CopiedBytes: 26})
+
+	testsupport.MustAssertEqual(t, src.PutBlob(ctx, "p1", "d1", "metadata", map[string]string{"title": "new"}, []byte{}), nil)
+	result, err = (&migration{from: src, to: dst, workers: 1, documents: []string{"d1"}, dryRun: true}).run(ctx)
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, *result, migrationResult{Documents: 1, CopiedBlobs: 1, SkippedBlobs: 2})
+
+	result, err = m.run(ctx)
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, *result, migrationResult{Documents: 1, CopiedBlobs: 1, SkippedBlobs: 2})
+	blob, err := dst.HeadBlob(ctx, "p1", "d1", "metadata")
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, blob.Metadata, map[string]string{"title": "new"})
+}
+
+func TestMigration_dry_run_and_filters(t *testing.T) {
+	src, dst := newMigrationSource(t), sqlitetest.New(t)
+	m := &migration{from: src, to: dst, workers: 1, dryRun: true, projects: []string{"p1"}, documents: []string{"d2"}}
+	result, err := m.run(context.Background())
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, *result, migrationResult{Documents: 1, CopiedBlobs: 2, CopiedBytes: 26})
+	ids, err := dst.ListProjectIds(context.Background())
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, ids, []string{})
+}
+
+func TestMigration_checkpoint(t *testing.T) {
+	src, dst := newMigrationSource(t), sqlitetest.New(t)
+	path := filepath.Join(t.TempDir(), "checkpoint")
+	cp, err := openCheckpoint(path)
+	testsupport.MustAssertEqual(t, err, nil)
+	_, err = (&migration{from: src, to: dst, workers: 4, checkpoint: cp, documents: []string{"d1"}}).run(context.Background())
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.MustAssertEqual(t, cp.Close(), nil)
+	content, _ := os.ReadFile(path)
+	testsupport.AssertEqual(t, string(content), "p1\td1\n")
+
+	cp, err = openCheckpoint(path)
+	testsupport.MustAssertEqual(t, err, nil)
+	defer cp.Close()
+	result, err := (&migration{from: src, to: dst, workers: 4, checkpoint: cp}).run(context.Background())
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, *result, migrationResult{Documents: 2, SkippedDocuments: 1, CopiedBlobs: 4, CopiedBytes: 52})
+}
+
+// corruptingStorage flips the first byte of every blob it writes.
+type corruptingStorage struct {
+	storage.BlobStorage
+}
+
+func (c *corruptingStorage) PutBlob(ctx context.Context, projectId, documentId, blobId string, meta map[string]string, blob []byte) error {
+	blob = bytes.Clone(blob)
+	blob[0] ^= 0xff
+	return c.BlobStorage.PutBlob(ctx, projectId, documentId, blobId, meta, blob)
+}
+
+func TestMigration_checksum_mismatch(t *testing.T) {
+	src, dst := newMigrationSource(t), sqlitetest.New(t)
+	result, err := (&migration{from: src, to: &corruptingStorage{dst}, workers: 1, projects: []string{"p2"}}).run(context.Background())
+	corrupted := []byte("d300000000001")
+	corrupted[0] ^= 0xff
+	testsupport.AssertErrorEqual(t, err, fmt.Sprintf(
+		"failed to migrate 1 documents: p2/d3: blob 00000000001: checksum mismatch after copy: expected %x but got %x",
+		sha256.Sum256([]byte("d300000000001")), sha256.Sum256(corrupted),
+	))
+	testsupport.AssertEqual(t, result.FailedDocuments, 1)
+}
+
+// metadataDroppingStorage writes every blob without its metadata.
+type metadataDroppingStorage struct {
+	storage.BlobStorage
+}
+
+func (c *metadataDroppingStorage) PutBlob(ctx context.Context, projectId, documentId, blobId string, meta map[string]string, blob []byte) error {
+	return c.BlobStorage.PutBlob(ctx, projectId, documentId, blobId, nil, blob)
+}
+
+func TestMigration_metadata_mismatch(t *testing.T) {
+	src, dst := newMigrationSource(t), sqlitetest.New(t)
+	result, err := (&migration{from: src, to: &metadataDroppingStorage{dst}, workers: 1, projects: []string{"p2"}}).run(context.Background())
+	testsupport.AssertErrorEqual(t, err, "failed to migrate 1 documents: p2/d3: blob 00000000001: metadata mismatch after copy: expected map[k:00000000001] but got map[]")
+	testsupport.AssertEqual(t, result.FailedDocuments, 1)
+}
+
+// streamingStorage records the blobs written with PutBlobFromReader and rejects PutBlob.
+type streamingStorage struct {
+	storage.BlobStorage
+	streamed []string
+}
+
+func (s *streamingStorage) PutBlob(ctx context.Context, projectId, documentId, blobId string, meta map[string]string, blob []byte) error {
+	return fmt.Errorf("unexpected PutBlob")
+}
+
+func (s *streamingStorage) PutBlobFromReader(ctx context.Context, projectId, documentId, blobId string, meta map[string]string, src io.Reader, size int64) error {
+	s.streamed = append(s.streamed, blobId)
+	return storage.PutBlobFromReader(ctx, s.BlobStorage, projectId, documentId, blobId, meta, src, size)
+}
+
+func TestMigration_streaming(t *testing.T) {
+	src, dst := newMigrationSource(t), sqlitetest.New(t)
+	streaming := &streamingStorage{BlobStorage: dst}
+	registry := metrics.NewRegistry()
+	// The streamer must be reachable through the decorators.
+	to := metered.New(streaming, "sqlite", registry)
+	result, err := (&migration{from: src, to: to, workers: 1, projects: []string{"p2"}}).run(context.Background())
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, *result, migrationResult{Documents: 1, CopiedBlobs: 2, CopiedBytes: 26})
+	testsupport.AssertEqual(t, streaming.streamed, []string{"00000000001", "00000000002"})
+	operations := registry.NewCounterVec("memorymouse_storage_operations_total", "", "backend", "method", "result")
+	testsupport.AssertEqual(t, operations.With("sqlite", "PutBlobFromReader", "ok").Value(), 2.0)
+
+	buff := new(bytes.Buffer)
+	blob, err := dst.GetBlob(context.Background(), "p2", "d3", "00000000002", buff)
+	testsupport.MustAssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, buff.String(), "d300000000002")
+	testsupport.AssertEqual(t, blob.Metadata, map[string]string{"k": "00000000002"})
+}
+
+func TestMigrateCommand(t *testing.T) {
+	from := newCommandStorage(t)
+	to := "sqlite:file:" + t.Name() + "-to.db?mode=memory&cache=shared"
+	stdout, _, err := runTestCommand("migrate", "-from", from, "-to", to)
+	testsupport.AssertEqual(t, err, nil)
+	testsupport.AssertEqual(t, stdout, "copied 1 blobs (5 bytes) and skipped 0 existing blobs across 1 documents, 0 documents skipped by checkpoint, 0 failed\n")
+	_, _, err = runTestCommand("migrate", "-from", from, "-to", from)
+	testsupport.AssertErrorEqual(t, err, "-from and -to must be different")
+	_, _, err = runTestCommand("migrate", "-from", from)
+	testsupport.AssertErrorEqual(t, err, "-to is required")
+}